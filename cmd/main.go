@@ -1,80 +1,162 @@
-// main.go
+// noteapi runs the API server and the administrative commands that share its
+// configuration and database. Build it with `go build -o noteapi ./cmd`.
+//
+//	noteapi [serve]                      run the API server
+//	noteapi migrate up | down [N] | status
+//	noteapi export-user [-o file] <user-id>
+//	noteapi import-user [-user id] [-new-ids] [file]
+//	noteapi purge-trash -older-than 30d [-dry-run]
+//	noteapi gc-uploads [-older-than 24h] [-dry-run]
+//	noteapi reindex-search
+//	noteapi create-api-token -user id -name name -scopes s1,s2 [-expires 90d]
+//	noteapi rotate-keys
+//
+// Every command reads the same environment (and .env file) as the server.
 package main
 
 import (
-	"NoteApi/cmd/websocket"
+	"NoteApi/internal/config"
 	"NoteApi/internal/database"
-	"NoteApi/internal/handlers"
-	"NoteApi/internal/middleware"
+	"NoteApi/internal/envelope"
+	"NoteApi/internal/logging"
+	"NoteApi/internal/models"
+	"NoteApi/internal/storage"
 	"NoteApi/pkg/utils"
-	"github.com/gin-contrib/cors"
-	"github.com/gin-gonic/gin"
+	"context"
+	"errors"
+	"fmt"
+	"gorm.io/gorm/logger"
+	"io"
 	"log"
-	"net/http"
+	"os"
+	"strconv"
+	"strings"
 	"time"
 )
 
-func init() {
-	utils.LoadEnv()
-	database.ConnectToDb()
-	database.SyncDatabase()
+type command struct {
+	name     string
+	synopsis string
+	summary  string
+	run      func(ctx context.Context, cfg config.Config, args []string) error
 }
 
-func main() {
-	gin.SetMode(gin.ReleaseMode)
-	r := gin.Default()
+// errUsage makes main print the command's synopsis and exit with status 2.
+var errUsage = errors.New("invalid arguments")
 
-	if err := r.SetTrustedProxies([]string{"127.0.0.1"}); err != nil {
-		panic(err)
+func commands() []command {
+	return []command{
+		{"serve", "", "run the API server (the default)", serve},
+		{"migrate", "up | down [N] | status", "apply or roll back schema migrations", migrate},
+		{"export-user", "[-o file] <user-id>", "write a user's personal notes and uploads as JSON", exportUser},
+		{"import-user", "[-user id] [-new-ids] [file]", "restore an export-user archive", importUser},
+		{"purge-trash", "-older-than 30d [-dry-run]", "delete notes trashed before the cutoff", purgeTrash},
+		{"gc-uploads", "[-older-than 24h] [-dry-run]", "remove stored files nothing refers to", gcUploads},
+		{"reindex-search", "", "rebuild the note full-text search index", reindexSearch},
+		{"create-api-token", "-user id -name name -scopes s1,s2 [-expires 90d]", "issue a personal API token for a user", createAPIToken},
+		{"rotate-keys", "", "re-wrap note data keys with the current master key", rotateKeys},
 	}
+}
 
-	config := cors.Config{
-		AllowOrigins: []string{
-			"https://note-taking-dusky.vercel.app",
-			"https://userauthapi-i77f.onrender.com",
-			"https://noteapi-rw35.onrender.com",
-		},
-		AllowMethods:     []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
-		AllowHeaders:     []string{"Origin", "Content-Type", "Accept", "Authorization"},
-		ExposeHeaders:    []string{"Content-Length", "Content-Type"},
-		AllowCredentials: false,
-		MaxAge:           12 * time.Hour,
+func main() {
+	name, args := "serve", os.Args[1:]
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		name, args = args[0], args[1:]
+	}
+	var cmd command
+	for _, candidate := range commands() {
+		if candidate.name == name {
+			cmd = candidate
+		}
+	}
+	if cmd.run == nil {
+		usage()
 	}
 
-	r.Use(cors.New(config))
+	utils.LoadEnv()
+	cfg, err := config.Load()
+	if err == nil && cmd.name == "serve" {
+		err = cfg.ValidateServe()
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
 
-	// Serve static files from the uploads directory
-	r.Static("/uploads", "../cmd/uploads")
+	// Commands other than serve print their results on stdout, so their logs
+	// go to stderr
+	var logOutput io.Writer = os.Stderr
+	if cmd.name == "serve" {
+		logOutput = os.Stdout
+	}
+	if err := logging.Setup(cfg.Log.Level, logOutput); err != nil {
+		log.Fatal(err)
+	}
 
-	// Health check route
-	r.GET("/health", func(c *gin.Context) {
-		c.JSON(http.StatusOK, gin.H{"status": "ok"})
-	})
+	database.ConnectToDb(cfg.Database)
+	if cmd.name != "serve" {
+		database.DB.Logger = logger.New(log.Default(), logger.Config{SlowThreshold: 200 * time.Millisecond, LogLevel: logger.Warn})
+	}
+	storage.SetRoot(cfg.Uploads.Dir)
 
-	// Note routes
-	r.POST("/notes", middleware.CheckAuthenticated(), handlers.CreateNote)
-	r.GET("/notes/:id", middleware.CheckAuthenticated(), handlers.GetNote)
-	r.PUT("/notes/:id", middleware.CheckAuthenticated(), handlers.UpdateNote)
-	r.DELETE("/notes/:id", middleware.CheckAuthenticated(), handlers.DeleteNote)
-	r.GET("/notes", middleware.CheckAuthenticated(), handlers.ListNotes)
+	err = cmd.run(context.Background(), cfg, args)
+	if closeErr := database.Close(); closeErr != nil {
+		log.Printf("Failed to close database: %v", closeErr)
+	}
+	if errors.Is(err, errUsage) {
+		fmt.Fprintf(os.Stderr, "usage: noteapi %s %s\n", cmd.name, cmd.synopsis)
+		os.Exit(2)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: noteapi <command> [arguments]\n\ncommands:")
+	for _, cmd := range commands() {
+		fmt.Fprintf(os.Stderr, "  %-18s %s\n", cmd.name, cmd.summary)
+	}
+	os.Exit(2)
+}
 
-	// Image upload route
-	r.POST("/upload", middleware.CheckAuthenticated(), handlers.UploadImage)
+// openNotes checks that the schema matches this build and, when a master key
+// is configured, installs the keyring that encrypts note titles and contents.
+// Every command that reads or writes notes needs both.
+func openNotes(ctx context.Context, cfg config.NotesConfig) (*envelope.Keyring, error) {
+	if _, err := database.CheckSchemaVersion(database.DB.WithContext(ctx)); err != nil {
+		return nil, err
+	}
+	keyring, err := envelope.ParseKeyring(database.DB, cfg.EncryptionKey, cfg.PreviousEncryptionKeys)
+	if err != nil {
+		return nil, fmt.Errorf("invalid note encryption configuration: %w", err)
+	}
+	if keyring != nil {
+		models.NoteCipher = keyring
+	}
+	return keyring, nil
+}
 
-	// WebSocket route
-	r.GET("/ws", middleware.CheckAuthenticated(), func(c *gin.Context) {
-		websocket.HandleConnections(c)
-	})
+// age is a duration flag that also takes whole days ("30d"), the unit
+// retention periods are usually given in.
+type age time.Duration
 
-	go websocket.HandleMessages()
+func (a *age) String() string {
+	return time.Duration(*a).String()
+}
 
-	// Ensure the uploads directory exists
-	if err := utils.EnsureDir("uploads"); err != nil {
-		log.Fatalf("failed to create uploads directory: %v", err)
+func (a *age) Set(value string) error {
+	if days, ok := strings.CutSuffix(value, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil || n < 0 {
+			return fmt.Errorf("%q is not a number of days", value)
+		}
+		*a = age(time.Duration(n) * 24 * time.Hour)
+		return nil
 	}
-
-	// Start the server
-	if err := r.Run(); err != nil {
-		log.Fatalf("failed to run server: %v", err)
+	parsed, err := time.ParseDuration(value)
+	if err != nil {
+		return err
 	}
+	*a = age(parsed)
+	return nil
 }
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"NoteApi/internal/audit"
+	"NoteApi/internal/config"
+	"NoteApi/internal/database"
+	"NoteApi/internal/repository"
+	"NoteApi/internal/storage"
+	"NoteApi/internal/trash"
+	"NoteApi/internal/workspace"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"time"
+)
+
+// purgeTrash permanently deletes notes that have been in the trash for longer
+// than -older-than, along with the files only they used.
+func purgeTrash(ctx context.Context, cfg config.Config, args []string) error {
+	flags := flag.NewFlagSet("purge-trash", flag.ContinueOnError)
+	var olderThan age
+	flags.Var(&olderThan, "older-than", "purge notes trashed longer ago than this (such as 30d or 12h)")
+	dryRun := flags.Bool("dry-run", false, "only count the notes that would be purged")
+	if err := flags.Parse(args); err != nil || flags.NArg() > 0 || olderThan <= 0 {
+		return errUsage
+	}
+	if _, err := database.CheckSchemaVersion(database.DB.WithContext(ctx)); err != nil {
+		return err
+	}
+	cutoff := time.Now().Add(-time.Duration(olderThan))
+
+	if *dryRun {
+		count, err := trash.Count(database.DB.WithContext(ctx), cutoff)
+		if err != nil {
+			return err
+		}
+		log.Printf("Would purge %d notes trashed before %s", count, cutoff.Format(time.RFC3339))
+		return nil
+	}
+
+	purged, err := trash.Purge(ctx, database.DB, repository.NewPostgres(database.DB), cutoff, audit.CommandActor("purge-trash"))
+	log.Printf("Purged %d notes trashed before %s", purged, cutoff.Format(time.RFC3339))
+	return err
+}
+
+// gcUploads repairs blob reference counts and removes stored files nothing
+// refers to; see storage.GC.
+func gcUploads(ctx context.Context, cfg config.Config, args []string) error {
+	flags := flag.NewFlagSet("gc-uploads", flag.ContinueOnError)
+	olderThan := age(24 * time.Hour)
+	flags.Var(&olderThan, "older-than", "leave files modified more recently than this alone")
+	dryRun := flags.Bool("dry-run", false, "only report what would be removed")
+	if err := flags.Parse(args); err != nil || flags.NArg() > 0 {
+		return errUsage
+	}
+	if _, err := database.CheckSchemaVersion(database.DB.WithContext(ctx)); err != nil {
+		return err
+	}
+
+	report, err := storage.GC(ctx, database.DB, time.Duration(olderThan), *dryRun)
+	removed, fixed := "Removed", "fixed"
+	if *dryRun {
+		removed, fixed = "Would remove", "would fix"
+	}
+	log.Printf("%s %d unreferenced blobs, %d orphaned blob files, %d stale resumable uploads and %d temporary files (%d bytes); %s %d reference counts",
+		removed, report.UnreferencedBlobs, report.OrphanedFiles, report.StaleTusUploads, report.StaleTempFiles, report.BytesFreed, fixed, report.RefCountsFixed)
+	return err
+}
+
+// reindexSearch rebuilds the index workspace search uses.
+func reindexSearch(ctx context.Context, cfg config.Config, args []string) error {
+	if len(args) > 0 {
+		return errUsage
+	}
+	if _, err := database.CheckSchemaVersion(database.DB.WithContext(ctx)); err != nil {
+		return err
+	}
+
+	start := time.Now()
+	err := workspace.ReindexSearch(database.DB.WithContext(ctx))
+	if errors.Is(err, workspace.ErrSearchNotIndexed) {
+		log.Printf("Nothing to do: %v", err)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	log.Printf("Rebuilt the search index in %s", time.Since(start).Round(time.Millisecond))
+	return nil
+}
+
+// rotateKeys re-wraps every user's note encryption data key with the current
+// master key. Deploy the new key as NOTE_ENCRYPTION_KEY with the old one in
+// NOTE_ENCRYPTION_PREVIOUS_KEYS, run this, then drop the old key.
+func rotateKeys(ctx context.Context, cfg config.Config, args []string) error {
+	if len(args) > 0 {
+		return errUsage
+	}
+	keyring, err := openNotes(ctx, cfg.Notes)
+	if err != nil {
+		return err
+	}
+	if keyring == nil {
+		return fmt.Errorf("notes.encryption_key (NOTE_ENCRYPTION_KEY) is not set")
+	}
+
+	rotated, err := keyring.Rotate()
+	if err != nil {
+		return fmt.Errorf("rotated %d data keys before failing: %w", rotated, err)
+	}
+	log.Printf("Rotated %d data keys", rotated)
+	return nil
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"NoteApi/internal/config"
+	"NoteApi/internal/database"
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+)
+
+// migrate applies or rolls back the versioned schema migrations embedded in
+// the database package.
+//
+//	migrate up         apply every pending migration
+//	migrate down [N]   roll back the latest N migrations (default 1)
+//	migrate status     list migrations and when they were applied
+func migrate(ctx context.Context, cfg config.Config, args []string) error {
+	if len(args) == 0 {
+		return errUsage
+	}
+
+	switch args[0] {
+	case "up":
+		applied, err := database.MigrateUp(ctx)
+		for _, migration := range applied {
+			log.Printf("Applied %d_%s", migration.Version, migration.Name)
+		}
+		if err != nil {
+			return err
+		}
+		if len(applied) == 0 {
+			log.Println("Schema is up to date")
+		}
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			var err error
+			steps, err = strconv.Atoi(args[1])
+			if err != nil || steps < 1 {
+				return errUsage
+			}
+		}
+		rolledBack, err := database.MigrateDown(ctx, steps)
+		for _, migration := range rolledBack {
+			log.Printf("Rolled back %d_%s", migration.Version, migration.Name)
+		}
+		if err != nil {
+			return err
+		}
+	case "status":
+		states, err := database.MigrationStatus(ctx)
+		if err != nil {
+			return err
+		}
+		for _, state := range states {
+			applied := "pending"
+			if state.AppliedAt != nil {
+				applied = "applied " + state.AppliedAt.Format("2006-01-02 15:04:05")
+			}
+			if state.Up == "" {
+				applied += " (unknown to this build)"
+			}
+			fmt.Printf("%04d_%s\t%s\n", state.Version, state.Name, applied)
+		}
+	default:
+		return errUsage
+	}
+	return nil
+}
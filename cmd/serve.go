@@ -0,0 +1,203 @@
+package main
+
+import (
+	"NoteApi/cmd/websocket"
+	"NoteApi/internal/auth"
+	"NoteApi/internal/config"
+	"NoteApi/internal/database"
+	"NoteApi/internal/handlers"
+	"NoteApi/internal/metrics"
+	"NoteApi/internal/middleware"
+	"NoteApi/internal/quota"
+	"NoteApi/internal/ratelimit"
+	"NoteApi/internal/repository"
+	"NoteApi/internal/storage"
+	"NoteApi/internal/tracing"
+	"NoteApi/pkg/utils"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"log"
+	"net/http"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// serve runs the API server until SIGINT or SIGTERM, then drains requests and
+// background workers.
+func serve(ctx context.Context, cfg config.Config, args []string) error {
+	if len(args) > 0 {
+		return errUsage
+	}
+
+	shutdownTracing, err := tracing.Setup(ctx, cfg.Tracing)
+	if err != nil {
+		return fmt.Errorf("invalid tracing configuration: %w", err)
+	}
+	if err := tracing.RegisterGORM(database.DB); err != nil {
+		return fmt.Errorf("failed to trace database queries: %w", err)
+	}
+
+	if cfg.Database.MigrateOnStart {
+		applied, err := database.MigrateUp(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to migrate database: %w", err)
+		}
+		for _, migration := range applied {
+			log.Printf("Applied migration %d_%s", migration.Version, migration.Name)
+		}
+	}
+	// Encrypt note titles and contents at rest when a master key is configured
+	if _, err := openNotes(ctx, cfg.Notes); err != nil {
+		return fmt.Errorf("refusing to start: %w (run `noteapi migrate up` or set DB_MIGRATE_ON_START)", err)
+	}
+	if sqlDB, err := database.DB.DB(); err == nil {
+		metrics.RegisterDB(sqlDB, cfg.Database.Driver)
+	}
+	utils.SetSigningKey([]byte(cfg.Uploads.SigningSecret))
+	utils.SetSignedURLTTL(time.Duration(cfg.Uploads.URLTTL))
+	storage.SetAllowedTypes(allowedTypes(cfg.Uploads.AllowedTypes))
+	quota.SetLimits(cfg.Quota.MaxBytes, cfg.Quota.MaxNotes)
+	websocket.Configure(cfg.WebSocketOrigins(), time.Duration(cfg.WebSocket.AuthTimeout))
+	auth.SetTicketTTL(time.Duration(cfg.WebSocket.TicketTTL))
+	middleware.SetAllowQueryToken(cfg.WebSocket.AllowQueryToken)
+	if err := auth.SetDefaultJWTScopes(cfg.Auth.DefaultScopes); err != nil {
+		return fmt.Errorf("refusing to start: %w", err)
+	}
+	verifier, err := auth.NewVerifier(auth.VerifierConfig{
+		HMACSecret:      cfg.Auth.JWTSecret,
+		HMACUntil:       cfg.Auth.JWTHMACUntil,
+		JWKSSource:      cfg.Auth.JWKSSource,
+		RefreshInterval: time.Duration(cfg.Auth.JWKSRefreshInterval),
+		Issuer:          cfg.Auth.Issuer,
+		Audience:        cfg.Auth.Audience,
+		ClockSkew:       time.Duration(cfg.Auth.ClockSkew),
+	})
+	if err != nil {
+		return fmt.Errorf("refusing to start: %w", err)
+	}
+	auth.SetDefault(verifier)
+
+	// Background workers run until the server shuts down
+	workers, stopWorkers := context.WithCancel(ctx)
+	defer stopWorkers()
+	var workerGroup sync.WaitGroup
+	startWorker := func(run func(ctx context.Context)) {
+		workerGroup.Add(1)
+		go func() {
+			defer workerGroup.Done()
+			run(workers)
+		}()
+	}
+
+	// Forget jti revocations once the tokens they deny have expired
+	startWorker(func(ctx context.Context) { auth.PruneRevokedTokensLoop(ctx, database.DB, time.Hour) })
+
+	// Rate limits are shared between instances when backed by Postgres
+	limiterStore, err := ratelimit.NewStore(cfg.RateLimit.Store, database.DB)
+	if err != nil {
+		return fmt.Errorf("failed to set up rate limiting: %w", err)
+	}
+	if pgStore, ok := limiterStore.(*ratelimit.PostgresStore); ok {
+		startWorker(func(ctx context.Context) { pgStore.PruneLoop(ctx, time.Hour, cfg.RateLimit.LongestPeriod()) })
+	}
+
+	// Prometheus metrics, either on their own listener or behind a token on
+	// the main router
+	var metricsSrv *http.Server
+	switch {
+	case cfg.Metrics.Addr != "":
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", metrics.Handler(cfg.Metrics.Token))
+		metricsSrv = &http.Server{Addr: cfg.Metrics.Addr, Handler: mux}
+	case cfg.Metrics.Token == "":
+		log.Println("Metrics disabled; set METRICS_ADDR or METRICS_TOKEN to expose /metrics")
+	}
+
+	repo := repository.NewPostgres(database.DB)
+	server := &handlers.Server{
+		Config:     cfg,
+		Notes:      repo,
+		Workspaces: repo,
+		Accounts:   repo,
+		TusUploads: repo,
+		Database:   repo,
+		Events:     websocket.Publisher{},
+		Limiter:    limiterStore,
+		WebSocket:  websocket.HandleConnections,
+	}
+	gin.SetMode(gin.ReleaseMode)
+	r, err := server.Router()
+	if err != nil {
+		return fmt.Errorf("failed to build router: %w", err)
+	}
+
+	startWorker(websocket.HandleMessages)
+
+	// Ensure the uploads directory exists
+	if err := utils.EnsureDir(cfg.Uploads.Dir); err != nil {
+		return fmt.Errorf("failed to create uploads directory: %w", err)
+	}
+
+	// Start the server and wait for SIGINT or SIGTERM
+	signals, stopSignals := signal.NotifyContext(ctx, syscall.SIGINT, syscall.SIGTERM)
+	defer stopSignals()
+
+	srv := &http.Server{Addr: cfg.Server.Addr(), Handler: r}
+	serverErr := make(chan error, 1)
+	go func() {
+		serverErr <- srv.ListenAndServe()
+	}()
+	log.Printf("Listening on %s", srv.Addr)
+
+	if metricsSrv != nil {
+		go func() {
+			if err := metricsSrv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				serverErr <- err
+			}
+		}()
+		log.Printf("Serving metrics on %s", metricsSrv.Addr)
+	}
+
+	select {
+	case err := <-serverErr:
+		return fmt.Errorf("failed to run server: %w", err)
+	case <-signals.Done():
+	}
+
+	// Stop accepting connections and let in-flight requests finish. Websockets
+	// are hijacked, so srv.Shutdown does not track them; close them first.
+	log.Printf("Shutting down, waiting up to %s for requests to finish", time.Duration(cfg.Server.ShutdownTimeout))
+	shutdown, cancel := context.WithTimeout(context.Background(), time.Duration(cfg.Server.ShutdownTimeout))
+	defer cancel()
+
+	websocket.Shutdown()
+	if err := srv.Shutdown(shutdown); err != nil {
+		log.Printf("Requests still running at shutdown: %v", err)
+	}
+	if metricsSrv != nil {
+		metricsSrv.Shutdown(shutdown)
+	}
+
+	stopWorkers()
+	workerGroup.Wait()
+	auth.CloseDefault()
+	if err := shutdownTracing(shutdown); err != nil {
+		log.Printf("Failed to flush traces: %v", err)
+	}
+	log.Println("Server stopped")
+	return nil
+}
+
+// allowedTypes converts the configured upload allowlist for the storage
+// package; empty keeps its defaults.
+func allowedTypes(rules []config.TypeRule) []storage.TypeRule {
+	converted := make([]storage.TypeRule, len(rules))
+	for i, rule := range rules {
+		converted[i] = storage.TypeRule{Pattern: rule.Pattern, MaxSize: rule.MaxSize}
+	}
+	return converted
+}
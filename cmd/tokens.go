@@ -0,0 +1,58 @@
+package main
+
+import (
+	"NoteApi/internal/auth"
+	"NoteApi/internal/config"
+	"NoteApi/internal/database"
+	"context"
+	"flag"
+	"fmt"
+	"github.com/google/uuid"
+	"log"
+	"strings"
+	"time"
+)
+
+// createAPIToken issues a personal API token for a user, as POST /me/tokens
+// does, and prints it on stdout. The token cannot be shown again.
+func createAPIToken(ctx context.Context, cfg config.Config, args []string) error {
+	flags := flag.NewFlagSet("create-api-token", flag.ContinueOnError)
+	user := flags.String("user", "", "ID of the user the token acts as")
+	name := flags.String("name", "", "name shown in the user's token list")
+	scopeList := flags.String("scopes", "", "comma-separated scopes: "+strings.Join(auth.AllScopes, ", "))
+	var expires age
+	flags.Var(&expires, "expires", "expire the token after this long (such as 90d); never by default")
+	if err := flags.Parse(args); err != nil || flags.NArg() > 0 || *user == "" || *name == "" || *scopeList == "" {
+		return errUsage
+	}
+
+	userID, err := uuid.Parse(*user)
+	if err != nil {
+		return fmt.Errorf("invalid user ID %q", *user)
+	}
+	if len(*name) > 100 {
+		return fmt.Errorf("name must be at most 100 characters")
+	}
+	scopes := strings.FieldsFunc(*scopeList, func(r rune) bool { return r == ',' || r == ' ' })
+	for _, scope := range scopes {
+		if !auth.IsKnownScope(scope) {
+			return fmt.Errorf("unknown scope %q (known scopes: %s)", scope, strings.Join(auth.AllScopes, ", "))
+		}
+	}
+	var expiresAt *time.Time
+	if expires > 0 {
+		at := time.Now().Add(time.Duration(expires))
+		expiresAt = &at
+	}
+	if _, err := database.CheckSchemaVersion(database.DB.WithContext(ctx)); err != nil {
+		return err
+	}
+
+	plaintext, token, err := auth.CreateAPIToken(database.DB.WithContext(ctx), userID, *name, scopes, expiresAt)
+	if err != nil {
+		return err
+	}
+	log.Printf("Created API token %s (%s) for user %s with scopes %s", token.ID, token.Prefix, userID, token.Scopes)
+	fmt.Println(plaintext)
+	return nil
+}
@@ -0,0 +1,40 @@
+package websocket
+
+import (
+	"NoteApi/internal/models"
+	"NoteApi/internal/quota"
+	"context"
+	"github.com/google/uuid"
+	"time"
+)
+
+// Publisher delivers handler events to the connections on this instance.
+type Publisher struct{}
+
+func (Publisher) NoteUpdated(ctx context.Context, note models.Note, userID uuid.UUID) {
+	BroadcastNoteUpdateToUser(ctx, note, userID)
+}
+
+func (Publisher) NoteDeleted(ctx context.Context, noteID, userID uuid.UUID) {
+	BroadcastNoteDeleteToUser(ctx, noteID, userID)
+}
+
+func (Publisher) NoteListChanged(ctx context.Context, notes []models.Note, userID uuid.UUID) {
+	BroadcastNoteListToUser(ctx, notes, userID)
+}
+
+func (Publisher) QuotaWarning(ctx context.Context, usage quota.Usage, userID uuid.UUID) {
+	BroadcastQuotaWarningToUser(ctx, usage, userID)
+}
+
+func (Publisher) TokenRevoked(userID uuid.UUID, tokenID string) {
+	CloseToken(userID, tokenID)
+}
+
+func (Publisher) TokensRevokedBefore(userID uuid.UUID, cutoff time.Time) {
+	CloseIssuedBefore(userID, cutoff)
+}
+
+func (Publisher) Health(ctx context.Context) (int, error) {
+	return Health(ctx)
+}
@@ -1,136 +1,252 @@
 package websocket
 
 import (
+	"NoteApi/internal/auth"
+	"NoteApi/internal/database"
+	"NoteApi/internal/metrics"
+	"NoteApi/internal/middleware"
 	"NoteApi/internal/models"
+	"NoteApi/internal/tracing"
+	"NoteApi/pkg/utils"
+	"context"
+	"errors"
 	"github.com/gin-gonic/gin"
-	"github.com/golang-jwt/jwt/v5" // Import JWT library
 	"github.com/google/uuid"
 	"github.com/gorilla/websocket"
-	"log"
+	"go.opentelemetry.io/otel/attribute"
+	"gorm.io/gorm"
+	"log/slog"
+	"math/rand"
 	"net/http"
-	"os"
 	"sync"
+	"time"
 )
 
 var upgrader = websocket.Upgrader{
 	ReadBufferSize:  1024,
 	WriteBufferSize: 1024,
-	CheckOrigin: func(r *http.Request) bool {
-		return true
-	},
+	CheckOrigin:     checkOrigin,
+}
+
+var (
+	// allowedOrigins may open connections; set by Configure.
+	allowedOrigins []string
+	// authTimeout bounds the wait for the first frame; set by Configure.
+	authTimeout = DefaultAuthTimeout
+)
+
+// Configure sets the browser origins allowed to open websocket connections
+// and how long a connection may take to authenticate.
+func Configure(origins []string, timeout time.Duration) {
+	allowedOrigins = origins
+	authTimeout = timeout
+}
+
+func checkOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	for _, allowed := range allowedOrigins {
+		if origin == allowed {
+			return true
+		}
+	}
+	return false
 }
 
 type Client struct {
 	conn   *websocket.Conn
 	userID uuid.UUID
+
+	// log carries the connection ID, request ID and user ID.
+	log *slog.Logger
+
+	// tokenID and issuedAt identify the credential the connection was
+	// authenticated with, so revoking it can close the connection.
+	tokenID  string
+	issuedAt time.Time
 }
 
 var clients = make(map[*Client]bool)
 var broadcast = make(chan Message)
 var mu sync.Mutex
 
+// shuttingDown stops new connections from registering once Shutdown has run.
+var shuttingDown bool
+
+// hubPing lets Health check that HandleMessages is still taking messages.
+var hubPing = make(chan chan struct{})
+
 type Message struct {
 	Type string      `json:"type"`
 	Data interface{} `json:"data"`
 }
 
+// HandleConnections upgrades an authenticated request; the route must run
+// middleware.CheckAuthenticatedWebSocket first. When that middleware deferred
+// authentication, the client must send {"type":"auth","ticket":...} or
+// {"type":"auth","token":...} as its first frame within AuthTimeout.
 func HandleConnections(c *gin.Context) {
-	// Get token from query parameter
-	token := c.Query("token")
-	if token == "" {
-		log.Println("No token provided")
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "No token provided"})
-		return
-	}
-
-	// Validate token
-	claims := &jwt.MapClaims{}
-	parsedToken, err := jwt.ParseWithClaims(
-		token,
-		claims,
-		func(token *jwt.Token) (interface{}, error) {
-			return []byte(os.Getenv("JWT_SECRET")), nil
-		},
-	)
-	if err != nil {
-		log.Printf("Invalid token: %v", err)
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token"})
-		return
-	}
-	if !parsedToken.Valid {
-		log.Println("Token is not valid")
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token"})
-		return
-	}
-
-	// Extract user ID from claims
-	userIDStr, ok := (*claims)["sub"].(string)
-	if !ok {
-		log.Println("User ID not found in token")
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "User ID not found in token"})
-		return
-	}
-
-	userID, err := uuid.Parse(userIDStr)
-	if err != nil {
-		log.Printf("Invalid user ID format: %v", err)
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid user ID format"})
+	logger := middleware.Logger(c).With("conn_id", uuid.New().String())
+	principal, ok := auth.FromContext(c)
+	deferred := c.GetBool(middleware.DeferredWebSocketAuthKey)
+	if !ok && !deferred {
+		logger.Error("No authenticated principal for websocket")
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Not authenticated"})
 		return
 	}
 
 	// Upgrade HTTP connection to WebSocket
-	upgrader.CheckOrigin = func(r *http.Request) bool {
-		origin := r.Header.Get("Origin")
-		return origin == "https://note-taking-dusky.vercel.app" // Replace with your frontend URL
-	}
-
 	ws, err := upgrader.Upgrade(c.Writer, c.Request, nil)
 	if err != nil {
-		log.Printf("Failed to upgrade to WebSocket: %v", err)
+		logger.Warn("Failed to upgrade to WebSocket", "error", err)
 		return
 	}
 	defer ws.Close()
 
-	log.Printf("WebSocket connection established for user: %s", userID)
+	if deferred {
+		principal, err = authenticateFirstFrame(ws, database.DB.WithContext(c.Request.Context()))
+		if err != nil {
+			logger.Warn("WebSocket authentication failed", "error", err)
+			return
+		}
+		logger = logger.With("user_id", principal.UserID.String())
+	}
+	userID := principal.UserID
+
+	logger.Info("WebSocket connection established")
 
 	client := &Client{
-		conn:   ws,
-		userID: userID,
+		conn:     ws,
+		userID:   userID,
+		log:      logger,
+		tokenID:  principal.TokenID,
+		issuedAt: principal.IssuedAt,
 	}
 
 	mu.Lock()
+	if shuttingDown {
+		mu.Unlock()
+		sendGoingAway(ws, logger)
+		return
+	}
 	clients[client] = true
+	metrics.WebSocketConnected()
 	mu.Unlock()
 
 	for {
 		_, _, err := ws.ReadMessage()
 		if err != nil {
-			log.Printf("Error reading message: %v", err)
+			logger.Info("WebSocket connection closed", "reason", err.Error())
 			mu.Lock()
-			delete(clients, client)
+			if clients[client] {
+				delete(clients, client)
+				metrics.WebSocketDisconnected()
+			}
 			mu.Unlock()
 			break
 		}
 	}
 }
 
-func HandleMessages() {
+// dropClient closes a registered client's connection and forgets it. mu must
+// be held.
+func dropClient(client *Client, reason string) {
+	client.conn.Close()
+	delete(clients, client)
+	metrics.WebSocketDisconnected()
+	metrics.WebSocketDropped(reason)
+}
+
+// authFrame is the first message a client sends when it connected without
+// credentials.
+type authFrame struct {
+	Type   string `json:"type"`
+	Ticket string `json:"ticket"`
+	Token  string `json:"token"`
+}
+
+const (
+	DefaultAuthTimeout = 10 * time.Second
+
+	// maxAuthFrameSize bounds what an unauthenticated client can make us read.
+	maxAuthFrameSize = 8 << 10
+)
+
+// authenticateFirstFrame reads the auth frame, resolves it to a principal
+// with the ws:subscribe scope and acknowledges it. On failure the connection
+// is closed with a policy violation.
+func authenticateFirstFrame(ws *websocket.Conn, db *gorm.DB) (auth.Principal, error) {
+	fail := func(reason string, err error) (auth.Principal, error) {
+		message := websocket.FormatCloseMessage(websocket.ClosePolicyViolation, reason)
+		ws.WriteControl(websocket.CloseMessage, message, time.Now().Add(time.Second))
+		return auth.Principal{}, err
+	}
+
+	ws.SetReadLimit(maxAuthFrameSize)
+	ws.SetReadDeadline(time.Now().Add(authTimeout))
+	var frame authFrame
+	if err := ws.ReadJSON(&frame); err != nil {
+		return fail("authentication required", err)
+	}
+	ws.SetReadDeadline(time.Time{})
+	ws.SetReadLimit(0)
+
+	var principal auth.Principal
+	var err error
+	switch {
+	case frame.Type != "auth":
+		return fail("authentication required", errors.New("first frame is not an auth frame"))
+	case frame.Ticket != "":
+		principal, err = auth.RedeemTicket(db, frame.Ticket)
+	case frame.Token != "":
+		principal, err = auth.AuthenticateToken(db, frame.Token)
+	default:
+		return fail("authentication required", errors.New("auth frame has no credential"))
+	}
+	if err != nil {
+		return fail("authentication failed", err)
+	}
+	if !principal.HasScope(auth.ScopeWSSubscribe) {
+		return fail("missing scope "+auth.ScopeWSSubscribe, errors.New("missing websocket scope"))
+	}
+
+	ack := Message{Type: "authenticated", Data: map[string]interface{}{"user_id": principal.UserID.String()}}
+	if err := ws.WriteJSON(ack); err != nil {
+		return auth.Principal{}, err
+	}
+	return principal, nil
+}
+
+// HandleMessages relays messages sent on broadcast to every client until ctx
+// is cancelled.
+func HandleMessages(ctx context.Context) {
 	for {
-		msg := <-broadcast
+		var msg Message
+		select {
+		case <-ctx.Done():
+			return
+		case reply := <-hubPing:
+			close(reply)
+			continue
+		case msg = <-broadcast:
+		}
 		mu.Lock()
+		delivered := 0
 		for client := range clients {
 			err := client.conn.WriteJSON(msg)
 			if err != nil {
-				log.Printf("Error writing JSON: %v", err)
-				client.conn.Close()
-				delete(clients, client)
+				client.log.Warn("Error writing JSON", "error", err)
+				metrics.WebSocketWriteFailed()
+				dropClient(client, metrics.DropWriteFailed)
+				continue
 			}
+			delivered++
 		}
 		mu.Unlock()
+		metrics.WebSocketBroadcast(msg.Type, delivered)
 	}
 }
 
-func BroadcastNoteListToUser(notes []models.Note, userID uuid.UUID) {
+func BroadcastNoteListToUser(ctx context.Context, notes []models.Note, userID uuid.UUID) {
 	noteList := make([]map[string]interface{}, len(notes))
 	for i, note := range notes {
 		noteList[i] = map[string]interface{}{
@@ -146,43 +262,155 @@ func BroadcastNoteListToUser(notes []models.Note, userID uuid.UUID) {
 		Data: noteList,
 	}
 
-	broadcastToUser(msg, userID)
+	broadcastToUser(ctx, msg, userID)
 }
 
-func BroadcastNoteUpdateToUser(note models.Note, userID uuid.UUID) {
+func BroadcastNoteUpdateToUser(ctx context.Context, note models.Note, userID uuid.UUID) {
 	msg := Message{
 		Type: "noteUpdate",
 		Data: map[string]interface{}{
 			"id":             note.ID.String(), // Convert UUID to string
 			"title":          note.Title,
 			"content":        note.Content,
-			"dashboard_path": note.DashboardPath,
+			"dashboard_path": utils.SignPath(note.DashboardPath),
+			"workspace_id":   note.WorkspaceID,
 		},
 	}
 
-	broadcastToUser(msg, userID)
+	broadcastToUser(ctx, msg, userID)
 }
 
-func BroadcastNoteDeleteToUser(noteID uuid.UUID, userID uuid.UUID) {
+func BroadcastNoteDeleteToUser(ctx context.Context, noteID uuid.UUID, userID uuid.UUID) {
 	msg := Message{
 		Type: "noteDelete",
 		Data: noteID.String(), // Convert UUID to string
 	}
 
-	broadcastToUser(msg, userID)
+	broadcastToUser(ctx, msg, userID)
 }
 
-func broadcastToUser(msg Message, userID uuid.UUID) {
+// broadcastToUser writes msg to every connection userID has open on this
+// instance, dropping connections that fail.
+func broadcastToUser(ctx context.Context, msg Message, userID uuid.UUID) {
+	_, span := tracing.Start(ctx, "websocket.broadcast",
+		attribute.String("websocket.message_type", msg.Type),
+		attribute.String("user_id", userID.String()))
 	mu.Lock()
-	defer mu.Unlock()
+	delivered := 0
+	defer func() {
+		mu.Unlock()
+		span.SetAttributes(attribute.Int("websocket.delivered", delivered))
+		span.End()
+	}()
 	for client := range clients {
 		if client.userID == userID {
 			err := client.conn.WriteJSON(msg)
 			if err != nil {
-				log.Printf("Error writing JSON: %v", err)
-				client.conn.Close()
-				delete(clients, client)
+				client.log.Warn("Error writing JSON", "error", err)
+				metrics.WebSocketWriteFailed()
+				dropClient(client, metrics.DropWriteFailed)
+				continue
 			}
+			delivered++
+		}
+	}
+	metrics.WebSocketBroadcast(msg.Type, delivered)
+}
+
+func BroadcastQuotaWarningToUser(ctx context.Context, usage interface{}, userID uuid.UUID) {
+	msg := Message{
+		Type: "quotaWarning",
+		Data: usage,
+	}
+
+	broadcastToUser(ctx, msg, userID)
+}
+
+// CloseToken closes userID's connections authenticated with the token
+// identified by tokenID.
+func CloseToken(userID uuid.UUID, tokenID string) {
+	closeClients(userID, func(client *Client) bool {
+		return client.tokenID == tokenID
+	})
+}
+
+// CloseIssuedBefore closes userID's connections authenticated with a token
+// issued before cutoff.
+func CloseIssuedBefore(userID uuid.UUID, cutoff time.Time) {
+	closeClients(userID, func(client *Client) bool {
+		return client.issuedAt.Before(cutoff)
+	})
+}
+
+// closeClients sends a policy violation close frame to the matching clients of
+// userID on this instance and drops them.
+func closeClients(userID uuid.UUID, match func(*Client) bool) {
+	message := websocket.FormatCloseMessage(websocket.ClosePolicyViolation, "token revoked")
+	deadline := time.Now().Add(time.Second)
+
+	mu.Lock()
+	defer mu.Unlock()
+	for client := range clients {
+		if client.userID != userID || !match(client) {
+			continue
+		}
+		if err := client.conn.WriteControl(websocket.CloseMessage, message, deadline); err != nil {
+			client.log.Warn("Error writing close message", "error", err)
 		}
+		client.log.Info("WebSocket closed, token revoked")
+		dropClient(client, metrics.DropTokenRevoked)
+	}
+}
+
+// Shutdown tells every connected client that the server is going away and
+// when to reconnect, then closes the connections. Connections upgraded
+// afterwards are turned away the same way.
+func Shutdown() {
+	mu.Lock()
+	defer mu.Unlock()
+	shuttingDown = true
+	for client := range clients {
+		sendGoingAway(client.conn, client.log)
+		dropClient(client, metrics.DropShutdown)
+	}
+}
+
+// sendGoingAway sends a reconnect hint followed by a 1001 close frame. The
+// delay is jittered so clients do not all reconnect at the same moment.
+func sendGoingAway(conn *websocket.Conn, logger *slog.Logger) {
+	deadline := time.Now().Add(time.Second)
+	conn.SetWriteDeadline(deadline)
+	retryAfter := time.Second + time.Duration(rand.Int63n(int64(4*time.Second)))
+	hint := Message{Type: "reconnect", Data: map[string]interface{}{"retry_after_ms": retryAfter.Milliseconds()}}
+	if err := conn.WriteJSON(hint); err != nil {
+		logger.Warn("Error writing reconnect hint", "error", err)
+	}
+	message := websocket.FormatCloseMessage(websocket.CloseGoingAway, "server restarting, reconnect")
+	if err := conn.WriteControl(websocket.CloseMessage, message, deadline); err != nil {
+		logger.Warn("Error writing close message", "error", err)
+	}
+}
+
+// Health reports how many clients are connected. It fails when the broadcast
+// loop does not answer within ctx, for example because a write to a client is
+// stuck, or once Shutdown has run.
+func Health(ctx context.Context) (int, error) {
+	reply := make(chan struct{})
+	select {
+	case hubPing <- reply:
+	case <-ctx.Done():
+		return 0, errors.New("broadcast loop is not running or is stuck")
+	}
+	select {
+	case <-reply:
+	case <-ctx.Done():
+		return 0, errors.New("broadcast loop did not answer")
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if shuttingDown {
+		return len(clients), errors.New("shutting down")
 	}
+	return len(clients), nil
 }
@@ -0,0 +1,197 @@
+// internal/archive/archive.go
+package archive
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"NoteApi/internal/audit"
+	"NoteApi/internal/models"
+	"NoteApi/internal/storage"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// Version is the archive format written by Export. Import refuses other
+// versions.
+const Version = 1
+
+var (
+	ErrUnsupportedVersion = errors.New("unsupported archive version")
+	ErrNoteExists         = errors.New("note already exists")
+	ErrDigestMismatch     = errors.New("upload content does not match its sha256")
+)
+
+// Archive is everything a user owns outside workspaces: their personal
+// notes, including trashed ones, and their uploads with the file contents
+// inline. Workspace notes belong to the workspace and are left out, together
+// with the files attached to them.
+type Archive struct {
+	Version    int           `json:"version"`
+	UserID     uuid.UUID     `json:"user_id"`
+	ExportedAt time.Time     `json:"exported_at"`
+	Notes      []models.Note `json:"notes"`
+	Uploads    []Upload      `json:"uploads"`
+}
+
+// Upload is an upload record together with its content.
+type Upload struct {
+	models.Upload
+	Content []byte `json:"content"`
+}
+
+// Export collects userID's archive. Notes are decrypted when note encryption
+// is configured, so the archive can be imported under another master key.
+func Export(ctx context.Context, db *gorm.DB, userID uuid.UUID) (Archive, error) {
+	db = db.WithContext(ctx)
+	archive := Archive{Version: Version, UserID: userID, ExportedAt: time.Now().UTC(), Notes: []models.Note{}, Uploads: []Upload{}}
+
+	personal := func() *gorm.DB {
+		return db.Model(&models.Note{}).Where("user_id = ? AND workspace_id IS NULL", userID)
+	}
+	if err := personal().Order("created_at").Find(&archive.Notes).Error; err != nil {
+		return Archive{}, err
+	}
+
+	var uploads []models.Upload
+	err := db.Where("user_id = ?", userID).
+		Where("note_id IS NULL OR note_id IN (?)", personal().Select("id")).
+		Order("created_at").
+		Find(&uploads).Error
+	if err != nil {
+		return Archive{}, err
+	}
+	for _, upload := range uploads {
+		content, err := readUpload(db, upload.Path)
+		if err != nil {
+			return Archive{}, fmt.Errorf("upload %s: %w", upload.Path, err)
+		}
+		archive.Uploads = append(archive.Uploads, Upload{Upload: upload, Content: content})
+	}
+	return archive, nil
+}
+
+func readUpload(db *gorm.DB, path string) ([]byte, error) {
+	file, _, err := storage.Open(db, path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return io.ReadAll(file)
+}
+
+// ImportOptions adjust how Import restores an archive.
+type ImportOptions struct {
+	// UserID receives the notes and uploads instead of the archive's user.
+	UserID uuid.UUID
+	// NewIDs gives every note a new ID instead of failing when an ID is
+	// already taken, as when copying a user within one instance.
+	NewIDs bool
+}
+
+// ImportResult counts what Import restored.
+type ImportResult struct {
+	UserID  uuid.UUID
+	Notes   int
+	Uploads int
+}
+
+// Import restores an archive. Upload contents are stored first, deduplicated
+// like any other upload and under new paths; the notes are then created in
+// one transaction with their dashboard paths and attachments pointed at the
+// new uploads. When the notes cannot be created the stored uploads are
+// released again. Everything is audited as done by actor.
+func Import(ctx context.Context, db *gorm.DB, archive Archive, options ImportOptions, actor audit.Actor) (ImportResult, error) {
+	if archive.Version != Version {
+		return ImportResult{}, fmt.Errorf("%w: %d", ErrUnsupportedVersion, archive.Version)
+	}
+	userID := archive.UserID
+	if options.UserID != uuid.Nil {
+		userID = options.UserID
+	}
+	db = db.WithContext(ctx)
+
+	noteIDs := map[uuid.UUID]uuid.UUID{}
+	for _, note := range archive.Notes {
+		noteIDs[note.ID] = note.ID
+		if options.NewIDs {
+			noteIDs[note.ID] = uuid.New()
+		}
+	}
+	if !options.NewIDs && len(noteIDs) > 0 {
+		ids := make([]uuid.UUID, 0, len(noteIDs))
+		for id := range noteIDs {
+			ids = append(ids, id)
+		}
+		var taken []uuid.UUID
+		if err := db.Model(&models.Note{}).Where("id IN ?", ids).Limit(5).Pluck("id", &taken).Error; err != nil {
+			return ImportResult{}, err
+		}
+		if len(taken) > 0 {
+			return ImportResult{}, fmt.Errorf("%w: %v", ErrNoteExists, taken)
+		}
+	}
+
+	// Store the contents first; the notes refer to them by path
+	paths := map[string]string{}
+	stored := make([]models.Upload, 0, len(archive.Uploads))
+	release := func() {
+		for _, upload := range stored {
+			storage.Release(ctx, db, upload.Path)
+		}
+	}
+	for _, upload := range archive.Uploads {
+		saved, err := storage.Save(ctx, db, userID, bytes.NewReader(upload.Content), upload.Filename, upload.ContentType, audit.UploadHook(actor))
+		if err != nil {
+			release()
+			return ImportResult{}, fmt.Errorf("upload %s: %w", upload.Path, err)
+		}
+		stored = append(stored, saved)
+		if upload.Digest != "" && saved.Digest != upload.Digest {
+			release()
+			return ImportResult{}, fmt.Errorf("upload %s: %w", upload.Path, ErrDigestMismatch)
+		}
+		paths[upload.Path] = saved.Path
+	}
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		for _, note := range archive.Notes {
+			note.ID = noteIDs[note.ID]
+			note.UserID = userID
+			note.WorkspaceID = nil
+			if path, ok := paths[note.DashboardPath]; ok {
+				note.DashboardPath = path
+			}
+			if err := tx.Create(&note).Error; err != nil {
+				return fmt.Errorf("note %s: %w", note.ID, err)
+			}
+			if err := audit.Record(tx, actor, audit.ActionNoteCreate, audit.TargetNote, note.ID, audit.NoteChanges(nil, &note)); err != nil {
+				return err
+			}
+		}
+
+		for i, upload := range archive.Uploads {
+			if upload.NoteID == nil {
+				continue
+			}
+			noteID, ok := noteIDs[*upload.NoteID]
+			if !ok {
+				continue
+			}
+			if err := tx.Model(&stored[i]).Update("note_id", noteID).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		release()
+		return ImportResult{}, err
+	}
+	return ImportResult{UserID: userID, Notes: len(archive.Notes), Uploads: len(stored)}, nil
+}
@@ -0,0 +1,277 @@
+// internal/audit/audit.go
+package audit
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"NoteApi/internal/auth"
+	"NoteApi/internal/middleware"
+	"NoteApi/internal/models"
+	"NoteApi/internal/storage"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// Actions recorded in the audit log. Deleting a note moves it to the trash;
+// purging removes it for good.
+const (
+	ActionNoteCreate   = "note.create"
+	ActionNoteUpdate   = "note.update"
+	ActionNoteDelete   = "note.delete"
+	ActionNoteRestore  = "note.restore"
+	ActionNotePurge    = "note.purge"
+	ActionNoteShare    = "note.share"
+	ActionUploadCreate = "upload.create"
+)
+
+// Target types recorded in the audit log.
+const (
+	TargetNote   = "note"
+	TargetUpload = "upload"
+)
+
+const (
+	DefaultPageSize = 50
+	MaxPageSize     = 200
+)
+
+var ErrInvalidCursor = errors.New("invalid audit cursor")
+
+// Actor identifies who performed an audited operation and from where.
+type Actor struct {
+	UserID    uuid.UUID
+	RequestID string
+	ClientIP  string
+	UserAgent string
+}
+
+// ActorFromContext describes the caller of the current request.
+func ActorFromContext(c *gin.Context) Actor {
+	principal, _ := auth.FromContext(c)
+	return Actor{
+		UserID:    principal.UserID,
+		RequestID: c.GetString(middleware.RequestIDKey),
+		ClientIP:  c.ClientIP(),
+		UserAgent: c.Request.UserAgent(),
+	}
+}
+
+// CommandActor describes an administrative command run from the command
+// line. Its events have no actor user, so they appear in no user's log, and
+// share a request ID so everything one run did can be found together.
+func CommandActor(command string) Actor {
+	return Actor{
+		RequestID: uuid.New().String(),
+		UserAgent: "noteapi " + command,
+	}
+}
+
+// Change is the before and after summary of one field. Before is omitted for
+// created objects and After for deleted ones.
+type Change struct {
+	Before interface{} `json:"before,omitempty"`
+	After  interface{} `json:"after,omitempty"`
+}
+
+// Changes maps field names to their change summaries.
+type Changes map[string]Change
+
+// Record appends an event to the audit log using tx, so the event commits or
+// rolls back together with the operation it describes.
+func Record(tx *gorm.DB, actor Actor, action, targetType string, targetID uuid.UUID, changes Changes) error {
+	encoded, err := json.Marshal(changes)
+	if err != nil {
+		return err
+	}
+
+	event := models.AuditEvent{
+		ActorID:    actor.UserID,
+		Action:     action,
+		TargetType: targetType,
+		TargetID:   targetID.String(),
+		RequestID:  actor.RequestID,
+		ClientIP:   actor.ClientIP,
+		UserAgent:  actor.UserAgent,
+		Changes:    string(encoded),
+	}
+	return tx.Create(&event).Error
+}
+
+// UploadHook records an upload.create event in the transaction that stores
+// the upload.
+func UploadHook(actor Actor) storage.TxHook {
+	return func(tx *gorm.DB, upload models.Upload) error {
+		return Record(tx, actor, ActionUploadCreate, TargetUpload, upload.ID, UploadChanges(upload))
+	}
+}
+
+// NoteChanges summarises the fields that differ between before and after.
+// Pass nil for before when a note is created and nil for after when it is
+// deleted. Neither title nor content is copied into the log, so it does not
+// become a second, unencrypted copy of every note: content is recorded by size
+// and a hash prefix, and titles, short enough to guess from a hash, only by
+// length.
+func NoteChanges(before, after *models.Note) Changes {
+	changes := Changes{}
+	var b, a models.Note
+	if before != nil {
+		b = *before
+	}
+	if after != nil {
+		a = *after
+	}
+
+	field := func(name string, beforeValue, afterValue interface{}, differs bool) {
+		if before != nil && after != nil && !differs {
+			return
+		}
+		change := Change{}
+		if before != nil {
+			change.Before = beforeValue
+		}
+		if after != nil {
+			change.After = afterValue
+		}
+		changes[name] = change
+	}
+
+	field("title", titleLength(b.Title), titleLength(a.Title), b.Title != a.Title)
+	field("content", summarise(b.Content), summarise(a.Content), b.Content != a.Content)
+	field("dashboard_path", b.DashboardPath, a.DashboardPath, b.DashboardPath != a.DashboardPath)
+	return changes
+}
+
+// TrashChanges records a note moving into the trash (a zero before) or back
+// out of it (a zero after).
+func TrashChanges(before, after time.Time) Changes {
+	change := Change{}
+	if !before.IsZero() {
+		change.Before = before
+	}
+	if !after.IsZero() {
+		change.After = after
+	}
+	return Changes{"last_removed": change}
+}
+
+// UploadChanges summarises a newly stored upload.
+func UploadChanges(upload models.Upload) Changes {
+	changes := Changes{
+		"filename":     {After: upload.Filename},
+		"path":         {After: upload.Path},
+		"sha256":       {After: upload.Digest},
+		"content_type": {After: upload.ContentType},
+		"size":         {After: upload.Size},
+	}
+	if upload.NoteID != nil {
+		changes["note_id"] = Change{After: upload.NoteID.String()}
+	}
+	return changes
+}
+
+func titleLength(value string) string {
+	return fmt.Sprintf("%d characters", utf8.RuneCountInString(value))
+}
+
+func summarise(value string) string {
+	sum := sha256.Sum256([]byte(value))
+	return fmt.Sprintf("%d bytes, sha256:%s", len(value), hex.EncodeToString(sum[:6]))
+}
+
+// Filter narrows a listing of a user's audit events. Zero values match
+// everything.
+type Filter struct {
+	Actions    []string
+	TargetType string
+	TargetID   string
+	Since      time.Time
+	Until      time.Time
+	Limit      int
+	Cursor     string
+}
+
+// List returns the events performed by userID, newest first, and a cursor for
+// the next page, which is empty on the last page.
+func List(db *gorm.DB, userID uuid.UUID, filter Filter) ([]models.AuditEvent, string, error) {
+	limit := filter.Limit
+	if limit <= 0 {
+		limit = DefaultPageSize
+	}
+	if limit > MaxPageSize {
+		limit = MaxPageSize
+	}
+
+	query := db.Where("actor_id = ?", userID)
+	if len(filter.Actions) > 0 {
+		query = query.Where("action IN ?", filter.Actions)
+	}
+	if filter.TargetType != "" {
+		query = query.Where("target_type = ?", filter.TargetType)
+	}
+	if filter.TargetID != "" {
+		query = query.Where("target_id = ?", filter.TargetID)
+	}
+	if !filter.Since.IsZero() {
+		query = query.Where("created_at >= ?", filter.Since)
+	}
+	if !filter.Until.IsZero() {
+		query = query.Where("created_at < ?", filter.Until)
+	}
+	if filter.Cursor != "" {
+		createdAt, id, err := decodeCursor(filter.Cursor)
+		if err != nil {
+			return nil, "", err
+		}
+		query = query.Where("(created_at < ?) OR (created_at = ? AND id < ?)", createdAt, createdAt, id)
+	}
+
+	// Fetch one extra row to learn whether another page exists
+	var events []models.AuditEvent
+	if err := query.Order("created_at DESC, id DESC").Limit(limit + 1).Find(&events).Error; err != nil {
+		return nil, "", err
+	}
+
+	next := ""
+	if len(events) > limit {
+		events = events[:limit]
+		last := events[len(events)-1]
+		next = encodeCursor(last.CreatedAt, last.ID)
+	}
+	return events, next, nil
+}
+
+// Cursors are opaque to clients: the position of the last event returned.
+func encodeCursor(createdAt time.Time, id uuid.UUID) string {
+	raw := createdAt.UTC().Format(time.RFC3339Nano) + "|" + id.String()
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+func decodeCursor(cursor string) (time.Time, uuid.UUID, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return time.Time{}, uuid.Nil, ErrInvalidCursor
+	}
+	timestamp, idString, ok := strings.Cut(string(raw), "|")
+	if !ok {
+		return time.Time{}, uuid.Nil, ErrInvalidCursor
+	}
+	createdAt, err := time.Parse(time.RFC3339Nano, timestamp)
+	if err != nil {
+		return time.Time{}, uuid.Nil, ErrInvalidCursor
+	}
+	id, err := uuid.Parse(idString)
+	if err != nil {
+		return time.Time{}, uuid.Nil, ErrInvalidCursor
+	}
+	return createdAt, id, nil
+}
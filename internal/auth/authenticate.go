@@ -0,0 +1,49 @@
+// internal/auth/authenticate.go
+package auth
+
+import (
+	"errors"
+	"fmt"
+
+	"gorm.io/gorm"
+)
+
+var (
+	// ErrInvalidToken wraps every reason a credential was rejected other than
+	// revocation, so callers can tell bad credentials from server errors.
+	ErrInvalidToken = errors.New("invalid token")
+	// ErrVerifierUnavailable means JWTs cannot be checked at all.
+	ErrVerifierUnavailable = errors.New("token verifier unavailable")
+)
+
+// AuthenticateToken resolves a bearer credential to a Principal. Personal API
+// tokens are looked up by hash; anything else goes through the shared JWT
+// verifier, which checks the algorithm, signature, expiry, issuer and
+// audience. Either way the token must not have been revoked.
+func AuthenticateToken(db *gorm.DB, tokenString string) (Principal, error) {
+	var principal Principal
+	if IsAPIToken(tokenString) {
+		var err error
+		principal, err = AuthenticateAPIToken(db, tokenString)
+		if errors.Is(err, ErrAPITokenInvalid) || errors.Is(err, ErrAPITokenExpired) || errors.Is(err, ErrAPITokenRevoked) {
+			return Principal{}, fmt.Errorf("%w: %v", ErrInvalidToken, err)
+		}
+		if err != nil {
+			return Principal{}, err
+		}
+	} else {
+		verifier, err := Default()
+		if err != nil {
+			return Principal{}, fmt.Errorf("%w: %v", ErrVerifierUnavailable, err)
+		}
+		principal, err = verifier.Authenticate(tokenString)
+		if err != nil {
+			return Principal{}, fmt.Errorf("%w: %v", ErrInvalidToken, err)
+		}
+	}
+
+	if err := CheckRevoked(db, principal); err != nil {
+		return Principal{}, err
+	}
+	return principal, nil
+}
@@ -0,0 +1,97 @@
+// internal/auth/principal.go
+package auth
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+// Principal is the authenticated caller of a request. It looks the same
+// whether the caller presented a JWT or a personal API token; APITokenID is
+// only set for the latter.
+type Principal struct {
+	UserID     uuid.UUID
+	Scopes     []string
+	TokenID    string
+	IssuedAt   time.Time
+	ExpiresAt  time.Time
+	APITokenID uuid.UUID
+}
+
+// ViaAPIToken reports whether the principal authenticated with a personal
+// API token rather than a JWT.
+func (p Principal) ViaAPIToken() bool {
+	return p.APITokenID != uuid.Nil
+}
+
+// HasScope reports whether the principal was granted scope.
+func (p Principal) HasScope(scope string) bool {
+	for _, s := range p.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// Claims are the JWT claims the API understands. Scopes may arrive either as
+// an OAuth style space separated "scope" string or as an "scp" array.
+type Claims struct {
+	jwt.RegisteredClaims
+	Scope string   `json:"scope,omitempty"`
+	Scp   []string `json:"scp,omitempty"`
+}
+
+// ScopeList merges both scope representations.
+func (c Claims) ScopeList() []string {
+	scopes := strings.Fields(c.Scope)
+	return append(scopes, c.Scp...)
+}
+
+var ErrInvalidSubject = errors.New("token subject is not a valid user ID")
+
+// principalFromClaims builds a Principal from verified claims.
+func principalFromClaims(claims *Claims) (Principal, error) {
+	userID, err := uuid.Parse(claims.Subject)
+	if err != nil {
+		return Principal{}, ErrInvalidSubject
+	}
+
+	principal := Principal{
+		UserID:  userID,
+		Scopes:  claims.ScopeList(),
+		TokenID: claims.ID,
+	}
+	if len(principal.Scopes) == 0 {
+		principal.Scopes = DefaultJWTScopes()
+	}
+	if claims.IssuedAt != nil {
+		principal.IssuedAt = claims.IssuedAt.Time
+	}
+	if claims.ExpiresAt != nil {
+		principal.ExpiresAt = claims.ExpiresAt.Time
+	}
+	return principal, nil
+}
+
+const principalKey = "auth.principal"
+
+// SetPrincipal stores the authenticated principal on the request context.
+func SetPrincipal(c *gin.Context, principal Principal) {
+	c.Set(principalKey, principal)
+}
+
+// FromContext returns the principal stored by the auth middleware.
+func FromContext(c *gin.Context) (Principal, bool) {
+	value, exists := c.Get(principalKey)
+	if !exists {
+		return Principal{}, false
+	}
+	principal, ok := value.(Principal)
+	return principal, ok
+}
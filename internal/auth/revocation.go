@@ -0,0 +1,102 @@
+// internal/auth/revocation.go
+package auth
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"NoteApi/internal/config"
+	"NoteApi/internal/models"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// defaultRevocationTTL outlives any access token we expect to be issued.
+const defaultRevocationTTL = 30 * 24 * time.Hour
+
+var ErrTokenRevoked = errors.New("token revoked")
+
+// CheckRevoked returns ErrTokenRevoked when principal's token was revoked by
+// jti or was issued before the user's revocation cutoff. Tokens without an
+// iat claim are treated as issued at the beginning of time.
+func CheckRevoked(db *gorm.DB, principal Principal) error {
+	var revocation models.UserRevocation
+	err := db.Where("user_id = ?", principal.UserID).Limit(1).Find(&revocation).Error
+	if err != nil {
+		return err
+	}
+	if !revocation.RevokedBefore.IsZero() && principal.IssuedAt.Before(revocation.RevokedBefore) {
+		return ErrTokenRevoked
+	}
+
+	// API tokens are revoked through their own record
+	if principal.TokenID == "" || principal.ViaAPIToken() {
+		return nil
+	}
+	var count int64
+	err = db.Model(&models.RevokedToken{}).
+		Where("jti = ? AND user_id = ?", principal.TokenID, principal.UserID).
+		Count(&count).Error
+	if err != nil {
+		return err
+	}
+	if count > 0 {
+		return ErrTokenRevoked
+	}
+	return nil
+}
+
+// RevokeToken denies the JWT identified by jti for userID. The row is kept
+// until expiresAt; a zero expiresAt, used when the token itself is not at hand,
+// keeps it for defaultRevocationTTL.
+func RevokeToken(db *gorm.DB, userID uuid.UUID, jti string, expiresAt time.Time) error {
+	if expiresAt.IsZero() {
+		expiresAt = time.Now().Add(defaultRevocationTTL)
+	}
+	revoked := models.RevokedToken{JTI: jti, UserID: userID, ExpiresAt: expiresAt}
+	return db.Clauses(clause.OnConflict{DoNothing: true}).Create(&revoked).Error
+}
+
+// RevokeUserTokensBefore denies every token of userID issued before cutoff.
+// An earlier cutoff never replaces a later one.
+func RevokeUserTokensBefore(db *gorm.DB, userID uuid.UUID, cutoff time.Time) error {
+	// SQLite's GREATEST is the two-argument form of MAX
+	greatest := "GREATEST"
+	if db.Dialector.Name() == config.DriverSQLite {
+		greatest = "MAX"
+	}
+	revocation := models.UserRevocation{UserID: userID, RevokedBefore: cutoff}
+	return db.Clauses(clause.OnConflict{
+		Columns: []clause.Column{{Name: "user_id"}},
+		DoUpdates: clause.Assignments(map[string]interface{}{
+			"revoked_before": gorm.Expr(greatest + "(user_revocations.revoked_before, EXCLUDED.revoked_before)"),
+			"updated_at":     time.Now(),
+		}),
+	}).Create(&revocation).Error
+}
+
+// PruneRevokedTokens deletes jti revocations for tokens that have expired.
+func PruneRevokedTokens(db *gorm.DB) error {
+	return db.Where("expires_at < ?", time.Now()).Delete(&models.RevokedToken{}).Error
+}
+
+// PruneRevokedTokensLoop calls PruneRevokedTokens every interval until ctx is
+// cancelled.
+func PruneRevokedTokensLoop(ctx context.Context, db *gorm.DB, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := PruneRevokedTokens(db.WithContext(ctx)); err != nil {
+				log.Printf("Failed to prune revoked tokens: %v", err)
+			}
+		}
+	}
+}
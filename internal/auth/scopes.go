@@ -0,0 +1,73 @@
+// internal/auth/scopes.go
+package auth
+
+import (
+	"fmt"
+	"strings"
+
+	"NoteApi/internal/config"
+)
+
+const (
+	ScopeNotesRead    = "notes:read"
+	ScopeNotesWrite   = "notes:write"
+	ScopeNotesDelete  = "notes:delete"
+	ScopeUploadsWrite = "uploads:write"
+	ScopeWSSubscribe  = "ws:subscribe"
+
+	ScopeWorkspacesManage = "workspaces:manage"
+	ScopeTokensManage     = "tokens:manage"
+)
+
+// AllScopes lists every scope the API checks.
+var AllScopes = []string{
+	ScopeNotesRead,
+	ScopeNotesWrite,
+	ScopeNotesDelete,
+	ScopeUploadsWrite,
+	ScopeWSSubscribe,
+	ScopeWorkspacesManage,
+	ScopeTokensManage,
+}
+
+// IsKnownScope reports whether scope is one of AllScopes.
+func IsKnownScope(scope string) bool {
+	for _, known := range AllScopes {
+		if scope == known {
+			return true
+		}
+	}
+	return false
+}
+
+// defaultJWTScopes are granted to JWTs that carry no scope claim. Until
+// SetDefaultJWTScopes says otherwise such tokens keep full access, so sessions
+// from the user auth service continue to work.
+var defaultJWTScopes = AllScopes
+
+// SetDefaultJWTScopes sets the scopes granted to JWTs without a scope claim,
+// from config.AuthConfig.DefaultScopes: empty grants every scope and
+// config.NoDefaultScopes grants none. Unknown scopes are an error.
+func SetDefaultJWTScopes(scopes []string) error {
+	switch {
+	case len(scopes) == 0:
+		defaultJWTScopes = AllScopes
+		return nil
+	case len(scopes) == 1 && scopes[0] == config.NoDefaultScopes:
+		defaultJWTScopes = nil
+		return nil
+	}
+	for _, scope := range scopes {
+		if !IsKnownScope(scope) {
+			return fmt.Errorf("unknown default scope %q (known scopes: %s)", scope, strings.Join(AllScopes, ", "))
+		}
+	}
+	defaultJWTScopes = scopes
+	return nil
+}
+
+// DefaultJWTScopes returns the scopes granted to JWTs that carry no scope
+// claim.
+func DefaultJWTScopes() []string {
+	return defaultJWTScopes
+}
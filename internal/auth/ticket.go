@@ -0,0 +1,114 @@
+// internal/auth/ticket.go
+package auth
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"strings"
+	"time"
+
+	"NoteApi/internal/models"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// DefaultTicketTTL is how long a websocket ticket can wait before the
+// upgrade that redeems it.
+const DefaultTicketTTL = 30 * time.Second
+
+// ticketTTL is set from config.WebSocketConfig by SetTicketTTL.
+var ticketTTL = DefaultTicketTTL
+
+// SetTicketTTL sets how long issued tickets can be redeemed.
+func SetTicketTTL(ttl time.Duration) {
+	ticketTTL = ttl
+}
+
+// TicketTTL returns how long issued tickets can be redeemed.
+func TicketTTL() time.Duration {
+	return ticketTTL
+}
+
+// IssueTicket creates a single-use websocket ticket standing in for
+// principal. Only the ticket's hash is stored. The ticket expires no later
+// than the credential it stands in for.
+func IssueTicket(db *gorm.DB, principal Principal) (string, time.Time, error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", time.Time{}, err
+	}
+	ticket := base64.RawURLEncoding.EncodeToString(secret)
+
+	expiresAt := time.Now().Add(TicketTTL())
+	if !principal.ExpiresAt.IsZero() && principal.ExpiresAt.Before(expiresAt) {
+		expiresAt = principal.ExpiresAt
+	}
+	record := models.WSTicket{
+		TicketHash: HashAPIToken(ticket),
+		UserID:     principal.UserID,
+		Scopes:     strings.Join(principal.Scopes, " "),
+		TokenID:    principal.TokenID,
+		IssuedAt:   principal.IssuedAt,
+		ExpiresAt:  expiresAt,
+	}
+	if principal.ViaAPIToken() {
+		record.APITokenID = &principal.APITokenID
+	}
+	if !principal.ExpiresAt.IsZero() {
+		record.TokenExpiresAt = &principal.ExpiresAt
+	}
+	if err := db.Create(&record).Error; err != nil {
+		return "", time.Time{}, err
+	}
+
+	// Expired tickets are never redeemed; clear them out as new ones are made
+	db.Where("expires_at < ?", time.Now()).Delete(&models.WSTicket{})
+
+	return ticket, expiresAt, nil
+}
+
+// RedeemTicket consumes ticket and returns the principal it was issued for.
+// Deleting the row is what makes the ticket single-use, even across instances.
+func RedeemTicket(db *gorm.DB, ticket string) (Principal, error) {
+	var records []models.WSTicket
+	err := db.Clauses(clause.Returning{}).
+		Where("ticket_hash = ?", HashAPIToken(ticket)).
+		Delete(&records).Error
+	if err != nil {
+		return Principal{}, err
+	}
+	if len(records) == 0 || time.Now().After(records[0].ExpiresAt) {
+		return Principal{}, fmt.Errorf("%w: unknown or expired ticket", ErrInvalidToken)
+	}
+
+	record := records[0]
+	principal := Principal{
+		UserID:   record.UserID,
+		Scopes:   strings.Fields(record.Scopes),
+		TokenID:  record.TokenID,
+		IssuedAt: record.IssuedAt,
+	}
+	if record.APITokenID != nil {
+		principal.APITokenID = *record.APITokenID
+	}
+	if record.TokenExpiresAt != nil {
+		principal.ExpiresAt = *record.TokenExpiresAt
+	}
+
+	// The underlying token may have expired or been revoked since the ticket
+	// was issued
+	if !principal.ExpiresAt.IsZero() && time.Now().After(principal.ExpiresAt) {
+		return Principal{}, fmt.Errorf("%w: the token the ticket was issued for has expired", ErrInvalidToken)
+	}
+	if principal.ViaAPIToken() {
+		if err := checkAPITokenActive(db, principal.APITokenID); err != nil {
+			return Principal{}, err
+		}
+	}
+	if err := CheckRevoked(db, principal); err != nil {
+		return Principal{}, err
+	}
+	return principal, nil
+}
@@ -0,0 +1,280 @@
+// internal/auth/verifier.go
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	DefaultJWKSRefreshInterval = 10 * time.Minute
+	DefaultClockSkew           = 30 * time.Second
+
+	// minRefreshGap limits how often an unknown kid can force a refetch, so
+	// garbage tokens cannot be used to hammer the JWKS endpoint.
+	minRefreshGap = 30 * time.Second
+)
+
+var (
+	hmacMethods       = []string{"HS256", "HS384", "HS512"}
+	asymmetricMethods = []string{"RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "ES256", "ES384", "ES512", "EdDSA"}
+
+	ErrUnknownKey     = errors.New("no key found for token kid")
+	ErrHMACRetired    = errors.New("HMAC signed tokens are no longer accepted")
+	ErrNoVerification = errors.New("no token verification keys configured")
+)
+
+// Verifier checks JWT signatures. It accepts HMAC tokens signed with a shared
+// secret (optionally only until a cut-over time) and asymmetric tokens whose
+// kid names a key in a JWKS document that is cached and refreshed
+// periodically.
+type Verifier struct {
+	hmacSecret      []byte
+	hmacUntil       time.Time
+	jwksSource      string
+	refreshInterval time.Duration
+	client          *http.Client
+	parser          *jwt.Parser
+
+	mu          sync.RWMutex
+	keys        map[string]publicKey
+	lastRefresh time.Time
+	refreshMu   sync.Mutex
+
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+// VerifierConfig describes where a Verifier gets its keys.
+type VerifierConfig struct {
+	// HMACSecret enables HS256/384/512 tokens when non-empty.
+	HMACSecret string
+	// HMACUntil ends the HMAC migration window; zero means no end.
+	HMACUntil time.Time
+	// JWKSSource is an http(s) URL or a local file path. Empty disables
+	// asymmetric tokens.
+	JWKSSource string
+	// RefreshInterval controls how often the JWKS is refetched.
+	RefreshInterval time.Duration
+	// Issuer, when set, must match the iss claim.
+	Issuer string
+	// Audience, when set, must appear in the aud claim.
+	Audience string
+	// ClockSkew is the leeway allowed when checking exp, nbf and iat.
+	ClockSkew time.Duration
+}
+
+// NewVerifier builds a Verifier and performs the initial JWKS load.
+func NewVerifier(config VerifierConfig) (*Verifier, error) {
+	if config.HMACSecret == "" && config.JWKSSource == "" {
+		return nil, ErrNoVerification
+	}
+	if config.RefreshInterval <= 0 {
+		config.RefreshInterval = DefaultJWKSRefreshInterval
+	}
+	if config.ClockSkew < 0 {
+		config.ClockSkew = 0
+	}
+
+	v := &Verifier{
+		hmacSecret:      []byte(config.HMACSecret),
+		hmacUntil:       config.HMACUntil,
+		jwksSource:      config.JWKSSource,
+		refreshInterval: config.RefreshInterval,
+		client:          defaultHTTPClient,
+		keys:            map[string]publicKey{},
+		stop:            make(chan struct{}),
+	}
+
+	options := []jwt.ParserOption{
+		jwt.WithValidMethods(v.ValidMethods()),
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuedAt(),
+		jwt.WithLeeway(config.ClockSkew),
+	}
+	if config.Issuer != "" {
+		options = append(options, jwt.WithIssuer(config.Issuer))
+	}
+	if config.Audience != "" {
+		options = append(options, jwt.WithAudience(config.Audience))
+	}
+	v.parser = jwt.NewParser(options...)
+	if v.jwksSource != "" {
+		if err := v.Refresh(); err != nil {
+			return nil, err
+		}
+	}
+	return v, nil
+}
+
+var (
+	defaultVerifier   *Verifier
+	defaultVerifierMu sync.RWMutex
+)
+
+// SetDefault makes v the process-wide Verifier used by Authenticate and starts
+// its background JWKS refresh.
+func SetDefault(v *Verifier) {
+	defaultVerifierMu.Lock()
+	defer defaultVerifierMu.Unlock()
+	defaultVerifier = v
+	go v.refreshLoop()
+}
+
+// Default returns the process-wide Verifier set by SetDefault.
+func Default() (*Verifier, error) {
+	defaultVerifierMu.RLock()
+	defer defaultVerifierMu.RUnlock()
+	if defaultVerifier == nil {
+		return nil, ErrNoVerification
+	}
+	return defaultVerifier, nil
+}
+
+// CloseDefault stops the background refresh of the process-wide Verifier and
+// removes it, so tokens are no longer accepted.
+func CloseDefault() {
+	defaultVerifierMu.Lock()
+	defer defaultVerifierMu.Unlock()
+	if defaultVerifier != nil {
+		defaultVerifier.Close()
+		defaultVerifier = nil
+	}
+}
+
+// Close stops the Verifier's background JWKS refresh.
+func (v *Verifier) Close() {
+	v.stopOnce.Do(func() { close(v.stop) })
+}
+
+// Refresh refetches the JWKS and replaces the cached keys. On failure the
+// previous keys are kept.
+func (v *Verifier) Refresh() error {
+	if v.jwksSource == "" {
+		return nil
+	}
+
+	v.refreshMu.Lock()
+	defer v.refreshMu.Unlock()
+
+	set, err := fetchJWKS(v.jwksSource, v.client)
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.lastRefresh = time.Now()
+	if err != nil {
+		return err
+	}
+	v.keys = parseJWKS(set)
+	return nil
+}
+
+func (v *Verifier) refreshLoop() {
+	if v.jwksSource == "" {
+		return
+	}
+	ticker := time.NewTicker(v.refreshInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-v.stop:
+			return
+		case <-ticker.C:
+			if err := v.Refresh(); err != nil {
+				log.Printf("Failed to refresh JWKS: %v", err)
+			}
+		}
+	}
+}
+
+func (v *Verifier) lookup(kid string) (publicKey, bool) {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+	key, ok := v.keys[kid]
+	return key, ok
+}
+
+// keyForKid returns the key for kid, refetching the JWKS once if the kid is
+// unknown and the cache is not fresh, which picks up rotated keys early.
+func (v *Verifier) keyForKid(kid string) (publicKey, error) {
+	if key, ok := v.lookup(kid); ok {
+		return key, nil
+	}
+
+	v.mu.RLock()
+	stale := time.Since(v.lastRefresh) > minRefreshGap
+	v.mu.RUnlock()
+	if stale {
+		if err := v.Refresh(); err != nil {
+			log.Printf("Failed to refresh JWKS: %v", err)
+		}
+		if key, ok := v.lookup(kid); ok {
+			return key, nil
+		}
+	}
+	return publicKey{}, fmt.Errorf("%w: %q", ErrUnknownKey, kid)
+}
+
+// ValidMethods lists the signing algorithms this Verifier will accept.
+func (v *Verifier) ValidMethods() []string {
+	var methods []string
+	if v.hmacAllowed() {
+		methods = append(methods, hmacMethods...)
+	}
+	if v.jwksSource != "" {
+		methods = append(methods, asymmetricMethods...)
+	}
+	return methods
+}
+
+func (v *Verifier) hmacAllowed() bool {
+	return len(v.hmacSecret) > 0 && (v.hmacUntil.IsZero() || time.Now().Before(v.hmacUntil))
+}
+
+// Keyfunc selects the verification key for token. The parser built by
+// NewVerifier already pins the algorithm to ValidMethods.
+func (v *Verifier) Keyfunc(token *jwt.Token) (interface{}, error) {
+	switch token.Method.(type) {
+	case *jwt.SigningMethodHMAC:
+		if !v.hmacAllowed() {
+			return nil, ErrHMACRetired
+		}
+		return v.hmacSecret, nil
+	case *jwt.SigningMethodRSA, *jwt.SigningMethodRSAPSS, *jwt.SigningMethodECDSA, *jwt.SigningMethodEd25519:
+		kid, _ := token.Header["kid"].(string)
+		if kid == "" {
+			return nil, errors.New("token has no kid header")
+		}
+		key, err := v.keyForKid(kid)
+		if err != nil {
+			return nil, err
+		}
+		if key.alg != "" && key.alg != token.Method.Alg() {
+			return nil, fmt.Errorf("key %q is not valid for %s", kid, token.Method.Alg())
+		}
+		return key.key, nil
+	default:
+		return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+	}
+}
+
+// Parse verifies the signature of tokenString, checks its registered claims
+// (expiry, issued-at, issuer, audience) and decodes it into claims.
+func (v *Verifier) Parse(tokenString string, claims jwt.Claims) (*jwt.Token, error) {
+	return v.parser.ParseWithClaims(tokenString, claims, v.Keyfunc)
+}
+
+// Authenticate verifies tokenString and returns the Principal it identifies.
+func (v *Verifier) Authenticate(tokenString string) (Principal, error) {
+	claims := &Claims{}
+	if _, err := v.Parse(tokenString, claims); err != nil {
+		return Principal{}, err
+	}
+	return principalFromClaims(claims)
+}
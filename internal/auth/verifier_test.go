@@ -0,0 +1,369 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+const testHMACSecret = "test-hmac-secret"
+
+// testKeys are generated once; RSA key generation is slow.
+var testKeys = struct {
+	once sync.Once
+	rsa  *rsa.PrivateKey
+	ec   *ecdsa.PrivateKey
+	ed   ed25519.PrivateKey
+}{}
+
+func keys(t *testing.T) (*rsa.PrivateKey, *ecdsa.PrivateKey, ed25519.PrivateKey) {
+	t.Helper()
+	testKeys.once.Do(func() {
+		var err error
+		if testKeys.rsa, err = rsa.GenerateKey(rand.Reader, 2048); err != nil {
+			panic(err)
+		}
+		if testKeys.ec, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader); err != nil {
+			panic(err)
+		}
+		if _, testKeys.ed, err = ed25519.GenerateKey(rand.Reader); err != nil {
+			panic(err)
+		}
+	})
+	return testKeys.rsa, testKeys.ec, testKeys.ed
+}
+
+// jwkFor encodes the public half of key as a JWK named kid.
+func jwkFor(t *testing.T, kid, alg string, key crypto.PublicKey) JWK {
+	t.Helper()
+	b64 := base64.RawURLEncoding.EncodeToString
+	switch key := key.(type) {
+	case *rsa.PublicKey:
+		return JWK{Kid: kid, Kty: "RSA", Alg: alg, Use: "sig", N: b64(key.N.Bytes()), E: b64(big.NewInt(int64(key.E)).Bytes())}
+	case *ecdsa.PublicKey:
+		return JWK{Kid: kid, Kty: "EC", Alg: alg, Use: "sig", Crv: "P-256", X: b64(key.X.FillBytes(make([]byte, 32))), Y: b64(key.Y.FillBytes(make([]byte, 32)))}
+	case ed25519.PublicKey:
+		return JWK{Kid: kid, Kty: "OKP", Alg: alg, Use: "sig", Crv: "Ed25519", X: b64(key)}
+	}
+	t.Fatalf("unsupported key %T", key)
+	return JWK{}
+}
+
+// jwksServer serves a key set that tests can replace, counting fetches.
+type jwksServer struct {
+	*httptest.Server
+	mu      sync.Mutex
+	set     JWKS
+	status  int
+	fetches int
+}
+
+func newJWKSServer(t *testing.T, keys ...JWK) *jwksServer {
+	t.Helper()
+	s := &jwksServer{set: JWKS{Keys: keys}, status: http.StatusOK}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.fetches++
+		if s.status != http.StatusOK {
+			w.WriteHeader(s.status)
+			return
+		}
+		json.NewEncoder(w).Encode(s.set)
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *jwksServer) setKeys(keys ...JWK) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.set = JWKS{Keys: keys}
+}
+
+func (s *jwksServer) setStatus(status int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.status = status
+}
+
+func (s *jwksServer) fetchCount() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.fetches
+}
+
+// validClaims are claims the test verifiers accept.
+func validClaims(subject string) Claims {
+	now := time.Now()
+	return Claims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   subject,
+			Issuer:    "https://issuer.test",
+			Audience:  jwt.ClaimStrings{"noteapi"},
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(time.Hour)),
+			ID:        uuid.NewString(),
+		},
+		Scope: "notes:read notes:write",
+	}
+}
+
+func sign(t *testing.T, method jwt.SigningMethod, kid string, key interface{}, claims Claims) string {
+	t.Helper()
+	token := jwt.NewWithClaims(method, claims)
+	if kid != "" {
+		token.Header["kid"] = kid
+	}
+	signed, err := token.SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed
+}
+
+// newTestVerifier accepts HMAC tokens and the RSA, EC and Ed25519 test keys
+// from a JWKS server.
+func newTestVerifier(t *testing.T) (*Verifier, *jwksServer) {
+	t.Helper()
+	rsaKey, ecKey, edKey := keys(t)
+	server := newJWKSServer(t,
+		jwkFor(t, "rsa-1", "RS256", &rsaKey.PublicKey),
+		jwkFor(t, "ec-1", "ES256", &ecKey.PublicKey),
+		jwkFor(t, "ed-1", "EdDSA", edKey.Public()),
+	)
+	v, err := NewVerifier(VerifierConfig{
+		HMACSecret: testHMACSecret,
+		JWKSSource: server.URL,
+		Issuer:     "https://issuer.test",
+		Audience:   "noteapi",
+		ClockSkew:  time.Second,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(v.Close)
+	return v, server
+}
+
+func TestVerifierAuthenticate(t *testing.T) {
+	rsaKey, ecKey, edKey := keys(t)
+	v, _ := newTestVerifier(t)
+	userID := uuid.New()
+	claims := validClaims(userID.String())
+
+	tests := []struct {
+		name   string
+		method jwt.SigningMethod
+		kid    string
+		key    interface{}
+	}{
+		{"HS256", jwt.SigningMethodHS256, "", []byte(testHMACSecret)},
+		{"RS256", jwt.SigningMethodRS256, "rsa-1", rsaKey},
+		{"ES256", jwt.SigningMethodES256, "ec-1", ecKey},
+		{"EdDSA", jwt.SigningMethodEdDSA, "ed-1", edKey},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			principal, err := v.Authenticate(sign(t, tt.method, tt.kid, tt.key, claims))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if principal.UserID != userID || principal.TokenID != claims.ID || !principal.HasScope("notes:write") {
+				t.Fatalf("principal = %+v", principal)
+			}
+			if !principal.ExpiresAt.Equal(claims.ExpiresAt.Time) {
+				t.Fatalf("ExpiresAt = %v, want %v", principal.ExpiresAt, claims.ExpiresAt.Time)
+			}
+		})
+	}
+}
+
+func TestVerifierRejects(t *testing.T) {
+	rsaKey, ecKey, _ := keys(t)
+	otherRSA, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v, _ := newTestVerifier(t)
+	subject := uuid.NewString()
+
+	with := func(change func(*Claims)) Claims {
+		claims := validClaims(subject)
+		change(&claims)
+		return claims
+	}
+	publicDER, err := x509.MarshalPKIXPublicKey(&rsaKey.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	none, err := jwt.NewWithClaims(jwt.SigningMethodNone, validClaims(subject)).SignedString(jwt.UnsafeAllowNoneSignatureType)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		token string
+	}{
+		{"expired", sign(t, jwt.SigningMethodRS256, "rsa-1", rsaKey, with(func(c *Claims) {
+			c.ExpiresAt = jwt.NewNumericDate(time.Now().Add(-time.Minute))
+		}))},
+		{"no exp", sign(t, jwt.SigningMethodRS256, "rsa-1", rsaKey, with(func(c *Claims) { c.ExpiresAt = nil }))},
+		{"issued in the future", sign(t, jwt.SigningMethodRS256, "rsa-1", rsaKey, with(func(c *Claims) {
+			c.IssuedAt = jwt.NewNumericDate(time.Now().Add(time.Hour))
+		}))},
+		{"wrong issuer", sign(t, jwt.SigningMethodRS256, "rsa-1", rsaKey, with(func(c *Claims) { c.Issuer = "https://evil.test" }))},
+		{"wrong audience", sign(t, jwt.SigningMethodRS256, "rsa-1", rsaKey, with(func(c *Claims) { c.Audience = jwt.ClaimStrings{"other"} }))},
+		{"subject not a user ID", sign(t, jwt.SigningMethodHS256, "", []byte(testHMACSecret), with(func(c *Claims) { c.Subject = "alice" }))},
+		{"wrong HMAC secret", sign(t, jwt.SigningMethodHS256, "", []byte("guess"), validClaims(subject))},
+		{"signed by another key", sign(t, jwt.SigningMethodRS256, "rsa-1", otherRSA, validClaims(subject))},
+		{"unknown kid", sign(t, jwt.SigningMethodRS256, "rsa-2", rsaKey, validClaims(subject))},
+		{"no kid", sign(t, jwt.SigningMethodRS256, "", rsaKey, validClaims(subject))},
+		{"kid of a key of another type", sign(t, jwt.SigningMethodES256, "rsa-1", ecKey, validClaims(subject))},
+		{"algorithm the key is not pinned to", sign(t, jwt.SigningMethodPS256, "rsa-1", rsaKey, validClaims(subject))},
+		// Alg confusion: the public key, which anyone can fetch, used as an
+		// HMAC secret must not verify
+		{"HS256 keyed with the RSA public key", sign(t, jwt.SigningMethodHS256, "rsa-1", publicDER, validClaims(subject))},
+		{"alg none", none},
+		{"garbage", "not.a.token"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if principal, err := v.Authenticate(tt.token); err == nil {
+				t.Fatalf("accepted: %+v", principal)
+			}
+		})
+	}
+}
+
+func TestVerifierAlgConfusionWithoutHMAC(t *testing.T) {
+	rsaKey, _, _ := keys(t)
+	server := newJWKSServer(t, jwkFor(t, "rsa-1", "", &rsaKey.PublicKey))
+	v, err := NewVerifier(VerifierConfig{JWKSSource: server.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(v.Close)
+
+	for _, method := range v.ValidMethods() {
+		if method == "HS256" {
+			t.Fatalf("HMAC accepted without a secret: %v", v.ValidMethods())
+		}
+	}
+	publicDER, _ := x509.MarshalPKIXPublicKey(&rsaKey.PublicKey)
+	token := sign(t, jwt.SigningMethodHS256, "rsa-1", publicDER, validClaims(uuid.NewString()))
+	if _, err := v.Authenticate(token); err == nil {
+		t.Fatal("HS256 token keyed with the public key was accepted")
+	}
+
+	// Without an alg in the JWK, any asymmetric algorithm for the key works
+	if _, err := v.Authenticate(sign(t, jwt.SigningMethodPS256, "rsa-1", rsaKey, validClaims(uuid.NewString()))); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestVerifierHMACRetired(t *testing.T) {
+	v, err := NewVerifier(VerifierConfig{HMACSecret: testHMACSecret, HMACUntil: time.Now().Add(-time.Minute)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(v.Close)
+
+	token := sign(t, jwt.SigningMethodHS256, "", []byte(testHMACSecret), validClaims(uuid.NewString()))
+	if _, err := v.Authenticate(token); err == nil {
+		t.Fatal("HMAC token accepted after HMACUntil")
+	}
+}
+
+func TestNewVerifierNeedsKeys(t *testing.T) {
+	if _, err := NewVerifier(VerifierConfig{}); !errors.Is(err, ErrNoVerification) {
+		t.Fatalf("err = %v, want ErrNoVerification", err)
+	}
+
+	server := newJWKSServer(t)
+	server.setStatus(http.StatusInternalServerError)
+	if _, err := NewVerifier(VerifierConfig{JWKSSource: server.URL}); err == nil {
+		t.Fatal("NewVerifier succeeded without loading the JWKS")
+	}
+}
+
+func TestVerifierRefreshesForNewKid(t *testing.T) {
+	rsaKey, _, _ := keys(t)
+	rotated, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v, server := newTestVerifier(t)
+	fetches := server.fetchCount()
+
+	// The issuer rotates to a new key that the cached set does not have yet
+	server.setKeys(jwkFor(t, "rsa-1", "RS256", &rsaKey.PublicKey), jwkFor(t, "rsa-2", "RS256", &rotated.PublicKey))
+	token := sign(t, jwt.SigningMethodRS256, "rsa-2", rotated, validClaims(uuid.NewString()))
+
+	// Right after a fetch, unknown kids do not trigger another one
+	if _, err := v.Authenticate(token); !errors.Is(err, ErrUnknownKey) {
+		t.Fatalf("err = %v, want ErrUnknownKey", err)
+	}
+	if got := server.fetchCount(); got != fetches {
+		t.Fatalf("refetched %d times within the minimum gap", got-fetches)
+	}
+
+	// Once the gap has passed, one unknown kid refetches the set
+	v.mu.Lock()
+	v.lastRefresh = time.Now().Add(-minRefreshGap - time.Second)
+	v.mu.Unlock()
+	if _, err := v.Authenticate(token); err != nil {
+		t.Fatal(err)
+	}
+	if got := server.fetchCount(); got != fetches+1 {
+		t.Fatalf("fetches = %d, want %d", got, fetches+1)
+	}
+
+	// Garbage kids cannot force a refetch per request
+	for i := 0; i < 5; i++ {
+		v.Authenticate(sign(t, jwt.SigningMethodRS256, uuid.NewString(), rsaKey, validClaims(uuid.NewString())))
+	}
+	if got := server.fetchCount(); got != fetches+1 {
+		t.Fatalf("unknown kids caused %d more fetches", got-fetches-1)
+	}
+}
+
+func TestVerifierKeepsKeysWhenRefreshFails(t *testing.T) {
+	rsaKey, _, _ := keys(t)
+	v, server := newTestVerifier(t)
+
+	server.setStatus(http.StatusServiceUnavailable)
+	if err := v.Refresh(); err == nil {
+		t.Fatal("Refresh succeeded against a failing server")
+	}
+	if _, err := v.Authenticate(sign(t, jwt.SigningMethodRS256, "rsa-1", rsaKey, validClaims(uuid.NewString()))); err != nil {
+		t.Fatalf("cached key lost after a failed refresh: %v", err)
+	}
+
+	// A successful refresh replaces the set, dropping removed keys
+	server.setStatus(http.StatusOK)
+	server.setKeys()
+	if err := v.Refresh(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := v.Authenticate(sign(t, jwt.SigningMethodRS256, "rsa-1", rsaKey, validClaims(uuid.NewString()))); !errors.Is(err, ErrUnknownKey) {
+		t.Fatalf("err = %v, want ErrUnknownKey for a removed key", err)
+	}
+}
@@ -0,0 +1,756 @@
+// internal/config/config.go
+package config
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"NoteApi/internal/envelope"
+	"NoteApi/internal/ratelimit"
+
+	"github.com/pelletier/go-toml/v2"
+	"gopkg.in/yaml.v3"
+)
+
+// Config holds the settings the server needs at startup. Load fills it from
+// Defaults, then an optional YAML or TOML file named by CONFIG_FILE, then
+// environment variables, and validates the result.
+type Config struct {
+	Server    ServerConfig    `yaml:"server"     toml:"server"`
+	Database  DatabaseConfig  `yaml:"database"   toml:"database"`
+	Auth      AuthConfig      `yaml:"auth"       toml:"auth"`
+	Uploads   UploadsConfig   `yaml:"uploads"    toml:"uploads"`
+	Notes     NotesConfig     `yaml:"notes"      toml:"notes"`
+	Quota     QuotaConfig     `yaml:"quota"      toml:"quota"`
+	RateLimit RateLimitConfig `yaml:"rate_limit" toml:"rate_limit"`
+	WebSocket WebSocketConfig `yaml:"websocket"  toml:"websocket"`
+	Log       LogConfig       `yaml:"log"        toml:"log"`
+	Metrics   MetricsConfig   `yaml:"metrics"    toml:"metrics"`
+	Tracing   TracingConfig   `yaml:"tracing"    toml:"tracing"`
+}
+
+type ServerConfig struct {
+	// Host is the interface to listen on; empty means all interfaces.
+	Host string `yaml:"host" toml:"host"`
+	Port int    `yaml:"port" toml:"port"`
+	// TrustedProxies may set X-Forwarded-For; see gin's SetTrustedProxies.
+	TrustedProxies []string `yaml:"trusted_proxies" toml:"trusted_proxies"`
+	// CORSOrigins are the browser origins allowed to call the API.
+	CORSOrigins []string `yaml:"cors_origins" toml:"cors_origins"`
+	// ShutdownTimeout bounds how long in-flight requests may take to finish
+	// after SIGTERM or SIGINT.
+	ShutdownTimeout Duration `yaml:"shutdown_timeout" toml:"shutdown_timeout"`
+}
+
+// Addr is the listen address for http.Server.
+func (s ServerConfig) Addr() string {
+	return net.JoinHostPort(s.Host, strconv.Itoa(s.Port))
+}
+
+// Database drivers. SQLite needs no server and suits local development and
+// tests; DSN is then a file path, or ":memory:" with max_open_conns 1.
+const (
+	DriverPostgres = "postgres"
+	DriverSQLite   = "sqlite"
+)
+
+type DatabaseConfig struct {
+	Driver          string   `yaml:"driver"            toml:"driver"`
+	DSN             string   `yaml:"dsn"               toml:"dsn"`
+	MaxIdleConns    int      `yaml:"max_idle_conns"    toml:"max_idle_conns"`
+	MaxOpenConns    int      `yaml:"max_open_conns"    toml:"max_open_conns"`
+	ConnMaxLifetime Duration `yaml:"conn_max_lifetime" toml:"conn_max_lifetime"`
+	// MigrateOnStart applies pending migrations when the server starts
+	// instead of requiring `migrate up` first.
+	MigrateOnStart bool `yaml:"migrate_on_start" toml:"migrate_on_start"`
+}
+
+// NoDefaultScopes as the only entry of AuthConfig.DefaultScopes grants
+// tokens without a scope claim nothing.
+const NoDefaultScopes = "none"
+
+type AuthConfig struct {
+	// JWTSecret verifies HMAC signed tokens; empty disables them.
+	JWTSecret string `yaml:"jwt_secret" toml:"jwt_secret"`
+	// JWTHMACUntil stops accepting HMAC signed tokens after this time, to
+	// end a migration to JWKS. Zero means never.
+	JWTHMACUntil time.Time `yaml:"jwt_hmac_until" toml:"jwt_hmac_until"`
+	// JWKSSource is an http(s) URL or a file with the keys for
+	// asymmetrically signed tokens; empty disables them.
+	JWKSSource          string   `yaml:"jwks_source"           toml:"jwks_source"`
+	JWKSRefreshInterval Duration `yaml:"jwks_refresh_interval" toml:"jwks_refresh_interval"`
+	// Issuer and Audience, when set, must match the iss and aud claims.
+	Issuer   string `yaml:"issuer"   toml:"issuer"`
+	Audience string `yaml:"audience" toml:"audience"`
+	// ClockSkew is the leeway when checking exp, nbf and iat.
+	ClockSkew Duration `yaml:"clock_skew" toml:"clock_skew"`
+	// DefaultScopes are granted to JWTs without a scope claim. Empty keeps
+	// full access for sessions from the user auth service; NoDefaultScopes
+	// requires explicit scopes.
+	DefaultScopes []string `yaml:"default_scopes" toml:"default_scopes"`
+}
+
+type UploadsConfig struct {
+	// Dir is where uploaded content is stored on disk.
+	Dir string `yaml:"dir" toml:"dir"`
+	// MaxFormMemory is how much of a multipart upload is held in memory
+	// before spilling to temporary files.
+	MaxFormMemory int64 `yaml:"max_form_memory" toml:"max_form_memory"`
+	// MaxRequestSize bounds the body of a multipart upload or note form;
+	// larger files go through resumable uploads.
+	MaxRequestSize int64 `yaml:"max_request_size" toml:"max_request_size"`
+	// AllowedTypes limits which sniffed content types may be uploaded and
+	// how large each may be. Empty uses storage.DefaultAllowedTypes.
+	AllowedTypes []TypeRule `yaml:"allowed_types" toml:"allowed_types"`
+	// URLTTL is how long a signed upload URL stays valid.
+	URLTTL Duration `yaml:"url_ttl" toml:"url_ttl"`
+	// TusMaxSize is the largest resumable upload accepted, in bytes.
+	TusMaxSize int64 `yaml:"tus_max_size" toml:"tus_max_size"`
+	// SigningSecret keys the HMAC on signed upload URLs. It must not be
+	// shared with anything else, such as the JWT secret.
+	SigningSecret string `yaml:"signing_secret" toml:"signing_secret"`
+	// TusExpiry is how long an unfinished resumable upload may go without
+	// receiving data before it expires and stops counting toward quota.
+	TusExpiry Duration `yaml:"tus_expiry" toml:"tus_expiry"`
+}
+
+// TypeRule allows uploads whose content type matches Pattern, an exact type
+// or "type/*", up to MaxSize bytes.
+type TypeRule struct {
+	Pattern string `yaml:"pattern"  toml:"pattern"`
+	MaxSize int64  `yaml:"max_size" toml:"max_size"`
+}
+
+type NotesConfig struct {
+	// EncryptionKey is the master key, "<id>:<base64 32 byte key>", that
+	// wraps the data keys encrypting note titles and contents. Empty stores
+	// notes in plaintext.
+	EncryptionKey string `yaml:"encryption_key" toml:"encryption_key"`
+	// PreviousEncryptionKeys still unwrap data keys after a rotation until
+	// `noteapi rotate-keys` has re-wrapped them with EncryptionKey.
+	PreviousEncryptionKeys []string `yaml:"previous_encryption_keys" toml:"previous_encryption_keys"`
+}
+
+type QuotaConfig struct {
+	// MaxBytes is how much each user may store; zero disables the limit.
+	MaxBytes int64 `yaml:"max_bytes" toml:"max_bytes"`
+	// MaxNotes is how many notes each user may keep; zero disables the
+	// limit.
+	MaxNotes int64 `yaml:"max_notes" toml:"max_notes"`
+}
+
+// Rate limit stores.
+const (
+	RateLimitMemory   = "memory"
+	RateLimitPostgres = "postgres"
+)
+
+// RateLimitConfig sets the token buckets for each route group, written as
+// "<requests>/<period>" such as "120/1m".
+type RateLimitConfig struct {
+	// Store keeps the buckets: memory for each instance on its own, or
+	// postgres to share them between instances.
+	Store   string `yaml:"store"   toml:"store"`
+	IP      string `yaml:"ip"      toml:"ip"`
+	Notes   string `yaml:"notes"   toml:"notes"`
+	Uploads string `yaml:"uploads" toml:"uploads"`
+	Tus     string `yaml:"tus"     toml:"tus"`
+	WS      string `yaml:"ws"      toml:"ws"`
+}
+
+// Groups returns the limit of every route group by name.
+func (r RateLimitConfig) Groups() map[string]string {
+	return map[string]string{
+		"ip":      r.IP,
+		"notes":   r.Notes,
+		"uploads": r.Uploads,
+		"tus":     r.Tus,
+		"ws":      r.WS,
+	}
+}
+
+// LongestPeriod returns the longest period of the valid group limits, which
+// is how long a bucket can take to refill completely.
+func (r RateLimitConfig) LongestPeriod() time.Duration {
+	var longest time.Duration
+	for _, value := range r.Groups() {
+		if limit, err := ratelimit.ParseLimit(value); err == nil && limit.Period > longest {
+			longest = limit.Period
+		}
+	}
+	return longest
+}
+
+type WebSocketConfig struct {
+	// AllowedOrigins may open websocket connections. Empty means the same
+	// origins as Server.CORSOrigins.
+	AllowedOrigins []string `yaml:"allowed_origins" toml:"allowed_origins"`
+	// AuthTimeout is how long a connection may take to send its auth frame.
+	AuthTimeout Duration `yaml:"auth_timeout" toml:"auth_timeout"`
+	// TicketTTL is how long a ticket from POST /ws/ticket can be redeemed.
+	TicketTTL Duration `yaml:"ticket_ttl" toml:"ticket_ttl"`
+	// AllowQueryToken accepts the legacy "token" query parameter on
+	// upgrades. Query strings end up in proxy and access logs, so it is off
+	// by default.
+	AllowQueryToken bool `yaml:"allow_query_token" toml:"allow_query_token"`
+}
+
+type LogConfig struct {
+	// Level is the minimum level written: debug, info, warn or error.
+	Level string `yaml:"level" toml:"level"`
+}
+
+type MetricsConfig struct {
+	// Addr, when set, serves /metrics on its own listener (for example
+	// "127.0.0.1:9090") instead of the public one.
+	Addr string `yaml:"addr" toml:"addr"`
+	// Token, when set, must be sent as a bearer token to scrape /metrics.
+	Token string `yaml:"token" toml:"token"`
+}
+
+// Trace exporters.
+const (
+	ExporterNone   = "none"
+	ExporterOTLP   = "otlp"
+	ExporterStdout = "stdout"
+)
+
+type TracingConfig struct {
+	// Exporter is where spans go: none, otlp (OTLP over HTTP) or stdout for
+	// local runs.
+	Exporter string `yaml:"exporter" toml:"exporter"`
+	// Endpoint is the OTLP collector URL, such as
+	// "http://localhost:4318". Empty uses OTEL_EXPORTER_OTLP_ENDPOINT or
+	// the exporter's default.
+	Endpoint string `yaml:"endpoint" toml:"endpoint"`
+	// SampleRatio is the fraction of new traces recorded, from 0 to 1.
+	// Requests that arrive with a sampled parent are always recorded.
+	SampleRatio float64 `yaml:"sample_ratio" toml:"sample_ratio"`
+}
+
+// MinSigningSecretLength is the shortest uploads.signing_secret accepted, in
+// bytes.
+const MinSigningSecretLength = 32
+
+// Duration reads Go duration strings such as "30s" from config files.
+type Duration time.Duration
+
+func (d *Duration) UnmarshalText(text []byte) error {
+	parsed, err := time.ParseDuration(string(text))
+	if err != nil {
+		return err
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+// Defaults returns the settings used when nothing overrides them.
+func Defaults() Config {
+	return Config{
+		Server: ServerConfig{
+			Port:            8080,
+			TrustedProxies:  []string{"127.0.0.1"},
+			ShutdownTimeout: Duration(30 * time.Second),
+			CORSOrigins: []string{
+				"https://note-taking-dusky.vercel.app",
+				"https://userauthapi-i77f.onrender.com",
+				"https://noteapi-rw35.onrender.com",
+			},
+		},
+		Database: DatabaseConfig{
+			Driver:       DriverPostgres,
+			MaxIdleConns: 10,
+			MaxOpenConns: 100,
+		},
+		Auth: AuthConfig{
+			JWKSRefreshInterval: Duration(10 * time.Minute),
+			ClockSkew:           Duration(30 * time.Second),
+		},
+		Uploads: UploadsConfig{
+			Dir:            "uploads",
+			MaxFormMemory:  10 << 20, // 10 MB
+			MaxRequestSize: 64 << 20, // 64 MB
+			URLTTL:         Duration(time.Hour),
+			TusMaxSize:     1 << 30, // 1 GiB
+			TusExpiry:      Duration(24 * time.Hour),
+		},
+		Quota: QuotaConfig{
+			MaxBytes: 1 << 30, // 1 GiB
+		},
+		RateLimit: RateLimitConfig{
+			Store:   RateLimitMemory,
+			IP:      "300/1m",
+			Notes:   "120/1m",
+			Uploads: "30/1m",
+			Tus:     "300/1m",
+			WS:      "10/1m",
+		},
+		WebSocket: WebSocketConfig{
+			AllowedOrigins: []string{"https://note-taking-dusky.vercel.app"},
+			AuthTimeout:    Duration(10 * time.Second),
+			TicketTTL:      Duration(30 * time.Second),
+		},
+		Log: LogConfig{
+			Level: "info",
+		},
+		Tracing: TracingConfig{
+			Exporter:    ExporterNone,
+			SampleRatio: 1,
+		},
+	}
+}
+
+// Load builds the configuration and validates it. The error lists every
+// problem found, not just the first.
+func Load() (Config, error) {
+	cfg := Defaults()
+
+	if path := os.Getenv("CONFIG_FILE"); path != "" {
+		if err := loadFile(path, &cfg); err != nil {
+			return Config{}, fmt.Errorf("config file %s: %w", path, err)
+		}
+	}
+
+	if err := applyEnv(&cfg); err != nil {
+		return Config{}, err
+	}
+	if err := cfg.Validate(); err != nil {
+		return Config{}, err
+	}
+	return cfg, nil
+}
+
+// loadFile decodes a YAML or TOML file, chosen by extension, over cfg.
+// Unknown keys are rejected so typos do not silently fall back to defaults.
+func loadFile(path string, cfg *Config) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		decoder := yaml.NewDecoder(bytes.NewReader(data))
+		decoder.KnownFields(true)
+		if err := decoder.Decode(cfg); err != nil && !errors.Is(err, io.EOF) {
+			return err
+		}
+		return nil
+	case ".toml":
+		decoder := toml.NewDecoder(bytes.NewReader(data))
+		decoder.DisallowUnknownFields()
+		return decoder.Decode(cfg)
+	default:
+		return errors.New("unsupported format, use .yaml, .yml or .toml")
+	}
+}
+
+// applyEnv overrides cfg with the environment variables that are set.
+func applyEnv(cfg *Config) error {
+	var errs []error
+	str := func(key string, dst *string) {
+		if value, ok := os.LookupEnv(key); ok {
+			*dst = value
+		}
+	}
+	list := func(key string, dst *[]string) {
+		if value, ok := os.LookupEnv(key); ok {
+			*dst = splitList(value)
+		}
+	}
+	integer := func(key string, dst *int) {
+		if value, ok := os.LookupEnv(key); ok {
+			parsed, err := strconv.Atoi(value)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: %q is not an integer", key, value))
+				return
+			}
+			*dst = parsed
+		}
+	}
+	size := func(key string, dst *int64) {
+		if value, ok := os.LookupEnv(key); ok {
+			parsed, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: %q is not a byte count", key, value))
+				return
+			}
+			*dst = parsed
+		}
+	}
+	boolean := func(key string, dst *bool) {
+		if value, ok := os.LookupEnv(key); ok {
+			parsed, err := strconv.ParseBool(value)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: %q is not true or false", key, value))
+				return
+			}
+			*dst = parsed
+		}
+	}
+	ratio := func(key string, dst *float64) {
+		if value, ok := os.LookupEnv(key); ok {
+			parsed, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: %q is not a number", key, value))
+				return
+			}
+			*dst = parsed
+		}
+	}
+	duration := func(key string, dst *Duration) {
+		if value, ok := os.LookupEnv(key); ok {
+			parsed, err := time.ParseDuration(value)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: %q is not a duration", key, value))
+				return
+			}
+			*dst = Duration(parsed)
+		}
+	}
+	instant := func(key string, dst *time.Time) {
+		if value, ok := os.LookupEnv(key); ok {
+			if value == "" {
+				*dst = time.Time{}
+				return
+			}
+			parsed, err := time.Parse(time.RFC3339, value)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: %q is not an RFC 3339 time", key, value))
+				return
+			}
+			*dst = parsed
+		}
+	}
+	types := func(key string, dst *[]TypeRule) {
+		if value, ok := os.LookupEnv(key); ok {
+			rules, err := parseTypeRules(value)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", key, err))
+				return
+			}
+			*dst = rules
+		}
+	}
+
+	str("HOST", &cfg.Server.Host)
+	integer("PORT", &cfg.Server.Port)
+	list("TRUSTED_PROXIES", &cfg.Server.TrustedProxies)
+	list("CORS_ORIGINS", &cfg.Server.CORSOrigins)
+	duration("SHUTDOWN_TIMEOUT", &cfg.Server.ShutdownTimeout)
+
+	str("DB_DRIVER", &cfg.Database.Driver)
+	str("DB", &cfg.Database.DSN)
+	integer("DB_MAX_IDLE_CONNS", &cfg.Database.MaxIdleConns)
+	integer("DB_MAX_OPEN_CONNS", &cfg.Database.MaxOpenConns)
+	duration("DB_CONN_MAX_LIFETIME", &cfg.Database.ConnMaxLifetime)
+	boolean("DB_MIGRATE_ON_START", &cfg.Database.MigrateOnStart)
+
+	str("JWT_SECRET", &cfg.Auth.JWTSecret)
+	instant("JWT_HMAC_UNTIL", &cfg.Auth.JWTHMACUntil)
+	str("JWKS_SOURCE", &cfg.Auth.JWKSSource)
+	duration("JWKS_REFRESH_INTERVAL", &cfg.Auth.JWKSRefreshInterval)
+	str("JWT_ISSUER", &cfg.Auth.Issuer)
+	str("JWT_AUDIENCE", &cfg.Auth.Audience)
+	duration("JWT_CLOCK_SKEW", &cfg.Auth.ClockSkew)
+	if value, ok := os.LookupEnv("JWT_DEFAULT_SCOPES"); ok {
+		// Space separated, like the scope claim
+		cfg.Auth.DefaultScopes = strings.Fields(strings.ReplaceAll(value, ",", " "))
+	}
+
+	str("UPLOAD_DIR", &cfg.Uploads.Dir)
+	size("UPLOAD_MAX_FORM_MEMORY", &cfg.Uploads.MaxFormMemory)
+	size("UPLOAD_MAX_REQUEST_SIZE", &cfg.Uploads.MaxRequestSize)
+	types("UPLOAD_ALLOWED_TYPES", &cfg.Uploads.AllowedTypes)
+	duration("UPLOAD_URL_TTL", &cfg.Uploads.URLTTL)
+	str("UPLOAD_SIGNING_SECRET", &cfg.Uploads.SigningSecret)
+	size("TUS_MAX_SIZE", &cfg.Uploads.TusMaxSize)
+	duration("TUS_EXPIRY", &cfg.Uploads.TusExpiry)
+
+	str("NOTE_ENCRYPTION_KEY", &cfg.Notes.EncryptionKey)
+	list("NOTE_ENCRYPTION_PREVIOUS_KEYS", &cfg.Notes.PreviousEncryptionKeys)
+
+	size("QUOTA_MAX_BYTES", &cfg.Quota.MaxBytes)
+	size("QUOTA_MAX_NOTES", &cfg.Quota.MaxNotes)
+
+	str("RATE_LIMIT_STORE", &cfg.RateLimit.Store)
+	str("RATE_LIMIT_IP", &cfg.RateLimit.IP)
+	str("RATE_LIMIT_NOTES", &cfg.RateLimit.Notes)
+	str("RATE_LIMIT_UPLOADS", &cfg.RateLimit.Uploads)
+	str("RATE_LIMIT_TUS", &cfg.RateLimit.Tus)
+	str("RATE_LIMIT_WS", &cfg.RateLimit.WS)
+
+	list("WS_ALLOWED_ORIGINS", &cfg.WebSocket.AllowedOrigins)
+	duration("WS_AUTH_TIMEOUT", &cfg.WebSocket.AuthTimeout)
+	duration("WS_TICKET_TTL", &cfg.WebSocket.TicketTTL)
+	boolean("WS_ALLOW_QUERY_TOKEN", &cfg.WebSocket.AllowQueryToken)
+
+	str("LOG_LEVEL", &cfg.Log.Level)
+
+	str("METRICS_ADDR", &cfg.Metrics.Addr)
+	str("METRICS_TOKEN", &cfg.Metrics.Token)
+	str("TRACING_EXPORTER", &cfg.Tracing.Exporter)
+	str("TRACING_ENDPOINT", &cfg.Tracing.Endpoint)
+	ratio("TRACING_SAMPLE_RATIO", &cfg.Tracing.SampleRatio)
+
+	return errors.Join(errs...)
+}
+
+// parseTypeRules reads comma separated "type=maxbytes" entries such as
+// "image/*=10485760,application/pdf=26214400".
+func parseTypeRules(value string) ([]TypeRule, error) {
+	var rules []TypeRule
+	for _, entry := range splitList(value) {
+		pattern, size, ok := strings.Cut(entry, "=")
+		if !ok {
+			return nil, fmt.Errorf("%q is not type=maxbytes", entry)
+		}
+		maxSize, err := strconv.ParseInt(strings.TrimSpace(size), 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%q: %q is not a byte count", entry, size)
+		}
+		rules = append(rules, TypeRule{Pattern: strings.ToLower(strings.TrimSpace(pattern)), MaxSize: maxSize})
+	}
+	return rules, nil
+}
+
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// Validate reports every invalid setting.
+func (c Config) Validate() error {
+	var errs []error
+	invalid := func(format string, args ...interface{}) {
+		errs = append(errs, fmt.Errorf(format, args...))
+	}
+
+	if c.Server.Port < 1 || c.Server.Port > 65535 {
+		invalid("server.port (PORT): %d is not a valid port", c.Server.Port)
+	}
+	for _, proxy := range c.Server.TrustedProxies {
+		if net.ParseIP(proxy) == nil {
+			if _, _, err := net.ParseCIDR(proxy); err != nil {
+				invalid("server.trusted_proxies (TRUSTED_PROXIES): %q is not an IP or CIDR", proxy)
+			}
+		}
+	}
+	for _, origin := range c.Server.CORSOrigins {
+		if err := validOrigin(origin); err != nil {
+			invalid("server.cors_origins (CORS_ORIGINS): %v", err)
+		}
+	}
+
+	if c.Server.ShutdownTimeout <= 0 {
+		invalid("server.shutdown_timeout (SHUTDOWN_TIMEOUT) must be positive")
+	}
+
+	if c.Database.Driver != DriverPostgres && c.Database.Driver != DriverSQLite {
+		invalid("database.driver (DB_DRIVER): %q is not postgres or sqlite", c.Database.Driver)
+	}
+	if c.Database.DSN == "" {
+		invalid("database.dsn (DB) is required")
+	}
+	if c.Database.MaxOpenConns < 0 || c.Database.MaxIdleConns < 0 {
+		invalid("database pool sizes must not be negative")
+	}
+	if c.Database.MaxOpenConns > 0 && c.Database.MaxIdleConns > c.Database.MaxOpenConns {
+		invalid("database.max_idle_conns (%d) exceeds database.max_open_conns (%d)", c.Database.MaxIdleConns, c.Database.MaxOpenConns)
+	}
+	if c.Database.ConnMaxLifetime < 0 {
+		invalid("database.conn_max_lifetime must not be negative")
+	}
+
+	if !c.Auth.JWTHMACUntil.IsZero() && c.Auth.JWTSecret == "" {
+		invalid("auth.jwt_hmac_until (JWT_HMAC_UNTIL) needs auth.jwt_secret (JWT_SECRET)")
+	}
+	if strings.HasPrefix(c.Auth.JWKSSource, "http") {
+		if u, err := url.Parse(c.Auth.JWKSSource); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			invalid("auth.jwks_source (JWKS_SOURCE): %q is not an http(s) URL or a file", c.Auth.JWKSSource)
+		}
+	}
+	if c.Auth.JWKSRefreshInterval <= 0 {
+		invalid("auth.jwks_refresh_interval (JWKS_REFRESH_INTERVAL) must be positive")
+	}
+	if c.Auth.ClockSkew < 0 {
+		invalid("auth.clock_skew (JWT_CLOCK_SKEW) must not be negative")
+	}
+	if len(c.Auth.DefaultScopes) > 1 {
+		for _, scope := range c.Auth.DefaultScopes {
+			if scope == NoDefaultScopes {
+				invalid("auth.default_scopes (JWT_DEFAULT_SCOPES): %q cannot be combined with other scopes", NoDefaultScopes)
+			}
+		}
+	}
+
+	if c.Uploads.Dir == "" {
+		invalid("uploads.dir (UPLOAD_DIR) is required")
+	}
+	if c.Uploads.MaxFormMemory <= 0 {
+		invalid("uploads.max_form_memory (UPLOAD_MAX_FORM_MEMORY) must be positive")
+	}
+	if c.Uploads.MaxRequestSize <= 0 {
+		invalid("uploads.max_request_size (UPLOAD_MAX_REQUEST_SIZE) must be positive")
+	}
+	for _, rule := range c.Uploads.AllowedTypes {
+		if err := rule.validate(); err != nil {
+			invalid("uploads.allowed_types (UPLOAD_ALLOWED_TYPES): %v", err)
+		}
+	}
+	if c.Uploads.URLTTL <= 0 {
+		invalid("uploads.url_ttl (UPLOAD_URL_TTL) must be positive")
+	}
+	if c.Uploads.TusMaxSize <= 0 {
+		invalid("uploads.tus_max_size (TUS_MAX_SIZE) must be positive")
+	}
+	if c.Uploads.TusExpiry <= 0 {
+		invalid("uploads.tus_expiry (TUS_EXPIRY) must be positive")
+	}
+
+	// Keys are secrets, so errors name them by position rather than value
+	if c.Notes.EncryptionKey != "" {
+		if _, err := envelope.ParseMasterKey(c.Notes.EncryptionKey); err != nil {
+			invalid("notes.encryption_key (NOTE_ENCRYPTION_KEY): %v", err)
+		}
+	} else if len(c.Notes.PreviousEncryptionKeys) > 0 {
+		invalid("notes.previous_encryption_keys (NOTE_ENCRYPTION_PREVIOUS_KEYS) needs notes.encryption_key (NOTE_ENCRYPTION_KEY)")
+	}
+	for i, value := range c.Notes.PreviousEncryptionKeys {
+		if _, err := envelope.ParseMasterKey(value); err != nil {
+			invalid("notes.previous_encryption_keys (NOTE_ENCRYPTION_PREVIOUS_KEYS): key %d: %v", i+1, err)
+		}
+	}
+
+	if c.Quota.MaxBytes < 0 || c.Quota.MaxNotes < 0 {
+		invalid("quota limits (QUOTA_MAX_BYTES, QUOTA_MAX_NOTES) must not be negative; 0 disables them")
+	}
+
+	switch c.RateLimit.Store {
+	case RateLimitMemory:
+	case RateLimitPostgres:
+		if c.Database.Driver != DriverPostgres {
+			invalid("rate_limit.store (RATE_LIMIT_STORE): the postgres store needs database.driver postgres, not %s", c.Database.Driver)
+		}
+	default:
+		invalid("rate_limit.store (RATE_LIMIT_STORE): %q is not memory or postgres", c.RateLimit.Store)
+	}
+	for _, group := range []string{"ip", "notes", "uploads", "tus", "ws"} {
+		if _, err := ratelimit.ParseLimit(c.RateLimit.Groups()[group]); err != nil {
+			invalid("rate_limit.%s (RATE_LIMIT_%s): %v", group, strings.ToUpper(group), err)
+		}
+	}
+
+	for _, origin := range c.WebSocket.AllowedOrigins {
+		if err := validOrigin(origin); err != nil {
+			invalid("websocket.allowed_origins (WS_ALLOWED_ORIGINS): %v", err)
+		}
+	}
+	if c.WebSocket.AuthTimeout <= 0 {
+		invalid("websocket.auth_timeout (WS_AUTH_TIMEOUT) must be positive")
+	}
+	if c.WebSocket.TicketTTL <= 0 {
+		invalid("websocket.ticket_ttl (WS_TICKET_TTL) must be positive")
+	}
+
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(c.Log.Level)); err != nil {
+		invalid("log.level (LOG_LEVEL): %q is not debug, info, warn or error", c.Log.Level)
+	}
+
+	if c.Metrics.Addr != "" {
+		if _, port, err := net.SplitHostPort(c.Metrics.Addr); err != nil {
+			invalid("metrics.addr (METRICS_ADDR): %q is not host:port", c.Metrics.Addr)
+		} else if port == strconv.Itoa(c.Server.Port) {
+			invalid("metrics.addr (METRICS_ADDR) must use a different port than server.port")
+		}
+	}
+
+	switch c.Tracing.Exporter {
+	case ExporterNone, ExporterOTLP, ExporterStdout:
+	default:
+		invalid("tracing.exporter (TRACING_EXPORTER): %q is not none, otlp or stdout", c.Tracing.Exporter)
+	}
+	if c.Tracing.Endpoint != "" {
+		if u, err := url.Parse(c.Tracing.Endpoint); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			invalid("tracing.endpoint (TRACING_ENDPOINT): %q is not an http(s) URL", c.Tracing.Endpoint)
+		}
+	}
+	if c.Tracing.SampleRatio < 0 || c.Tracing.SampleRatio > 1 {
+		invalid("tracing.sample_ratio (TRACING_SAMPLE_RATIO) must be between 0 and 1")
+	}
+
+	if len(errs) == 0 {
+		return nil
+	}
+	return fmt.Errorf("invalid configuration:\n  %w", joinLines(errs))
+}
+
+// ValidateServe reports the secrets only the API server needs: a way to
+// verify tokens and a key for signing upload URLs. The admin commands run
+// without them.
+func (c Config) ValidateServe() error {
+	var errs []error
+	if c.Auth.JWTSecret == "" && c.Auth.JWKSSource == "" {
+		errs = append(errs, errors.New("auth.jwt_secret (JWT_SECRET) or auth.jwks_source (JWKS_SOURCE) is required"))
+	}
+	if len(c.Uploads.SigningSecret) < MinSigningSecretLength {
+		errs = append(errs, fmt.Errorf("uploads.signing_secret (UPLOAD_SIGNING_SECRET) must be at least %d bytes", MinSigningSecretLength))
+	}
+
+	if len(errs) == 0 {
+		return nil
+	}
+	return fmt.Errorf("invalid configuration:\n  %w", joinLines(errs))
+}
+
+// WebSocketOrigins returns the origins allowed to open websockets.
+func (c Config) WebSocketOrigins() []string {
+	if len(c.WebSocket.AllowedOrigins) > 0 {
+		return c.WebSocket.AllowedOrigins
+	}
+	return c.Server.CORSOrigins
+}
+
+func (r TypeRule) validate() error {
+	kind, subtype, ok := strings.Cut(r.Pattern, "/")
+	if !ok || kind == "" || kind == "*" || subtype == "" {
+		return fmt.Errorf("%q is not a type like image/png or image/*", r.Pattern)
+	}
+	if r.MaxSize <= 0 {
+		return fmt.Errorf("%s: max size must be positive", r.Pattern)
+	}
+	return nil
+}
+
+func validOrigin(origin string) error {
+	parsed, err := url.Parse(origin)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" || (parsed.Path != "" && parsed.Path != "/") {
+		return fmt.Errorf("%q is not an origin like https://example.com", origin)
+	}
+	return nil
+}
+
+// joinLines joins errors one per line, indented under the heading.
+func joinLines(errs []error) error {
+	messages := make([]string, len(errs))
+	for i, err := range errs {
+		messages[i] = err.Error()
+	}
+	return errors.New(strings.Join(messages, "\n  "))
+}
@@ -2,26 +2,35 @@ package database
 
 import (
 	"log"
-	"os"
+	"strings"
+	"time"
 
+	"NoteApi/internal/config"
+
+	"github.com/glebarez/sqlite"
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
 )
 
 var DB *gorm.DB
 
-func ConnectToDb() {
+func ConnectToDb(cfg config.DatabaseConfig) {
 	var err error
-	dsn := os.Getenv("DB")
-	
-	// Create a new PostgreSQL configuration
-	pgConfig := postgres.Config{
-		DSN:                  dsn,
-		PreferSimpleProtocol: true, // Disables implicit prepared statement usage
+	var dialector gorm.Dialector
+
+	switch cfg.Driver {
+	case config.DriverSQLite:
+		dialector = sqlite.Open(sqliteDSN(cfg.DSN))
+	default:
+		// Create a new PostgreSQL configuration
+		dialector = postgres.New(postgres.Config{
+			DSN:                  cfg.DSN,
+			PreferSimpleProtocol: true, // Disables implicit prepared statement usage
+		})
 	}
 
 	// Open the database connection with the new configuration
-	DB, err = gorm.Open(postgres.New(pgConfig), &gorm.Config{
+	DB, err = gorm.Open(dialector, &gorm.Config{
 		PrepareStmt: false, // Disable prepared statement caching
 	})
 
@@ -35,7 +44,53 @@ func ConnectToDb() {
 		log.Fatalf("failed to get database instance: %v", err)
 	}
 
-	sqlDB.SetMaxIdleConns(10)
-	sqlDB.SetMaxOpenConns(100)
+	sqlDB.SetMaxIdleConns(cfg.MaxIdleConns)
+	sqlDB.SetMaxOpenConns(cfg.MaxOpenConns)
+	sqlDB.SetConnMaxLifetime(time.Duration(cfg.ConnMaxLifetime))
+}
+
+// sqliteDSN adds the settings SQLite needs to be shared by a connection pool,
+// unless the DSN already sets them: writers wait for each other instead of
+// failing with SQLITE_BUSY, readers do not block writers, and transactions
+// take the write lock up front so two of them cannot deadlock upgrading.
+func sqliteDSN(dsn string) string {
+	params := []string{}
+	if !strings.Contains(dsn, "busy_timeout") {
+		params = append(params, "_pragma=busy_timeout(5000)")
+	}
+	if !strings.Contains(dsn, "journal_mode") {
+		params = append(params, "_pragma=journal_mode(WAL)")
+	}
+	if !strings.Contains(dsn, "_txlock") {
+		params = append(params, "_txlock=immediate")
+	}
+	if len(params) == 0 {
+		return dsn
+	}
+	separator := "?"
+	if strings.Contains(dsn, "?") {
+		separator = "&"
+	}
+	return dsn + separator + strings.Join(params, "&")
 }
 
+// Dialect names the SQL dialect of DB, config.DriverPostgres or
+// config.DriverSQLite, for the few queries that differ between them.
+func Dialect() string {
+	if DB == nil {
+		return config.DriverPostgres
+	}
+	return DB.Dialector.Name()
+}
+
+// Close closes the connection pool.
+func Close() error {
+	if DB == nil {
+		return nil
+	}
+	sqlDB, err := DB.DB()
+	if err != nil {
+		return err
+	}
+	return sqlDB.Close()
+}
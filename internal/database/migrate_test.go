@@ -0,0 +1,169 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"NoteApi/internal/config"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// connect points DB at a new connection for the length of the test.
+func connect(t *testing.T, cfg config.DatabaseConfig) *gorm.DB {
+	t.Helper()
+	previous := DB
+	cfg.MaxIdleConns, cfg.MaxOpenConns = 1, 1
+	ConnectToDb(cfg)
+	DB.Logger = logger.Discard
+	db := DB
+	t.Cleanup(func() {
+		DB = previous
+		if sqlDB, err := db.DB(); err == nil {
+			sqlDB.Close()
+		}
+	})
+	return db
+}
+
+func TestMigrateUpDownSQLite(t *testing.T) {
+	connect(t, config.DatabaseConfig{Driver: config.DriverSQLite, DSN: filepath.Join(t.TempDir(), "notes.db")})
+	ctx := context.Background()
+
+	if _, err := CheckSchemaVersion(DB); !errors.Is(err, ErrSchemaOutdated) {
+		t.Fatalf("empty database: err = %v, want ErrSchemaOutdated", err)
+	}
+	migrations, err := Migrations()
+	if err != nil {
+		t.Fatal(err)
+	}
+	applied, err := MigrateUp(ctx)
+	if err != nil || len(applied) != len(migrations) {
+		t.Fatalf("MigrateUp applied %d of %d: %v", len(applied), len(migrations), err)
+	}
+	if _, err := CheckSchemaVersion(DB); err != nil {
+		t.Fatal(err)
+	}
+	if applied, err := MigrateUp(ctx); err != nil || len(applied) != 0 {
+		t.Fatalf("second MigrateUp applied %d: %v", len(applied), err)
+	}
+
+	// Every down migration undoes its up migration, so the schema can be
+	// rolled back to nothing and built again
+	if rolledBack, err := MigrateDown(ctx, len(migrations)); err != nil || len(rolledBack) != len(migrations) {
+		t.Fatalf("MigrateDown rolled back %d of %d: %v", len(rolledBack), len(migrations), err)
+	}
+	if version, err := SchemaVersion(DB); err != nil || version != 0 {
+		t.Fatalf("after rolling back: version = %d, %v", version, err)
+	}
+	if DB.Migrator().HasTable("notes") {
+		t.Fatal("notes still exists after rolling back every migration")
+	}
+	if _, err := MigrateUp(ctx); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// baselineNote is the notes table as AutoMigrate created it before notes
+// could belong to a workspace.
+type baselineNote struct {
+	ID            uuid.UUID `gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
+	Title         string
+	DashboardPath string
+	Content       string
+	CreatedAt     time.Time `gorm:"autoCreateTime"`
+	LastChanged   time.Time `gorm:"autoUpdateTime"`
+	LastRemove    time.Time
+	UserID        uuid.UUID `gorm:"type:uuid"`
+}
+
+func (baselineNote) TableName() string { return "notes" }
+
+// baselineUpload is the uploads table as AutoMigrate created it before
+// uploads were deduplicated into blobs.
+type baselineUpload struct {
+	ID          uuid.UUID `gorm:"type:uuid;primary_key"`
+	UserID      uuid.UUID `gorm:"type:uuid;index"`
+	Path        string    `gorm:"uniqueIndex"`
+	ContentType string
+	Size        int64
+	CreatedAt   time.Time `gorm:"autoCreateTime"`
+}
+
+func (baselineUpload) TableName() string { return "uploads" }
+
+// TestMigrateBaselinePostgres migrates a database that an older release set
+// up with AutoMigrate. It needs a Postgres database to create a scratch
+// schema in, named by NOTEAPI_TEST_POSTGRES_DSN.
+func TestMigrateBaselinePostgres(t *testing.T) {
+	dsn := os.Getenv("NOTEAPI_TEST_POSTGRES_DSN")
+	if dsn == "" {
+		t.Skip("NOTEAPI_TEST_POSTGRES_DSN is not set")
+	}
+	ctx := context.Background()
+
+	admin := connect(t, config.DatabaseConfig{Driver: config.DriverPostgres, DSN: dsn})
+	schema := "noteapi_test_" + strings.ReplaceAll(uuid.NewString(), "-", "")
+	if err := admin.Exec("CREATE SCHEMA " + schema).Error; err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { admin.Exec("DROP SCHEMA " + schema + " CASCADE") })
+	connect(t, config.DatabaseConfig{Driver: config.DriverPostgres, DSN: withSearchPath(dsn, schema)})
+
+	if err := DB.AutoMigrate(&baselineNote{}, &baselineUpload{}); err != nil {
+		t.Fatal(err)
+	}
+	note := baselineNote{Title: "Groceries", Content: "milk", UserID: uuid.New()}
+	if err := DB.Create(&note).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := MigrateUp(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := CheckSchemaVersion(DB); err != nil {
+		t.Fatal(err)
+	}
+	for table, columns := range map[string][]string{
+		"notes":   {"workspace_id", "size"},
+		"uploads": {"note_id", "filename", "digest"},
+	} {
+		for _, column := range columns {
+			if !DB.Migrator().HasColumn(table, column) {
+				t.Errorf("%s.%s was not added", table, column)
+			}
+		}
+	}
+
+	var migrated struct {
+		Title string
+		Size  int64
+	}
+	if err := DB.Table("notes").Where("id = ?", note.ID).Take(&migrated).Error; err != nil {
+		t.Fatal(err)
+	}
+	if migrated.Title != "Groceries" || migrated.Size != int64(len("Groceries")+len("milk")) {
+		t.Fatalf("note after migrating = %+v", migrated)
+	}
+}
+
+// withSearchPath makes connections from dsn use schema, for both URL and
+// key=value DSNs.
+func withSearchPath(dsn, schema string) string {
+	if strings.HasPrefix(dsn, "postgres://") || strings.HasPrefix(dsn, "postgresql://") {
+		separator := "?"
+		if strings.Contains(dsn, "?") {
+			separator = "&"
+		}
+		return dsn + separator + "search_path=" + schema
+	}
+	return fmt.Sprintf("%s search_path=%s", dsn, schema)
+}
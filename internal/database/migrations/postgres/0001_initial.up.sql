@@ -0,0 +1,176 @@
+-- Baseline schema, matching what GORM AutoMigrate created before versioned
+-- migrations. Everything is IF NOT EXISTS so databases that were set up by
+-- AutoMigrate adopt this version without changes. Databases last migrated by
+-- an older release have some tables without the columns added since, so
+-- those columns are added separately as well.
+
+CREATE TABLE IF NOT EXISTS notes (
+    id uuid PRIMARY KEY DEFAULT gen_random_uuid(),
+    title text,
+    dashboard_path text,
+    content text,
+    created_at timestamptz,
+    last_changed timestamptz,
+    last_remove timestamptz,
+    user_id uuid,
+    workspace_id uuid
+);
+ALTER TABLE notes ADD COLUMN IF NOT EXISTS workspace_id uuid;
+CREATE INDEX IF NOT EXISTS idx_notes_workspace_id ON notes (workspace_id);
+
+CREATE TABLE IF NOT EXISTS uploads (
+    id uuid PRIMARY KEY,
+    user_id uuid,
+    note_id uuid,
+    path text,
+    filename text,
+    digest varchar(64),
+    content_type text,
+    size bigint,
+    created_at timestamptz
+);
+ALTER TABLE uploads ADD COLUMN IF NOT EXISTS note_id uuid;
+ALTER TABLE uploads ADD COLUMN IF NOT EXISTS filename text;
+ALTER TABLE uploads ADD COLUMN IF NOT EXISTS digest varchar(64);
+CREATE INDEX IF NOT EXISTS idx_uploads_user_id ON uploads (user_id);
+CREATE INDEX IF NOT EXISTS idx_uploads_note_id ON uploads (note_id);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_uploads_path ON uploads (path);
+CREATE INDEX IF NOT EXISTS idx_uploads_digest ON uploads (digest);
+
+CREATE TABLE IF NOT EXISTS blobs (
+    digest varchar(64) PRIMARY KEY,
+    size bigint,
+    content_type text,
+    ref_count bigint NOT NULL DEFAULT 0,
+    page_count bigint,
+    duration_seconds decimal,
+    row_count bigint,
+    created_at timestamptz
+);
+ALTER TABLE blobs ADD COLUMN IF NOT EXISTS page_count bigint;
+ALTER TABLE blobs ADD COLUMN IF NOT EXISTS duration_seconds decimal;
+ALTER TABLE blobs ADD COLUMN IF NOT EXISTS row_count bigint;
+
+CREATE TABLE IF NOT EXISTS tus_uploads (
+    id uuid PRIMARY KEY,
+    user_id uuid,
+    note_id uuid,
+    target text,
+    filename text,
+    file_type text,
+    metadata text,
+    length bigint,
+    upload_offset bigint,
+    path text,
+    created_at timestamptz
+);
+CREATE INDEX IF NOT EXISTS idx_tus_uploads_user_id ON tus_uploads (user_id);
+
+CREATE TABLE IF NOT EXISTS api_tokens (
+    id uuid PRIMARY KEY,
+    user_id uuid,
+    name text,
+    prefix text,
+    token_hash varchar(64),
+    scopes text,
+    expires_at timestamptz,
+    last_used_at timestamptz,
+    revoked_at timestamptz,
+    created_at timestamptz
+);
+CREATE INDEX IF NOT EXISTS idx_api_tokens_user_id ON api_tokens (user_id);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_api_tokens_token_hash ON api_tokens (token_hash);
+
+CREATE TABLE IF NOT EXISTS rate_limit_buckets (
+    key text PRIMARY KEY,
+    tokens decimal NOT NULL,
+    allowed boolean NOT NULL,
+    updated_at timestamptz NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_rate_limit_buckets_updated_at ON rate_limit_buckets (updated_at);
+
+CREATE TABLE IF NOT EXISTS audit_events (
+    id uuid PRIMARY KEY,
+    actor_id uuid,
+    action text,
+    target_type text,
+    target_id text,
+    request_id text,
+    client_ip text,
+    user_agent text,
+    changes text,
+    created_at timestamptz
+);
+CREATE INDEX IF NOT EXISTS idx_audit_actor_time ON audit_events (actor_id, created_at);
+CREATE INDEX IF NOT EXISTS idx_audit_events_action ON audit_events (action);
+CREATE INDEX IF NOT EXISTS idx_audit_events_target_id ON audit_events (target_id);
+
+CREATE TABLE IF NOT EXISTS data_keys (
+    user_id uuid PRIMARY KEY,
+    master_key_id text,
+    wrapped_key text,
+    created_at timestamptz,
+    updated_at timestamptz
+);
+CREATE INDEX IF NOT EXISTS idx_data_keys_master_key_id ON data_keys (master_key_id);
+
+CREATE TABLE IF NOT EXISTS revoked_tokens (
+    jti text PRIMARY KEY,
+    user_id uuid,
+    expires_at timestamptz,
+    created_at timestamptz
+);
+CREATE INDEX IF NOT EXISTS idx_revoked_tokens_user_id ON revoked_tokens (user_id);
+CREATE INDEX IF NOT EXISTS idx_revoked_tokens_expires_at ON revoked_tokens (expires_at);
+
+CREATE TABLE IF NOT EXISTS user_revocations (
+    user_id uuid PRIMARY KEY,
+    revoked_before timestamptz,
+    updated_at timestamptz
+);
+
+CREATE TABLE IF NOT EXISTS ws_tickets (
+    ticket_hash text PRIMARY KEY,
+    user_id uuid,
+    scopes text,
+    token_id text,
+    issued_at timestamptz,
+    api_token_id uuid,
+    expires_at timestamptz,
+    created_at timestamptz
+);
+CREATE INDEX IF NOT EXISTS idx_ws_tickets_expires_at ON ws_tickets (expires_at);
+
+CREATE TABLE IF NOT EXISTS workspaces (
+    id uuid PRIMARY KEY,
+    name text,
+    created_by uuid,
+    created_at timestamptz,
+    updated_at timestamptz
+);
+
+CREATE TABLE IF NOT EXISTS workspace_members (
+    workspace_id uuid,
+    user_id uuid,
+    role text,
+    created_at timestamptz,
+    updated_at timestamptz,
+    PRIMARY KEY (workspace_id, user_id)
+);
+CREATE INDEX IF NOT EXISTS idx_workspace_members_user_id ON workspace_members (user_id);
+
+CREATE TABLE IF NOT EXISTS workspace_invitations (
+    id uuid PRIMARY KEY,
+    workspace_id uuid,
+    role text,
+    code_hash text,
+    invited_by uuid,
+    invitee_id uuid,
+    expires_at timestamptz,
+    accepted_by uuid,
+    accepted_at timestamptz,
+    revoked_at timestamptz,
+    created_at timestamptz
+);
+CREATE INDEX IF NOT EXISTS idx_workspace_invitations_workspace_id ON workspace_invitations (workspace_id);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_workspace_invitations_code_hash ON workspace_invitations (code_hash);
@@ -0,0 +1,2 @@
+-- Personal note listings filter on user_id, which AutoMigrate never indexed.
+CREATE INDEX IF NOT EXISTS idx_notes_user_id ON notes (user_id);
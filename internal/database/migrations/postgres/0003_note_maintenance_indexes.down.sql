@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_notes_last_remove;
+DROP INDEX IF EXISTS idx_notes_search;
@@ -0,0 +1,6 @@
+-- Workspace search matches this expression (see workspace.SearchNotes); it was
+-- computed for every row on every search.
+CREATE INDEX IF NOT EXISTS idx_notes_search ON notes
+    USING gin (to_tsvector('simple', coalesce(title, '') || ' ' || coalesce(content, '')));
+-- purge-trash looks notes up by when they were trashed.
+CREATE INDEX IF NOT EXISTS idx_notes_last_remove ON notes (last_remove);
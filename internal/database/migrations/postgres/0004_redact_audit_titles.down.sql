@@ -0,0 +1,2 @@
+-- Redacted titles cannot be restored.
+SELECT 1;
@@ -0,0 +1,11 @@
+-- Note titles were copied into audit events in plaintext, outside note
+-- encryption. Keep which side of the change had a title, not the title.
+UPDATE audit_events
+SET changes = jsonb_set(
+        changes::jsonb,
+        '{title}',
+        (SELECT jsonb_object_agg(side, 'redacted') FROM jsonb_object_keys(changes::jsonb -> 'title') AS side)
+    )::text
+WHERE target_type = 'note'
+  AND jsonb_typeof(changes::jsonb -> 'title') = 'object'
+  AND changes::jsonb -> 'title' <> '{}'::jsonb;
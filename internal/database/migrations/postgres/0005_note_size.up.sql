@@ -0,0 +1,12 @@
+-- Quota counts the plaintext size of notes, which cannot be measured on
+-- encrypted columns. Encrypted values are 'enc:v1:' and the base64 of a 12
+-- byte nonce, the ciphertext and a 16 byte tag, so their size is known
+-- without decrypting them.
+ALTER TABLE notes ADD COLUMN IF NOT EXISTS size bigint NOT NULL DEFAULT 0;
+UPDATE notes SET size =
+    CASE WHEN title LIKE 'enc:v1:%'
+         THEN octet_length(decode(substr(title, 8), 'base64')) - 28
+         ELSE octet_length(coalesce(title, '')) END
+  + CASE WHEN content LIKE 'enc:v1:%'
+         THEN octet_length(decode(substr(content, 8), 'base64')) - 28
+         ELSE octet_length(coalesce(content, '')) END;
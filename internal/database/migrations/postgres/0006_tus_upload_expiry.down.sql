@@ -0,0 +1 @@
+ALTER TABLE tus_uploads DROP COLUMN IF EXISTS expires_at;
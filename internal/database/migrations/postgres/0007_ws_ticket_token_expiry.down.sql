@@ -0,0 +1 @@
+ALTER TABLE ws_tickets DROP COLUMN IF EXISTS token_expires_at;
@@ -0,0 +1,14 @@
+DROP TABLE IF EXISTS workspace_invitations;
+DROP TABLE IF EXISTS workspace_members;
+DROP TABLE IF EXISTS workspaces;
+DROP TABLE IF EXISTS ws_tickets;
+DROP TABLE IF EXISTS user_revocations;
+DROP TABLE IF EXISTS revoked_tokens;
+DROP TABLE IF EXISTS data_keys;
+DROP TABLE IF EXISTS audit_events;
+DROP TABLE IF EXISTS rate_limit_buckets;
+DROP TABLE IF EXISTS api_tokens;
+DROP TABLE IF EXISTS tus_uploads;
+DROP TABLE IF EXISTS blobs;
+DROP TABLE IF EXISTS uploads;
+DROP TABLE IF EXISTS notes;
@@ -0,0 +1,167 @@
+-- Baseline schema for SQLite, matching postgres/0001_initial.up.sql. UUIDs
+-- are stored as text and generated by the application; timestamps are
+-- declared datetime so the driver reads them back as times.
+
+CREATE TABLE IF NOT EXISTS notes (
+    id text PRIMARY KEY,
+    title text,
+    dashboard_path text,
+    content text,
+    created_at datetime,
+    last_changed datetime,
+    last_remove datetime,
+    user_id text,
+    workspace_id text
+);
+CREATE INDEX IF NOT EXISTS idx_notes_workspace_id ON notes (workspace_id);
+
+CREATE TABLE IF NOT EXISTS uploads (
+    id text PRIMARY KEY,
+    user_id text,
+    note_id text,
+    path text,
+    filename text,
+    digest text,
+    content_type text,
+    size integer,
+    created_at datetime
+);
+CREATE INDEX IF NOT EXISTS idx_uploads_user_id ON uploads (user_id);
+CREATE INDEX IF NOT EXISTS idx_uploads_note_id ON uploads (note_id);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_uploads_path ON uploads (path);
+CREATE INDEX IF NOT EXISTS idx_uploads_digest ON uploads (digest);
+
+CREATE TABLE IF NOT EXISTS blobs (
+    digest text PRIMARY KEY,
+    size integer,
+    content_type text,
+    ref_count integer NOT NULL DEFAULT 0,
+    page_count integer,
+    duration_seconds real,
+    row_count integer,
+    created_at datetime
+);
+
+CREATE TABLE IF NOT EXISTS tus_uploads (
+    id text PRIMARY KEY,
+    user_id text,
+    note_id text,
+    target text,
+    filename text,
+    file_type text,
+    metadata text,
+    length integer,
+    upload_offset integer,
+    path text,
+    created_at datetime
+);
+CREATE INDEX IF NOT EXISTS idx_tus_uploads_user_id ON tus_uploads (user_id);
+
+CREATE TABLE IF NOT EXISTS api_tokens (
+    id text PRIMARY KEY,
+    user_id text,
+    name text,
+    prefix text,
+    token_hash text,
+    scopes text,
+    expires_at datetime,
+    last_used_at datetime,
+    revoked_at datetime,
+    created_at datetime
+);
+CREATE INDEX IF NOT EXISTS idx_api_tokens_user_id ON api_tokens (user_id);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_api_tokens_token_hash ON api_tokens (token_hash);
+
+CREATE TABLE IF NOT EXISTS rate_limit_buckets (
+    key text PRIMARY KEY,
+    tokens real NOT NULL,
+    allowed boolean NOT NULL,
+    updated_at datetime NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_rate_limit_buckets_updated_at ON rate_limit_buckets (updated_at);
+
+CREATE TABLE IF NOT EXISTS audit_events (
+    id text PRIMARY KEY,
+    actor_id text,
+    action text,
+    target_type text,
+    target_id text,
+    request_id text,
+    client_ip text,
+    user_agent text,
+    changes text,
+    created_at datetime
+);
+CREATE INDEX IF NOT EXISTS idx_audit_actor_time ON audit_events (actor_id, created_at);
+CREATE INDEX IF NOT EXISTS idx_audit_events_action ON audit_events (action);
+CREATE INDEX IF NOT EXISTS idx_audit_events_target_id ON audit_events (target_id);
+
+CREATE TABLE IF NOT EXISTS data_keys (
+    user_id text PRIMARY KEY,
+    master_key_id text,
+    wrapped_key text,
+    created_at datetime,
+    updated_at datetime
+);
+CREATE INDEX IF NOT EXISTS idx_data_keys_master_key_id ON data_keys (master_key_id);
+
+CREATE TABLE IF NOT EXISTS revoked_tokens (
+    jti text PRIMARY KEY,
+    user_id text,
+    expires_at datetime,
+    created_at datetime
+);
+CREATE INDEX IF NOT EXISTS idx_revoked_tokens_user_id ON revoked_tokens (user_id);
+CREATE INDEX IF NOT EXISTS idx_revoked_tokens_expires_at ON revoked_tokens (expires_at);
+
+CREATE TABLE IF NOT EXISTS user_revocations (
+    user_id text PRIMARY KEY,
+    revoked_before datetime,
+    updated_at datetime
+);
+
+CREATE TABLE IF NOT EXISTS ws_tickets (
+    ticket_hash text PRIMARY KEY,
+    user_id text,
+    scopes text,
+    token_id text,
+    issued_at datetime,
+    api_token_id text,
+    expires_at datetime,
+    created_at datetime
+);
+CREATE INDEX IF NOT EXISTS idx_ws_tickets_expires_at ON ws_tickets (expires_at);
+
+CREATE TABLE IF NOT EXISTS workspaces (
+    id text PRIMARY KEY,
+    name text,
+    created_by text,
+    created_at datetime,
+    updated_at datetime
+);
+
+CREATE TABLE IF NOT EXISTS workspace_members (
+    workspace_id text,
+    user_id text,
+    role text,
+    created_at datetime,
+    updated_at datetime,
+    PRIMARY KEY (workspace_id, user_id)
+);
+CREATE INDEX IF NOT EXISTS idx_workspace_members_user_id ON workspace_members (user_id);
+
+CREATE TABLE IF NOT EXISTS workspace_invitations (
+    id text PRIMARY KEY,
+    workspace_id text,
+    role text,
+    code_hash text,
+    invited_by text,
+    invitee_id text,
+    expires_at datetime,
+    accepted_by text,
+    accepted_at datetime,
+    revoked_at datetime,
+    created_at datetime
+);
+CREATE INDEX IF NOT EXISTS idx_workspace_invitations_workspace_id ON workspace_invitations (workspace_id);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_workspace_invitations_code_hash ON workspace_invitations (code_hash);
@@ -0,0 +1,2 @@
+-- Search scans with LIKE on SQLite, so only purge-trash gets an index.
+CREATE INDEX IF NOT EXISTS idx_notes_last_remove ON notes (last_remove);
@@ -0,0 +1,15 @@
+-- Note titles were copied into audit events in plaintext, outside note
+-- encryption. Keep which side of the change had a title, not the title.
+UPDATE audit_events
+SET changes = json_set(changes, '$.title', json(
+        CASE WHEN json_extract(changes, '$.title.before') IS NOT NULL
+             AND json_extract(changes, '$.title.after') IS NOT NULL
+             THEN '{"before":"redacted","after":"redacted"}'
+             WHEN json_extract(changes, '$.title.before') IS NOT NULL
+             THEN '{"before":"redacted"}'
+             ELSE '{"after":"redacted"}'
+        END))
+WHERE target_type = 'note'
+  AND json_valid(changes)
+  AND (json_extract(changes, '$.title.before') IS NOT NULL
+       OR json_extract(changes, '$.title.after') IS NOT NULL);
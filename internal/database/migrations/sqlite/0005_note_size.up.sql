@@ -0,0 +1,12 @@
+-- Quota counts the plaintext size of notes, which cannot be measured on
+-- encrypted columns. Encrypted values are 'enc:v1:' and the base64 of a 12
+-- byte nonce, the ciphertext and a 16 byte tag, so their size is known
+-- without decrypting them.
+ALTER TABLE notes ADD COLUMN size integer NOT NULL DEFAULT 0;
+UPDATE notes SET size =
+    CASE WHEN title LIKE 'enc:v1:%'
+         THEN (length(title) - 7) / 4 * 3 - (CASE WHEN title LIKE '%==' THEN 2 WHEN title LIKE '%=' THEN 1 ELSE 0 END) - 28
+         ELSE length(CAST(coalesce(title, '') AS BLOB)) END
+  + CASE WHEN content LIKE 'enc:v1:%'
+         THEN (length(content) - 7) / 4 * 3 - (CASE WHEN content LIKE '%==' THEN 2 WHEN content LIKE '%=' THEN 1 ELSE 0 END) - 28
+         ELSE length(CAST(coalesce(content, '') AS BLOB)) END;
@@ -0,0 +1,3 @@
+-- Unfinished resumable uploads expire so they stop counting toward quota.
+-- Uploads started before this have no expiry and are left to gc-uploads.
+ALTER TABLE tus_uploads ADD COLUMN expires_at datetime;
@@ -0,0 +1 @@
+ALTER TABLE ws_tickets DROP COLUMN token_expires_at;
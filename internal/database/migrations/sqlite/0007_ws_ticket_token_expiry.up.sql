@@ -0,0 +1,3 @@
+-- Tickets remember when the credential they stand in for expires, so one
+-- cannot outlive it.
+ALTER TABLE ws_tickets ADD COLUMN token_expires_at datetime;
@@ -0,0 +1,282 @@
+// internal/envelope/envelope.go
+package envelope
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"NoteApi/internal/models"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Note fields are encrypted with a per-user data key. Data keys are stored
+// wrapped (encrypted) by a master key that only lives in configuration, so a
+// database dump alone reveals nothing. Rotating the master key only requires
+// re-wrapping the data keys, not re-encrypting every note.
+
+// Prefix marks an encrypted value. Values without it are plaintext written
+// before encryption was enabled and are returned unchanged.
+const Prefix = "enc:v1:"
+
+const keySize = 32 // AES-256
+
+var (
+	ErrUnknownMasterKey = errors.New("data key is wrapped with an unknown master key")
+	ErrMalformedKey     = errors.New("master key must be <id>:<base64 32 byte key>")
+	ErrMalformedValue   = errors.New("malformed encrypted value")
+)
+
+// MasterKey wraps data keys. ID is stored next to each wrapped key so old
+// master keys can still unwrap after a rotation.
+type MasterKey struct {
+	ID  string
+	Key []byte
+}
+
+// ParseMasterKey reads "<id>:<base64 key>".
+func ParseMasterKey(value string) (MasterKey, error) {
+	id, encoded, ok := strings.Cut(strings.TrimSpace(value), ":")
+	if !ok || id == "" {
+		return MasterKey{}, ErrMalformedKey
+	}
+	key, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return MasterKey{}, fmt.Errorf("%w: the key is not standard base64", ErrMalformedKey)
+	}
+	if len(key) != keySize {
+		return MasterKey{}, fmt.Errorf("%w: the key is %d bytes, not %d", ErrMalformedKey, len(key), keySize)
+	}
+	return MasterKey{ID: id, Key: key}, nil
+}
+
+// Keyring seals and opens note fields. It implements models.FieldCipher.
+type Keyring struct {
+	db       *gorm.DB
+	current  MasterKey
+	previous map[string]MasterKey
+
+	mu       sync.RWMutex
+	dataKeys map[uuid.UUID]cipher.AEAD
+}
+
+// NewKeyring encrypts new data keys with current and can unwrap keys made
+// with any of previous.
+func NewKeyring(db *gorm.DB, current MasterKey, previous ...MasterKey) *Keyring {
+	k := &Keyring{
+		db:       db,
+		current:  current,
+		previous: map[string]MasterKey{},
+		dataKeys: map[uuid.UUID]cipher.AEAD{},
+	}
+	for _, key := range previous {
+		k.previous[key.ID] = key
+	}
+	return k
+}
+
+// ParseKeyring builds a Keyring from a master key and the previous keys it
+// replaced, each written as "<id>:<base64 key>". It returns nil when current
+// is empty, meaning encryption is not configured.
+func ParseKeyring(db *gorm.DB, current string, previous []string) (*Keyring, error) {
+	if current == "" {
+		return nil, nil
+	}
+	currentKey, err := ParseMasterKey(current)
+	if err != nil {
+		return nil, fmt.Errorf("current key: %w", err)
+	}
+
+	previousKeys := make([]MasterKey, 0, len(previous))
+	for i, value := range previous {
+		key, err := ParseMasterKey(value)
+		if err != nil {
+			return nil, fmt.Errorf("previous key %d: %w", i+1, err)
+		}
+		previousKeys = append(previousKeys, key)
+	}
+	return NewKeyring(db, currentKey, previousKeys...), nil
+}
+
+// Seal encrypts plaintext for field of a note. The note ID and field name are
+// bound as additional data so ciphertext cannot be moved between fields or
+// notes.
+func (k *Keyring) Seal(userID, noteID uuid.UUID, field, plaintext string) (string, error) {
+	aead, err := k.dataKey(userID)
+	if err != nil {
+		return "", err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := aead.Seal(nonce, nonce, []byte(plaintext), additionalData(noteID, field))
+	return Prefix + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// Open decrypts a value produced by Seal. Plaintext values are returned as is.
+func (k *Keyring) Open(userID, noteID uuid.UUID, field, value string) (string, error) {
+	if !strings.HasPrefix(value, Prefix) {
+		return value, nil
+	}
+	sealed, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(value, Prefix))
+	if err != nil {
+		return "", ErrMalformedValue
+	}
+
+	aead, err := k.dataKey(userID)
+	if err != nil {
+		return "", err
+	}
+	if len(sealed) < aead.NonceSize() {
+		return "", ErrMalformedValue
+	}
+	nonce, ciphertext := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+	plaintext, err := aead.Open(nil, nonce, ciphertext, additionalData(noteID, field))
+	if err != nil {
+		return "", err
+	}
+	return string(plaintext), nil
+}
+
+func additionalData(noteID uuid.UUID, field string) []byte {
+	return []byte(noteID.String() + "/" + field)
+}
+
+// dataKey returns the user's data key, creating it on first use.
+func (k *Keyring) dataKey(userID uuid.UUID) (cipher.AEAD, error) {
+	k.mu.RLock()
+	aead, ok := k.dataKeys[userID]
+	k.mu.RUnlock()
+	if ok {
+		return aead, nil
+	}
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if aead, ok := k.dataKeys[userID]; ok {
+		return aead, nil
+	}
+
+	key, err := k.loadOrCreate(userID)
+	if err != nil {
+		return nil, err
+	}
+	aead, err = newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	k.dataKeys[userID] = aead
+	return aead, nil
+}
+
+// loadOrCreate reads the user's wrapped data key, generating one if there is
+// none. Keys are written outside any caller's transaction so a rollback can
+// never discard a key that already encrypted something.
+func (k *Keyring) loadOrCreate(userID uuid.UUID) ([]byte, error) {
+	fresh := make([]byte, keySize)
+	if _, err := rand.Read(fresh); err != nil {
+		return nil, err
+	}
+	wrapped, err := wrap(k.current, userID, fresh)
+	if err != nil {
+		return nil, err
+	}
+
+	// Concurrent instances may race to create the key; the first one wins
+	candidate := models.DataKey{UserID: userID, MasterKeyID: k.current.ID, WrappedKey: wrapped}
+	if err := k.db.Clauses(clause.OnConflict{DoNothing: true}).Create(&candidate).Error; err != nil {
+		return nil, err
+	}
+
+	var stored models.DataKey
+	if err := k.db.Where("user_id = ?", userID).First(&stored).Error; err != nil {
+		return nil, err
+	}
+	return k.unwrap(stored)
+}
+
+func (k *Keyring) masterKey(id string) (MasterKey, bool) {
+	if id == k.current.ID {
+		return k.current, true
+	}
+	key, ok := k.previous[id]
+	return key, ok
+}
+
+func (k *Keyring) unwrap(stored models.DataKey) ([]byte, error) {
+	master, ok := k.masterKey(stored.MasterKeyID)
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrUnknownMasterKey, stored.MasterKeyID)
+	}
+	aead, err := newAEAD(master.Key)
+	if err != nil {
+		return nil, err
+	}
+
+	sealed, err := base64.StdEncoding.DecodeString(stored.WrappedKey)
+	if err != nil || len(sealed) < aead.NonceSize() {
+		return nil, ErrMalformedValue
+	}
+	nonce, ciphertext := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+	return aead.Open(nil, nonce, ciphertext, []byte(stored.UserID.String()))
+}
+
+func wrap(master MasterKey, userID uuid.UUID, key []byte) (string, error) {
+	aead, err := newAEAD(master.Key)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := aead.Seal(nonce, nonce, key, []byte(userID.String()))
+	return base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// Rotate re-wraps every data key that is not yet wrapped with the current
+// master key and returns how many were updated. Note contents are untouched.
+func (k *Keyring) Rotate() (int, error) {
+	var stale []models.DataKey
+	if err := k.db.Where("master_key_id <> ?", k.current.ID).Find(&stale).Error; err != nil {
+		return 0, err
+	}
+
+	rotated := 0
+	for _, stored := range stale {
+		key, err := k.unwrap(stored)
+		if err != nil {
+			return rotated, fmt.Errorf("user %s: %w", stored.UserID, err)
+		}
+		wrapped, err := wrap(k.current, stored.UserID, key)
+		if err != nil {
+			return rotated, err
+		}
+		err = k.db.Model(&models.DataKey{}).
+			Where("user_id = ? AND master_key_id = ?", stored.UserID, stored.MasterKeyID).
+			Updates(map[string]interface{}{"master_key_id": k.current.ID, "wrapped_key": wrapped}).Error
+		if err != nil {
+			return rotated, err
+		}
+		rotated++
+	}
+	return rotated, nil
+}
@@ -0,0 +1,217 @@
+package envelope
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"NoteApi/internal/models"
+
+	"github.com/glebarez/sqlite"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// testDB opens an empty SQLite database with the data_keys table.
+func testDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "keys.db")), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AutoMigrate(&models.DataKey{}); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if sqlDB, err := db.DB(); err == nil {
+			sqlDB.Close()
+		}
+	})
+	return db
+}
+
+func newMasterKey(t *testing.T, id string) MasterKey {
+	t.Helper()
+	key := make([]byte, keySize)
+	if _, err := rand.Read(key); err != nil {
+		t.Fatal(err)
+	}
+	return MasterKey{ID: id, Key: key}
+}
+
+// formatKey writes key the way ParseMasterKey reads it.
+func formatKey(key MasterKey) string {
+	return key.ID + ":" + base64.StdEncoding.EncodeToString(key.Key)
+}
+
+func TestSealOpen(t *testing.T) {
+	keyring := NewKeyring(testDB(t), newMasterKey(t, "k1"))
+	userID, noteID := uuid.New(), uuid.New()
+
+	sealed, err := keyring.Seal(userID, noteID, "content", "meet at noon")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(sealed, Prefix) || strings.Contains(sealed, "noon") {
+		t.Fatalf("sealed = %q", sealed)
+	}
+	again, _ := keyring.Seal(userID, noteID, "content", "meet at noon")
+	if again == sealed {
+		t.Fatal("sealing twice gave the same ciphertext")
+	}
+
+	opened, err := keyring.Open(userID, noteID, "content", sealed)
+	if err != nil || opened != "meet at noon" {
+		t.Fatalf("Open = %q, %v", opened, err)
+	}
+
+	// Values written before encryption was enabled pass through
+	if plain, err := keyring.Open(userID, noteID, "content", "old note"); err != nil || plain != "old note" {
+		t.Fatalf("Open(plaintext) = %q, %v", plain, err)
+	}
+}
+
+func TestDataKeysPersist(t *testing.T) {
+	db := testDB(t)
+	master := newMasterKey(t, "k1")
+	userID, noteID := uuid.New(), uuid.New()
+
+	sealed, err := NewKeyring(db, master).Seal(userID, noteID, "title", "Groceries")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A fresh keyring, as after a restart, loads the stored data key
+	opened, err := NewKeyring(db, master).Open(userID, noteID, "title", sealed)
+	if err != nil || opened != "Groceries" {
+		t.Fatalf("Open after restart = %q, %v", opened, err)
+	}
+}
+
+func TestRotate(t *testing.T) {
+	db := testDB(t)
+	oldKey, newKey := newMasterKey(t, "old"), newMasterKey(t, "new")
+	userID, noteID := uuid.New(), uuid.New()
+
+	sealed, err := NewKeyring(db, oldKey).Seal(userID, noteID, "content", "before rotation")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Until rotate-keys runs, the previous key still unwraps the data key
+	rotating := NewKeyring(db, newKey, oldKey)
+	if opened, err := rotating.Open(userID, noteID, "content", sealed); err != nil || opened != "before rotation" {
+		t.Fatalf("Open with the previous key = %q, %v", opened, err)
+	}
+	if _, err := NewKeyring(db, newKey).Open(userID, noteID, "content", sealed); !errors.Is(err, ErrUnknownMasterKey) {
+		t.Fatalf("Open without the previous key: err = %v, want ErrUnknownMasterKey", err)
+	}
+
+	rotated, err := rotating.Rotate()
+	if err != nil || rotated != 1 {
+		t.Fatalf("Rotate = %d, %v", rotated, err)
+	}
+	if rotated, err := rotating.Rotate(); err != nil || rotated != 0 {
+		t.Fatalf("second Rotate = %d, %v", rotated, err)
+	}
+
+	// Afterwards the old key can be dropped; notes are not re-encrypted
+	if opened, err := NewKeyring(db, newKey).Open(userID, noteID, "content", sealed); err != nil || opened != "before rotation" {
+		t.Fatalf("Open after rotation = %q, %v", opened, err)
+	}
+	if _, err := NewKeyring(db, oldKey).Open(userID, noteID, "content", sealed); !errors.Is(err, ErrUnknownMasterKey) {
+		t.Fatalf("Open with only the old key: err = %v, want ErrUnknownMasterKey", err)
+	}
+}
+
+func TestOpenDetectsTampering(t *testing.T) {
+	keyring := NewKeyring(testDB(t), newMasterKey(t, "k1"))
+	userID, noteID := uuid.New(), uuid.New()
+	sealed, err := keyring.Seal(userID, noteID, "content", "pay 10 EUR")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	raw, _ := base64.StdEncoding.DecodeString(strings.TrimPrefix(sealed, Prefix))
+	flipped := append([]byte(nil), raw...)
+	flipped[len(flipped)/2] ^= 0x01
+
+	tests := []struct {
+		name          string
+		userID        uuid.UUID
+		noteID        uuid.UUID
+		field, value  string
+		wantMalformed bool
+	}{
+		{"flipped bit", userID, noteID, "content", Prefix + base64.StdEncoding.EncodeToString(flipped), false},
+		{"truncated", userID, noteID, "content", Prefix + base64.StdEncoding.EncodeToString(raw[:len(raw)-1]), false},
+		{"shorter than a nonce", userID, noteID, "content", Prefix + base64.StdEncoding.EncodeToString(raw[:4]), true},
+		{"not base64", userID, noteID, "content", Prefix + "!!!", true},
+		{"moved to another field", userID, noteID, "title", sealed, false},
+		{"moved to another note", userID, uuid.New(), "content", sealed, false},
+		{"moved to another user", uuid.New(), noteID, "content", sealed, false},
+	}
+	for _, tt := range tests {
+		opened, err := keyring.Open(tt.userID, tt.noteID, tt.field, tt.value)
+		if err == nil {
+			t.Errorf("%s: Open = %q, want an error", tt.name, opened)
+			continue
+		}
+		if tt.wantMalformed && !errors.Is(err, ErrMalformedValue) {
+			t.Errorf("%s: err = %v, want ErrMalformedValue", tt.name, err)
+		}
+	}
+}
+
+func TestParseMasterKey(t *testing.T) {
+	key := newMasterKey(t, "2026-01")
+	short := base64.StdEncoding.EncodeToString(make([]byte, 16))
+
+	tests := []struct {
+		value string
+		ok    bool
+	}{
+		{formatKey(key), true},
+		{"  " + formatKey(key) + "\n", true},
+		{base64.StdEncoding.EncodeToString(key.Key), false},
+		{":" + base64.StdEncoding.EncodeToString(key.Key), false},
+		{"k1:" + short, false},
+		{"k1:not base64!", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		parsed, err := ParseMasterKey(tt.value)
+		if tt.ok {
+			if err != nil || parsed.ID != key.ID || string(parsed.Key) != string(key.Key) {
+				t.Errorf("ParseMasterKey(%q) = %+v, %v", tt.value, parsed, err)
+			}
+			continue
+		}
+		if !errors.Is(err, ErrMalformedKey) {
+			t.Errorf("ParseMasterKey(%q) err = %v, want ErrMalformedKey", tt.value, err)
+		}
+	}
+}
+
+func TestParseKeyring(t *testing.T) {
+	db := testDB(t)
+	current, previous := newMasterKey(t, "new"), newMasterKey(t, "old")
+
+	if keyring, err := ParseKeyring(db, "", nil); keyring != nil || err != nil {
+		t.Fatalf("ParseKeyring without a key = %v, %v; want nil, nil", keyring, err)
+	}
+	keyring, err := ParseKeyring(db, formatKey(current), []string{formatKey(previous)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := keyring.masterKey("old"); !ok {
+		t.Fatal("previous key not kept")
+	}
+	if _, err := ParseKeyring(db, formatKey(current), []string{"old:short"}); !errors.Is(err, ErrMalformedKey) {
+		t.Fatalf("ParseKeyring with a bad previous key: err = %v", err)
+	}
+}
@@ -0,0 +1,76 @@
+package handlers
+
+import (
+	"NoteApi/internal/audit"
+	"NoteApi/internal/middleware"
+	"NoteApi/internal/models"
+	"encoding/json"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type auditEventResponse struct {
+	models.AuditEvent
+	Changes json.RawMessage `json:"changes"`
+}
+
+// ListAuditEvents returns the caller's audit log, newest first. It accepts
+// action (comma separated), target_type, target_id, since and until (RFC 3339),
+// limit and the cursor returned as next_cursor by the previous page.
+func (s *Server) ListAuditEvents(c *gin.Context) {
+	userID, ok := userIDFromContext(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User ID not found"})
+		return
+	}
+
+	filter := audit.Filter{
+		TargetType: c.Query("target_type"),
+		TargetID:   c.Query("target_id"),
+		Cursor:     c.Query("cursor"),
+	}
+	if actions := c.Query("action"); actions != "" {
+		filter.Actions = strings.Split(actions, ",")
+	}
+	for name, dst := range map[string]*time.Time{"since": &filter.Since, "until": &filter.Until} {
+		value := c.Query(name)
+		if value == "" {
+			continue
+		}
+		parsed, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid " + name + ": expected an RFC 3339 time"})
+			return
+		}
+		*dst = parsed
+	}
+	if limit := c.Query("limit"); limit != "" {
+		parsed, err := strconv.Atoi(limit)
+		if err != nil || parsed <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid limit"})
+			return
+		}
+		filter.Limit = parsed
+	}
+
+	events, next, err := s.Accounts.ListAuditEvents(c.Request.Context(), userID, filter)
+	if errors.Is(err, audit.ErrInvalidCursor) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid cursor"})
+		return
+	}
+	if err != nil {
+		middleware.Logger(c).Error("Failed to list audit events", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list audit events"})
+		return
+	}
+
+	response := make([]auditEventResponse, len(events))
+	for i, event := range events {
+		response[i] = auditEventResponse{AuditEvent: event, Changes: json.RawMessage(event.Changes)}
+	}
+	c.JSON(http.StatusOK, gin.H{"events": response, "next_cursor": next})
+}
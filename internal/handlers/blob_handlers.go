@@ -0,0 +1,35 @@
+package handlers
+
+import (
+	"NoteApi/internal/storage"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"net/http"
+)
+
+// HeadBlob lets clients check whether they have already uploaded content
+// with the given SHA-256 digest, so they can link it instead of sending it
+// again. Content other users uploaded is reported as missing.
+func (s *Server) HeadBlob(c *gin.Context) {
+	userID, ok := userIDFromContext(c)
+	if !ok {
+		c.Status(http.StatusUnauthorized)
+		return
+	}
+
+	digest := c.Param("sha256")
+	_, err := s.Notes.FindBlob(c.Request.Context(), userID, digest)
+	switch {
+	case errors.Is(err, storage.ErrInvalidDigest):
+		c.Status(http.StatusBadRequest)
+		return
+	case errors.Is(err, storage.ErrBlobNotFound):
+		c.Status(http.StatusNotFound)
+		return
+	case err != nil:
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+	c.Header("ETag", `"`+digest+`"`)
+	c.Status(http.StatusOK)
+}
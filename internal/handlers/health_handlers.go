@@ -0,0 +1,115 @@
+package handlers
+
+import (
+	"NoteApi/internal/storage"
+	"context"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// ReadinessTimeout bounds each readiness check, so a hung dependency makes
+// /readyz fail instead of hang.
+const ReadinessTimeout = 2 * time.Second
+
+// checkResult is one entry in the /readyz breakdown.
+type checkResult struct {
+	Status    string      `json:"status"`
+	Error     string      `json:"error,omitempty"`
+	LatencyMS float64     `json:"latency_ms"`
+	Details   interface{} `json:"details,omitempty"`
+}
+
+// readinessCheck returns optional details, or an error when the dependency
+// is not usable.
+type readinessCheck func(ctx context.Context) (interface{}, error)
+
+func (s *Server) readinessChecks() map[string]readinessCheck {
+	return map[string]readinessCheck{
+		"database": func(ctx context.Context) (interface{}, error) {
+			return nil, s.Database.Ping(ctx)
+		},
+		"migrations": func(ctx context.Context) (interface{}, error) {
+			version, err := s.Database.SchemaVersion(ctx)
+			return gin.H{"version": version}, err
+		},
+		"blob_store": func(ctx context.Context) (interface{}, error) {
+			return gin.H{"root": storage.Root}, storage.CheckWritable()
+		},
+		"websocket_hub": func(ctx context.Context) (interface{}, error) {
+			connections, err := s.Events.Health(ctx)
+			return gin.H{"connections": connections}, err
+		},
+	}
+}
+
+// Livez reports that the process is up and serving. It checks no
+// dependencies, so a database outage does not get healthy pods restarted.
+func (s *Server) Livez(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"status": "ok"})
+}
+
+// Readyz runs every readiness check in parallel and returns 200 only when all
+// of them pass, 503 otherwise, with the result of each check.
+func (s *Server) Readyz(c *gin.Context) {
+	checks := s.readinessChecks()
+	results := make(map[string]checkResult, len(checks))
+	var resultsMu sync.Mutex
+	var wg sync.WaitGroup
+
+	for name, check := range checks {
+		wg.Add(1)
+		go func(name string, check readinessCheck) {
+			defer wg.Done()
+			result := runCheck(c.Request.Context(), check)
+			resultsMu.Lock()
+			results[name] = result
+			resultsMu.Unlock()
+		}(name, check)
+	}
+	wg.Wait()
+
+	status, code := "ok", http.StatusOK
+	for _, result := range results {
+		if result.Status != "ok" {
+			status, code = "unavailable", http.StatusServiceUnavailable
+		}
+	}
+	c.JSON(code, gin.H{"status": status, "checks": results})
+}
+
+// runCheck runs check with ReadinessTimeout. A check that does not return in
+// time is reported as failed and left to finish in the background.
+func runCheck(parent context.Context, check readinessCheck) checkResult {
+	ctx, cancel := context.WithTimeout(parent, ReadinessTimeout)
+	defer cancel()
+
+	type outcome struct {
+		details interface{}
+		err     error
+	}
+	done := make(chan outcome, 1)
+	start := time.Now()
+	go func() {
+		details, err := check(ctx)
+		done <- outcome{details, err}
+	}()
+
+	var result checkResult
+	select {
+	case out := <-done:
+		result.Details = out.details
+		if out.err != nil {
+			result.Error = out.err.Error()
+		}
+	case <-ctx.Done():
+		result.Error = "timed out after " + ReadinessTimeout.String()
+	}
+	result.LatencyMS = float64(time.Since(start).Microseconds()) / 1000
+	result.Status = "ok"
+	if result.Error != "" {
+		result.Status = "failed"
+	}
+	return result
+}
@@ -1,52 +1,268 @@
 package handlers
 
 import (
-    "github.com/gin-gonic/gin"
-    "github.com/google/uuid"
-    "net/http"
-    "path/filepath"
-    "strings"
+	"NoteApi/internal/audit"
+	"NoteApi/internal/auth"
+	"NoteApi/internal/metrics"
+	"NoteApi/internal/middleware"
+	"NoteApi/internal/models"
+	"NoteApi/internal/storage"
+	"NoteApi/internal/tracing"
+	"NoteApi/pkg/utils"
+	"context"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"go.opentelemetry.io/otel/attribute"
+	"io"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"strings"
 )
 
-const (
-    MaxUploadSize = 10 << 20 // 10 MB
-    UploadPath    = "uploads"
-)
+const UploadPath = storage.UploadPath
+
+// UploadFile stores a file from the "file" form field ("image" is accepted for
+// older clients). The type is sniffed from the content and must be on the
+// allowlist from storage.AllowedTypes.
+func (s *Server) UploadFile(c *gin.Context) {
+	userID, ok := userIDFromContext(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User ID not found"})
+		return
+	}
+
+	// Digest references may also arrive as a plain urlencoded form
+	if err := s.parseMultipartForm(c); err != nil && !errors.Is(err, http.ErrNotMultipart) {
+		respondFormError(c, err)
+		return
+	}
+
+	// Clients that already uploaded this content (HEAD /blobs/:sha256) can
+	// reference it by digest instead of sending the file again.
+	if digest := c.Request.FormValue("sha256"); digest != "" {
+		blob, err := s.Notes.FindBlob(c.Request.Context(), userID, digest)
+		if err != nil {
+			respondStorageError(c, err)
+			return
+		}
+		if !s.checkQuota(c, userID, blob.Size, 0) {
+			return
+		}
 
-func UploadImage(c *gin.Context) {
-    // Set a lower memory limit for multipart forms (default is 32 MiB)
-    c.Request.ParseMultipartForm(MaxUploadSize)
+		upload, err := s.Notes.LinkUpload(c.Request.Context(), userID, digest, c.Request.FormValue("filename"), audit.ActorFromContext(c))
+		if err != nil {
+			respondStorageError(c, err)
+			return
+		}
+		go s.notifyQuota(context.WithoutCancel(c.Request.Context()), userID)
+		s.respondUpload(c, upload)
+		return
+	}
 
-    file, header, err := c.Request.FormFile("image")
-    if err != nil {
-        c.JSON(http.StatusBadRequest, gin.H{"error": "No file uploaded"})
-        return
-    }
-    defer file.Close()
+	file, header, err := c.Request.FormFile("file")
+	if err == http.ErrMissingFile {
+		file, header, err = c.Request.FormFile("image")
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "No file uploaded"})
+		return
+	}
+	defer file.Close()
 
-    // Validate file size
-    if header.Size > MaxUploadSize {
-        c.JSON(http.StatusBadRequest, gin.H{"error": "File is too large"})
-        return
-    }
+	contentType, ok := sniffUpload(c, file, header.Size, false)
+	if !ok {
+		return
+	}
 
-    // Validate file type
-    if !strings.HasPrefix(header.Header.Get("Content-Type"), "image/") {
-        c.JSON(http.StatusBadRequest, gin.H{"error": "File is not an image"})
-        return
-    }
+	if !s.checkQuota(c, userID, header.Size, 0) {
+		return
+	}
 
-    // Generate a unique filename
-    ext := filepath.Ext(header.Filename)
-    newFilename := uuid.New().String() + ext
-    dst := filepath.Join(UploadPath, newFilename)
+	upload, err := s.Notes.SaveUpload(c.Request.Context(), userID, file, header.Filename, contentType, audit.ActorFromContext(c))
+	if err != nil {
+		middleware.Logger(c).Error("Failed to save file", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save file"})
+		return
+	}
+	metrics.UploadReceived(metrics.UploadMultipart, header.Size)
 
-    if err := c.SaveUploadedFile(header, dst); err != nil {
-        c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save file"})
-        return
-    }
+	go s.notifyQuota(context.WithoutCancel(c.Request.Context()), userID)
+	s.respondUpload(c, upload)
+}
+
+// parseMultipartForm parses the request body inside a span; large forms
+// spill to temporary files and can account for much of a request. Bodies over
+// the configured request size fail with an *http.MaxBytesError.
+func (s *Server) parseMultipartForm(c *gin.Context) error {
+	_, span := tracing.Start(c.Request.Context(), "multipart.parse",
+		attribute.Int64("http.request.body.size", c.Request.ContentLength))
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, s.Config.Uploads.MaxRequestSize)
+	err := c.Request.ParseMultipartForm(s.Config.Uploads.MaxFormMemory)
+	tracing.End(span, err)
+	return err
+}
+
+// respondFormError writes a 413 when the request body was over the size
+// limit and a 400 for any other malformed form.
+func respondFormError(c *gin.Context, err error) {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		metrics.UploadRejected(metrics.RejectSize)
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "Request body too large", "max_bytes": tooLarge.Limit})
+		return
+	}
+	middleware.Logger(c).Warn("Failed to parse form", "error", err)
+	c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to parse form"})
+}
+
+// sniffUpload detects the content type of file and checks it against the
+// allowlist, writing a 415 or 413 when it is rejected. imageOnly additionally
+// requires an image, as for dashboard images.
+func sniffUpload(c *gin.Context, file io.ReadSeeker, size int64, imageOnly bool) (string, bool) {
+	contentType, err := storage.Sniff(file)
+	if err != nil {
+		middleware.Logger(c).Error("Failed to detect file type", "error", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to read file"})
+		return "", false
+	}
+
+	if imageOnly && !strings.HasPrefix(contentType, "image/") {
+		metrics.UploadRejected(metrics.RejectType)
+		c.JSON(http.StatusUnsupportedMediaType, gin.H{"error": "File is not an image", "content_type": contentType})
+		return "", false
+	}
+
+	if err := storage.CheckType(contentType, size); err != nil {
+		respondStorageError(c, err)
+		return "", false
+	}
+	return contentType, true
+}
 
-    // Return the path to be stored in the Note model
-    c.JSON(http.StatusOK, gin.H{"dashboard_path": dst})
+// respondUpload returns a signed URL for display along with the raw storage
+// path, the content digest and any extracted metadata.
+func (s *Server) respondUpload(c *gin.Context, upload models.Upload) {
+	response := gin.H{
+		"dashboard_path": utils.SignPath(upload.Path),
+		"path":           upload.Path,
+		"sha256":         upload.Digest,
+		"filename":       upload.Filename,
+		"content_type":   upload.ContentType,
+		"size":           upload.Size,
+	}
+	if blob, err := s.Notes.FindBlob(c.Request.Context(), upload.UserID, upload.Digest); err == nil {
+		response["metadata"] = storage.Metadata{
+			PageCount:       blob.PageCount,
+			DurationSeconds: blob.DurationSeconds,
+			RowCount:        blob.RowCount,
+		}
+	}
+	c.JSON(http.StatusOK, response)
 }
 
+func respondStorageError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, storage.ErrInvalidDigest):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid sha256 digest"})
+	case errors.Is(err, storage.ErrBlobNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Blob not found"})
+	case errors.Is(err, storage.ErrTypeNotAllowed):
+		metrics.UploadRejected(metrics.RejectType)
+		c.JSON(http.StatusUnsupportedMediaType, gin.H{"error": err.Error()})
+	case errors.Is(err, storage.ErrFileTooLarge):
+		metrics.UploadRejected(metrics.RejectSize)
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": err.Error()})
+	default:
+		middleware.Logger(c).Error("Failed to store upload", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save file"})
+	}
+}
+
+// DownloadUpload serves a stored file. Requests carrying a valid signature
+// (see utils.SignPath) are served directly; otherwise the caller must own the
+// upload or the note that references it.
+func (s *Server) DownloadUpload(c *gin.Context) {
+	filename := c.Param("filename")
+	if filename == "" || filename == "." || filename == ".." || filepath.Base(filename) != filename {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid file name"})
+		return
+	}
+	path := filepath.ToSlash(filepath.Join(UploadPath, filename))
+
+	if signed, _ := c.Get("signed_url"); signed != true {
+		userID, ok := userIDFromContext(c)
+		if !ok {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "User ID not found"})
+			return
+		}
+		allowed, err := s.Notes.CanAccessUpload(c.Request.Context(), userID, path)
+		if err != nil {
+			middleware.Logger(c).Error("Failed to check upload access", "error", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read file"})
+			return
+		}
+		if !allowed {
+			c.JSON(http.StatusNotFound, gin.H{"error": "File not found"})
+			return
+		}
+	}
+
+	file, err := s.Notes.OpenUpload(c.Request.Context(), path)
+	if err != nil {
+		if !errors.Is(err, storage.ErrUploadNotFound) {
+			middleware.Logger(c).Error("Failed to open upload", "error", err)
+		}
+		c.JSON(http.StatusNotFound, gin.H{"error": "File not found"})
+		return
+	}
+	defer file.Close()
+	upload := file.Upload
+
+	if upload.ContentType != "" {
+		c.Header("Content-Type", upload.ContentType)
+	}
+	c.Header("Content-Disposition", contentDisposition(upload, filename))
+	c.Header("X-Content-Type-Options", "nosniff")
+	// Files opened directly in the browser must not run scripts with the
+	// API's origin, as an SVG or HTML upload otherwise could
+	c.Header("Content-Security-Policy", "sandbox")
+	if upload.Digest != "" {
+		c.Header("ETag", `"`+upload.Digest+`"`)
+	}
+	c.Header("Cache-Control", "private, max-age=300")
+	http.ServeContent(c.Writer, c.Request, filename, file.ModTime, file)
+}
+
+// contentDisposition shows images inline so <img> tags work and offers
+// everything else as a download under its original name. SVG can carry
+// scripts, so it is always a download; <img> tags still render it.
+func contentDisposition(upload models.Upload, fallback string) string {
+	name := upload.Filename
+	if name == "" {
+		name = fallback
+	}
+	disposition := "attachment"
+	inlineImage := strings.HasPrefix(upload.ContentType, "image/") && upload.ContentType != "image/svg+xml"
+	if inlineImage || (upload.ContentType == "" && upload.Digest == "") {
+		disposition = "inline"
+	}
+	return mime.FormatMediaType(disposition, map[string]string{"filename": name})
+}
+
+// signNote returns a copy of note whose dashboard path is a signed URL.
+func signNote(note models.Note) models.Note {
+	note.DashboardPath = utils.SignPath(note.DashboardPath)
+	return note
+}
+
+// userIDFromContext returns the authenticated user's ID from the principal
+// stored by the auth middleware.
+func userIDFromContext(c *gin.Context) (uuid.UUID, bool) {
+	principal, ok := auth.FromContext(c)
+	if !ok {
+		return uuid.Nil, false
+	}
+	return principal.UserID, true
+}
@@ -0,0 +1,135 @@
+package handlers
+
+import (
+	"NoteApi/internal/audit"
+	"NoteApi/internal/quota"
+	"crypto/sha256"
+	"encoding/hex"
+	"github.com/google/uuid"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+type uploadResponse struct {
+	DashboardPath string `json:"dashboard_path"`
+	Path          string `json:"path"`
+	SHA256        string `json:"sha256"`
+	Filename      string `json:"filename"`
+	ContentType   string `json:"content_type"`
+	Size          int64  `json:"size"`
+}
+
+func TestUploadFile(t *testing.T) {
+	ts := newTestServer(t)
+	userID := uuid.New()
+
+	body, contentType := multipartForm(t, nil, formFile{field: "file", name: "pixel.png", content: pngImage})
+	var upload uploadResponse
+	decode(t, ts.do(userID, http.MethodPost, "/upload", body, contentType), http.StatusOK, &upload)
+
+	sum := sha256.Sum256(pngImage)
+	if upload.SHA256 != hex.EncodeToString(sum[:]) {
+		t.Fatalf("sha256 = %q", upload.SHA256)
+	}
+	if upload.ContentType != "image/png" || upload.Filename != "pixel.png" || upload.Size != int64(len(pngImage)) {
+		t.Fatalf("uploaded %+v", upload)
+	}
+	if !strings.HasPrefix(upload.DashboardPath, upload.Path+"?") {
+		t.Fatalf("dashboard_path = %q, want a signed %q", upload.DashboardPath, upload.Path)
+	}
+	if content, ok := ts.repo.Content(upload.SHA256); !ok || string(content) != string(pngImage) {
+		t.Fatalf("stored content = %q, %v", content, ok)
+	}
+
+	events := ts.repo.AuditEvents()
+	if len(events) != 1 || events[0].Action != audit.ActionUploadCreate || events[0].ActorID != userID {
+		t.Fatalf("audit events = %+v", events)
+	}
+}
+
+func TestUploadFileLegacyImageField(t *testing.T) {
+	ts := newTestServer(t)
+
+	body, contentType := multipartForm(t, nil, formFile{field: "image", name: "pixel.png", content: pngImage})
+	decode(t, ts.do(uuid.New(), http.MethodPost, "/upload", body, contentType), http.StatusOK, nil)
+}
+
+func TestUploadFileRejected(t *testing.T) {
+	tests := []struct {
+		name  string
+		files []formFile
+		want  int
+	}{
+		{"no file", nil, http.StatusBadRequest},
+		{"type not allowed", []formFile{{field: "file", name: "run.sh", content: []byte("#!/bin/sh\necho hi\n")}}, http.StatusUnsupportedMediaType},
+		{"too large for its type", []formFile{{field: "file", name: "big.png", content: append(append([]byte(nil), pngImage...), make([]byte, 10<<20)...)}}, http.StatusRequestEntityTooLarge},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := newTestServer(t)
+			userID := uuid.New()
+
+			body, contentType := multipartForm(t, map[string]string{"filename": "x"}, tt.files...)
+			decode(t, ts.do(userID, http.MethodPost, "/upload", body, contentType), tt.want, nil)
+			if events := ts.repo.AuditEvents(); len(events) != 0 {
+				t.Fatalf("rejected upload was recorded: %+v", events)
+			}
+		})
+	}
+}
+
+func TestUploadFileOverQuota(t *testing.T) {
+	ts := newTestServer(t)
+	maxBytes, maxNotes := quota.MaxBytes(), quota.MaxNotes()
+	quota.SetLimits(int64(len(pngImage))-1, maxNotes)
+	t.Cleanup(func() { quota.SetLimits(maxBytes, maxNotes) })
+
+	body, contentType := multipartForm(t, nil, formFile{field: "file", name: "pixel.png", content: pngImage})
+	decode(t, ts.do(uuid.New(), http.MethodPost, "/upload", body, contentType), http.StatusRequestEntityTooLarge, nil)
+}
+
+func TestUploadFileByDigest(t *testing.T) {
+	ts := newTestServer(t)
+	owner := uuid.New()
+
+	body, contentType := multipartForm(t, nil, formFile{field: "file", name: "pixel.png", content: pngImage})
+	var first uploadResponse
+	decode(t, ts.do(owner, http.MethodPost, "/upload", body, contentType), http.StatusOK, &first)
+
+	form := url.Values{"sha256": {first.SHA256}, "filename": {"again.png"}}
+	var second uploadResponse
+	decode(t, ts.do(owner, http.MethodPost, "/upload", strings.NewReader(form.Encode()), "application/x-www-form-urlencoded"), http.StatusOK, &second)
+	if second.SHA256 != first.SHA256 || second.Path == first.Path || second.Filename != "again.png" {
+		t.Fatalf("linked %+v after %+v", second, first)
+	}
+
+	// Knowing a digest is not enough to reference someone else's content
+	decode(t, ts.do(uuid.New(), http.MethodPost, "/upload", strings.NewReader(form.Encode()), "application/x-www-form-urlencoded"), http.StatusNotFound, nil)
+
+	form.Set("sha256", "not-a-digest")
+	decode(t, ts.do(owner, http.MethodPost, "/upload", strings.NewReader(form.Encode()), "application/x-www-form-urlencoded"), http.StatusBadRequest, nil)
+}
+
+func TestDownloadUpload(t *testing.T) {
+	ts := newTestServer(t)
+	owner := uuid.New()
+
+	body, contentType := multipartForm(t, nil, formFile{field: "file", name: "pixel.png", content: pngImage})
+	var upload uploadResponse
+	decode(t, ts.do(owner, http.MethodPost, "/upload", body, contentType), http.StatusOK, &upload)
+
+	w := ts.do(owner, http.MethodGet, "/"+upload.Path, nil, "")
+	if w.Code != http.StatusOK || w.Body.String() != string(pngImage) {
+		t.Fatalf("download = %d %q", w.Code, w.Body.String())
+	}
+	if got := w.Header().Get("Content-Type"); got != "image/png" {
+		t.Fatalf("Content-Type = %q", got)
+	}
+	if got := w.Header().Get("ETag"); got != `"`+upload.SHA256+`"` {
+		t.Fatalf("ETag = %q", got)
+	}
+
+	decode(t, ts.do(uuid.New(), http.MethodGet, "/"+upload.Path, nil, ""), http.StatusNotFound, nil)
+}
@@ -3,43 +3,32 @@
 package handlers
 
 import (
-	"NoteApi/cmd/websocket"
-	"NoteApi/internal/database"
+	"NoteApi/internal/audit"
+	"NoteApi/internal/metrics"
+	"NoteApi/internal/middleware"
 	"NoteApi/internal/models"
+	"NoteApi/internal/repository"
+	"NoteApi/internal/storage"
+	"NoteApi/internal/workspace"
+	"context"
+	"errors"
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
-	"io"
-	"log"
+	"log/slog"
 	"net/http"
-	"os"
-	"path/filepath"
+	"strings"
 )
 
-func CreateNote(c *gin.Context) {
-	// Parse the multipart form
-	userID, exists := c.Get("user_id")
-	if !exists {
+func (s *Server) CreateNote(c *gin.Context) {
+	userIDUUID, ok := userIDFromContext(c)
+	if !ok {
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "User ID not found"})
 		return
 	}
 
-	var userIDUUID uuid.UUID
-	switch v := userID.(type) {
-	case uuid.UUID:
-		userIDUUID = v
-	case string:
-		var err error
-		userIDUUID, err = uuid.Parse(v)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Invalid user ID format"})
-			return
-		}
-	default:
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Invalid user ID type"})
-		return
-	}
-	if err := c.Request.ParseMultipartForm(10 << 20); err != nil { // 10 MB max
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to parse form"})
+	// Parse the multipart form
+	if err := s.parseMultipartForm(c); err != nil {
+		respondFormError(c, err)
 		return
 	}
 
@@ -49,239 +38,340 @@ func CreateNote(c *gin.Context) {
 		Content: c.Request.FormValue("content"),
 	}
 
-	// Handle file upload
-	file, header, err := c.Request.FormFile("dashboard_image")
-	if err == nil {
-		// Generate a unique filename
-		fileExt := filepath.Ext(header.Filename)
-		newFilename := uuid.New().String() + fileExt
-
-		// Create the uploads directory if it doesn't exist
-		uploadsDir := "./uploads"
-		if err := os.MkdirAll(uploadsDir, os.ModePerm); err != nil {
-			c.JSON(
-				http.StatusInternalServerError,
-				gin.H{"error": "Failed to create uploads directory"},
-			)
-			return
-		}
-
-		// Create the file
-		dst, err := os.Create(filepath.Join(uploadsDir, newFilename))
+	// Notes created in a workspace belong to it; editors and above may create
+	if value := c.Request.FormValue("workspace_id"); value != "" {
+		workspaceID, err := uuid.Parse(value)
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create the file"})
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid workspace_id"})
 			return
 		}
-		defer dst.Close()
-
-		// Copy the uploaded file to the destination file
-		if _, err := io.Copy(dst, file); err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save the file"})
+		if _, err := s.Notes.RequireRole(c.Request.Context(), workspaceID, userIDUUID, models.RoleEditor); err != nil {
+			respondWorkspaceError(c, err)
 			return
 		}
+		note.WorkspaceID = &workspaceID
+	}
 
-		note.DashboardPath = filepath.Join("uploads", newFilename)
-		defer file.Close()
+	// Handle file upload
+	upload, ok := s.dashboardUpload(c, userIDUUID, note.PlainSize(), 1, 0)
+	if !ok {
+		return
+	}
+	if upload != nil {
+		note.DashboardPath = upload.Path
 	}
 
-	if err := database.DB.Create(&note).Error; err != nil {
+	if err := s.Notes.CreateNote(c.Request.Context(), &note, audit.ActorFromContext(c)); err != nil {
+		middleware.Logger(c).Error("Failed to create note", "error", err)
+		s.releaseDashboardUpload(c, upload)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create note"})
 		return
 	}
 
-	// Broadcast the new note to the user
-	websocket.BroadcastNoteUpdateToUser(note, userIDUUID)
+	s.broadcastNoteUpdate(c.Request.Context(), note)
+	go s.notifyQuota(context.WithoutCancel(c.Request.Context()), userIDUUID)
 
-	// Broadcast the updated note list to the user
-	var notes []models.Note
-	database.DB.Where("user_id = ?", userIDUUID).
-		Select("id, title, content, dashboard_path").
-		Find(&notes)
-	websocket.BroadcastNoteListToUser(notes, userIDUUID)
-
-	c.JSON(http.StatusCreated, note)
+	c.JSON(http.StatusCreated, signNote(note))
 }
 
-func GetNote(c *gin.Context) {
-	userID, _ := c.Get("user_id")
-	id := c.Param("id")
-	var note models.Note
-
-	if err := database.DB.Where("id = ? AND user_id = ?", id, userID).First(&note).Error; err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Note not found"})
+func (s *Server) GetNote(c *gin.Context) {
+	userID, ok := userIDFromContext(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User ID not found"})
+		return
+	}
+	note, err := s.Notes.FindNote(c.Request.Context(), c.Param("id"), userID, models.RoleViewer)
+	if err != nil {
+		respondWorkspaceError(c, err)
 		return
 	}
 
-	c.JSON(http.StatusOK, note)
+	c.JSON(http.StatusOK, signNote(note))
 }
 
-func UpdateNote(c *gin.Context) {
-	userID, exists := c.Get("user_id")
-	if !exists {
-		log.Println("User ID not found in context")
+func (s *Server) UpdateNote(c *gin.Context) {
+	userIDUUID, ok := userIDFromContext(c)
+	if !ok {
+		middleware.Logger(c).Error("User ID not found in context")
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "User ID not found"})
 		return
 	}
 
-	var userIDUUID uuid.UUID
-	switch v := userID.(type) {
-	case uuid.UUID:
-		userIDUUID = v
-	case string:
-		var err error
-		userIDUUID, err = uuid.Parse(v)
-		if err != nil {
-			log.Printf("Invalid user ID format: %v", err)
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Invalid user ID format"})
-			return
-		}
-	default:
-		log.Println("Invalid user ID type")
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Invalid user ID type"})
-		return
-	}
-
-	id := c.Param("id")
-	var note models.Note
-
-	if err := database.DB.Where("id = ? AND user_id = ?", id, userIDUUID).First(&note).Error; err != nil {
-		log.Printf("Note not found: %v", err)
-		c.JSON(http.StatusNotFound, gin.H{"error": "Note not found"})
+	note, err := s.Notes.FindNote(c.Request.Context(), c.Param("id"), userIDUUID, models.RoleEditor)
+	if err != nil {
+		respondWorkspaceError(c, err)
 		return
 	}
 
 	// Parse the multipart form
-	if err := c.Request.ParseMultipartForm(10 << 20); err != nil { // 10 MB max
-		log.Printf("Failed to parse form: %v", err)
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to parse form"})
+	if err := s.parseMultipartForm(c); err != nil {
+		respondFormError(c, err)
 		return
 	}
 
 	// Update note fields
+	before := note
+	previousSize := note.PlainSize()
 	note.Title = c.Request.FormValue("title")
 	note.Content = c.Request.FormValue("content")
 
-	// Handle file upload
-	file, header, err := c.Request.FormFile("dashboard_image")
-	if err == nil {
-		// Generate a unique filename
-		fileExt := filepath.Ext(header.Filename)
-		newFilename := uuid.New().String() + fileExt
-
-		// Create the uploads directory if it doesn't exist
-		uploadsDir := "./uploads"
-		if err := os.MkdirAll(uploadsDir, os.ModePerm); err != nil {
-			log.Printf("Failed to create uploads directory: %v", err)
-			c.JSON(
-				http.StatusInternalServerError,
-				gin.H{"error": "Failed to create uploads directory"},
-			)
+	// A new dashboard image frees the one it replaces, when the user
+	// uploaded that one
+	previousPath := note.DashboardPath
+	var replacedBytes int64
+	if previousPath != "" {
+		previous, err := s.Notes.FindUpload(c.Request.Context(), previousPath)
+		if err != nil && !errors.Is(err, storage.ErrUploadNotFound) {
+			middleware.Logger(c).Error("Failed to load the dashboard image", "error", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update note"})
 			return
 		}
-
-		// Create the file
-		dst, err := os.Create(filepath.Join(uploadsDir, newFilename))
-		if err != nil {
-			log.Printf("Failed to create the file: %v", err)
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create the file"})
-			return
-		}
-		defer dst.Close()
-
-		// Copy the uploaded file to the destination file
-		if _, err := io.Copy(dst, file); err != nil {
-			log.Printf("Failed to save the file: %v", err)
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save the file"})
-			return
+		if err == nil && previous.UserID == userIDUUID {
+			replacedBytes = previous.Size
 		}
+	}
 
-		note.DashboardPath = filepath.Join("uploads", newFilename)
-		defer file.Close()
-	} else if err != http.ErrMissingFile {
-		log.Printf("Failed to handle file upload: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to handle file upload"})
+	// Handle file upload
+	upload, ok := s.dashboardUpload(c, userIDUUID, note.PlainSize()-previousSize, 0, replacedBytes)
+	if !ok {
 		return
 	}
+	if upload != nil {
+		note.DashboardPath = upload.Path
+	}
 
-	if err := database.DB.Save(&note).Error; err != nil {
-		log.Printf("Failed to update note: %v", err)
+	if err := s.Notes.UpdateNote(c.Request.Context(), before, &note, audit.ActorFromContext(c)); err != nil {
+		middleware.Logger(c).Error("Failed to update note", "error", err)
+		s.releaseDashboardUpload(c, upload)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update note"})
 		return
 	}
 
-	// Broadcast the updated note to the user
-	websocket.BroadcastNoteUpdateToUser(note, userIDUUID)
+	// Drop the reference to the replaced image
+	if upload != nil && previousPath != "" {
+		if err := s.Notes.ReleaseUpload(c.Request.Context(), previousPath); err != nil {
+			middleware.Logger(c).Error("Failed to release previous dashboard image", "error", err)
+		}
+	}
 
-	// Broadcast the updated note list to the user
-	var notes []models.Note
-	database.DB.Where("user_id = ?", userIDUUID).
-		Select("id, title, content, dashboard_path").
-		Find(&notes)
-	websocket.BroadcastNoteListToUser(notes, userIDUUID)
+	s.broadcastNoteUpdate(c.Request.Context(), note)
+	go s.notifyQuota(context.WithoutCancel(c.Request.Context()), userIDUUID)
 
-	c.JSON(http.StatusOK, note)
+	c.JSON(http.StatusOK, signNote(note))
 }
 
-func DeleteNote(c *gin.Context) {
-	userID, exists := c.Get("user_id")
-	if !exists {
-		log.Println("User ID not found in context")
+// DeleteNote moves a note to the trash, from where it can be restored until
+// `noteapi purge-trash` deletes it for good together with its files.
+func (s *Server) DeleteNote(c *gin.Context) {
+	userIDUUID, ok := userIDFromContext(c)
+	if !ok {
+		middleware.Logger(c).Error("User ID not found in context")
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "User ID not found"})
 		return
 	}
 
-	var userIDUUID uuid.UUID
-	switch v := userID.(type) {
-	case uuid.UUID:
-		userIDUUID = v
-	case string:
-		var err error
-		userIDUUID, err = uuid.Parse(v)
-		if err != nil {
-			log.Printf("Invalid user ID format: %v", err)
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Invalid user ID format"})
-			return
-		}
-	default:
-		log.Println("Invalid user ID type")
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Invalid user ID type"})
+	note, err := s.Notes.FindNote(c.Request.Context(), c.Param("id"), userIDUUID, models.RoleEditor)
+	if err != nil {
+		respondWorkspaceError(c, err)
 		return
 	}
 
-	id := c.Param("id")
-	var note models.Note
+	if err := s.Notes.DeleteNote(c.Request.Context(), &note, audit.ActorFromContext(c)); err != nil {
+		middleware.Logger(c).Error("Failed to delete note", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete note"})
+		return
+	}
 
-	if err := database.DB.Where("id = ? AND user_id = ?", id, userIDUUID).First(&note).Error; err != nil {
-		log.Printf("Note not found: %v", err)
-		c.JSON(http.StatusNotFound, gin.H{"error": "Note not found"})
+	s.broadcastNoteDelete(c.Request.Context(), note)
+
+	c.JSON(http.StatusOK, gin.H{"message": "Note moved to trash", "last_removed": note.LastRemove})
+}
+
+// RestoreNote takes a note back out of the trash.
+func (s *Server) RestoreNote(c *gin.Context) {
+	userID, ok := userIDFromContext(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User ID not found"})
 		return
 	}
 
-	if err := database.DB.Delete(&note).Error; err != nil {
-		log.Printf("Failed to delete note: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete note"})
+	note, err := s.Notes.FindTrashedNote(c.Request.Context(), c.Param("id"), userID, models.RoleEditor)
+	if err != nil {
+		respondWorkspaceError(c, err)
 		return
 	}
 
-	// Broadcast the deleted note ID to the user
-	websocket.BroadcastNoteDeleteToUser(note.ID, userIDUUID)
+	if err := s.Notes.RestoreNote(c.Request.Context(), &note, audit.ActorFromContext(c)); err != nil {
+		middleware.Logger(c).Error("Failed to restore note", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to restore note"})
+		return
+	}
 
-	// Broadcast the updated note list to the user
-	var notes []models.Note
-	database.DB.Where("user_id = ?", userIDUUID).Select("id, title").Find(&notes)
-	websocket.BroadcastNoteListToUser(notes, userIDUUID)
+	s.broadcastNoteUpdate(c.Request.Context(), note)
 
-	c.JSON(http.StatusOK, gin.H{"message": "Note deleted successfully"})
+	c.JSON(http.StatusOK, signNote(note))
 }
 
-func ListNotes(c *gin.Context) {
-	userID, _ := c.Get("user_id")
-	var notes []models.Note
+// ListTrashedNotes returns the caller's personal notes in the trash, most
+// recently deleted first.
+func (s *Server) ListTrashedNotes(c *gin.Context) {
+	userID, ok := userIDFromContext(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User ID not found"})
+		return
+	}
+	notes, err := s.Notes.ListTrashedNotes(c.Request.Context(), userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch notes"})
+		return
+	}
 
-	if err := database.DB.Where("user_id = ?", userID).Select("id, title, content, dashboard_path").Find(&notes).Error; err != nil {
+	for i := range notes {
+		notes[i] = signNote(notes[i])
+	}
+
+	c.JSON(http.StatusOK, notes)
+}
+
+func (s *Server) ListNotes(c *gin.Context) {
+	userID, ok := userIDFromContext(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User ID not found"})
+		return
+	}
+	notes, err := s.Notes.ListPersonalNotes(c.Request.Context(), userID)
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch notes"})
 		return
 	}
 
+	for i := range notes {
+		notes[i] = signNote(notes[i])
+	}
+
 	c.JSON(http.StatusOK, notes)
 }
+
+// dashboardUpload stores the optional "dashboard_image" file, or links the
+// existing blob named by "dashboard_sha256", after checking that it fits in
+// the user's quota together with extraBytes and extraNotes. replacedBytes is
+// freed when a new image replaces the current one. It writes the error
+// response itself and returns false when the request should stop.
+func (s *Server) dashboardUpload(c *gin.Context, userID uuid.UUID, extraBytes, extraNotes, replacedBytes int64) (*models.Upload, bool) {
+	if digest := c.Request.FormValue("dashboard_sha256"); digest != "" {
+		blob, err := s.Notes.FindBlob(c.Request.Context(), userID, digest)
+		if err != nil {
+			respondStorageError(c, err)
+			return nil, false
+		}
+		if !strings.HasPrefix(blob.ContentType, "image/") {
+			metrics.UploadRejected(metrics.RejectType)
+			c.JSON(http.StatusUnsupportedMediaType, gin.H{"error": "File is not an image", "content_type": blob.ContentType})
+			return nil, false
+		}
+		if !s.checkQuota(c, userID, blob.Size+extraBytes-replacedBytes, extraNotes) {
+			return nil, false
+		}
+
+		upload, err := s.Notes.LinkUpload(c.Request.Context(), userID, digest, c.Request.FormValue("dashboard_filename"), audit.ActorFromContext(c))
+		if err != nil {
+			respondStorageError(c, err)
+			return nil, false
+		}
+		return &upload, true
+	}
+
+	file, header, err := c.Request.FormFile("dashboard_image")
+	if err == http.ErrMissingFile {
+		return nil, s.checkQuota(c, userID, extraBytes, extraNotes)
+	}
+	if err != nil {
+		middleware.Logger(c).Error("Failed to handle file upload", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to handle file upload"})
+		return nil, false
+	}
+	defer file.Close()
+
+	contentType, ok := sniffUpload(c, file, header.Size, true)
+	if !ok {
+		return nil, false
+	}
+
+	if !s.checkQuota(c, userID, header.Size+extraBytes-replacedBytes, extraNotes) {
+		return nil, false
+	}
+
+	upload, err := s.Notes.SaveUpload(c.Request.Context(), userID, file, header.Filename, contentType, audit.ActorFromContext(c))
+	if err != nil {
+		middleware.Logger(c).Error("Failed to save the file", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save the file"})
+		return nil, false
+	}
+	metrics.UploadReceived(metrics.UploadMultipart, header.Size)
+	return &upload, true
+}
+
+// releaseDashboardUpload drops an image stored for a note that could not be
+// written.
+func (s *Server) releaseDashboardUpload(c *gin.Context, upload *models.Upload) {
+	if upload == nil {
+		return
+	}
+	if err := s.Notes.ReleaseUpload(c.Request.Context(), upload.Path); err != nil {
+		middleware.Logger(c).Error("Failed to release dashboard image", "error", err)
+	}
+}
+
+// broadcastNoteUpdate sends note to everyone who can see it, and refreshes
+// the owner's personal note list for personal notes.
+func (s *Server) broadcastNoteUpdate(ctx context.Context, note models.Note) {
+	for _, userID := range s.Notes.Audience(ctx, note) {
+		s.Events.NoteUpdated(ctx, note, userID)
+	}
+	if note.WorkspaceID == nil {
+		s.broadcastNoteList(ctx, note.UserID)
+	}
+}
+
+// broadcastNoteDelete tells everyone who could see note that it is gone.
+func (s *Server) broadcastNoteDelete(ctx context.Context, note models.Note) {
+	for _, userID := range s.Notes.Audience(ctx, note) {
+		s.Events.NoteDeleted(ctx, note.ID, userID)
+	}
+	if note.WorkspaceID == nil {
+		s.broadcastNoteList(ctx, note.UserID)
+	}
+}
+
+// broadcastNoteList sends userID their current personal note list.
+func (s *Server) broadcastNoteList(ctx context.Context, userID uuid.UUID) {
+	notes, err := s.Notes.ListPersonalNotes(ctx, userID)
+	if err != nil {
+		slog.Error("Failed to list notes for broadcast", "user_id", userID, "error", err)
+		return
+	}
+	s.Events.NoteListChanged(ctx, notes, userID)
+}
+
+// respondWorkspaceError maps workspace access errors to responses.
+func respondWorkspaceError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, workspace.ErrNoteNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Note not found"})
+	case errors.Is(err, workspace.ErrNotMember), errors.Is(err, repository.ErrWorkspaceNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Workspace not found"})
+	case errors.Is(err, workspace.ErrForbidden):
+		c.JSON(http.StatusForbidden, gin.H{"error": "Your workspace role does not allow this"})
+	case errors.Is(err, workspace.ErrInvalidRole):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid role"})
+	case errors.Is(err, workspace.ErrLastOwner):
+		c.JSON(http.StatusConflict, gin.H{"error": "A workspace needs at least one owner"})
+	case errors.Is(err, workspace.ErrInvitationInvalid):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Invitation is invalid, expired or already used"})
+	case errors.Is(err, workspace.ErrSearchDisabled):
+		c.JSON(http.StatusNotImplemented, gin.H{"error": "Search is disabled while note encryption is enabled"})
+	default:
+		middleware.Logger(c).Error("Workspace operation failed", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+	}
+}
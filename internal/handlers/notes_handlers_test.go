@@ -0,0 +1,299 @@
+package handlers
+
+import (
+	"NoteApi/internal/audit"
+	"NoteApi/internal/models"
+	"NoteApi/internal/quota"
+	"NoteApi/internal/repository"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"github.com/google/uuid"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestCreateNote(t *testing.T) {
+	ts := newTestServer(t)
+	userID := uuid.New()
+
+	body, contentType := multipartForm(t, map[string]string{"title": "Groceries", "content": "milk"})
+	var note models.Note
+	decode(t, ts.do(userID, http.MethodPost, "/notes", body, contentType), http.StatusCreated, &note)
+
+	if note.Title != "Groceries" || note.Content != "milk" || note.UserID != userID {
+		t.Fatalf("created %+v", note)
+	}
+	stored, err := ts.repo.FindNote(context.Background(), note.ID.String(), userID, models.RoleOwner)
+	if err != nil || stored.Title != "Groceries" {
+		t.Fatalf("stored note %+v, %v", stored, err)
+	}
+
+	events := ts.repo.AuditEvents()
+	if len(events) != 1 || events[0].Action != audit.ActionNoteCreate || events[0].ActorID != userID {
+		t.Fatalf("audit events = %+v", events)
+	}
+	if strings.Contains(events[0].Changes, "Groceries") {
+		t.Fatalf("audit event leaks the title: %s", events[0].Changes)
+	}
+
+	ts.events.mu.Lock()
+	defer ts.events.mu.Unlock()
+	if len(ts.events.updated) != 1 || ts.events.updated[0] != note.ID {
+		t.Fatalf("published updates = %v", ts.events.updated)
+	}
+	if list := ts.events.lists[userID]; len(list) != 1 || list[0].ID != note.ID {
+		t.Fatalf("published list = %+v", list)
+	}
+}
+
+func TestCreateNoteWithDashboardImage(t *testing.T) {
+	ts := newTestServer(t)
+	userID := uuid.New()
+
+	body, contentType := multipartForm(t, map[string]string{"title": "Photo"},
+		formFile{field: "dashboard_image", name: "pixel.png", content: pngImage})
+	var note models.Note
+	decode(t, ts.do(userID, http.MethodPost, "/notes", body, contentType), http.StatusCreated, &note)
+
+	path, query, _ := strings.Cut(note.DashboardPath, "?")
+	if !strings.HasPrefix(path, UploadPath+"/") || !strings.Contains(query, "signature=") {
+		t.Fatalf("dashboard_path = %q, want a signed upload path", note.DashboardPath)
+	}
+	download := ts.do(userID, http.MethodGet, "/"+path, nil, "")
+	if download.Code != http.StatusOK || download.Body.String() != string(pngImage) {
+		t.Fatalf("download = %d %q", download.Code, download.Body.String())
+	}
+}
+
+func TestCreateNoteRejectsBadDashboardImage(t *testing.T) {
+	ts := newTestServer(t)
+	userID := uuid.New()
+
+	body, contentType := multipartForm(t, map[string]string{"title": "Not a photo"},
+		formFile{field: "dashboard_image", name: "notes.txt", content: []byte("plain text")})
+	decode(t, ts.do(userID, http.MethodPost, "/notes", body, contentType), http.StatusUnsupportedMediaType, nil)
+
+	if notes, _ := ts.repo.ListPersonalNotes(context.Background(), userID); len(notes) != 0 {
+		t.Fatalf("note created despite the rejected image: %+v", notes)
+	}
+}
+
+// failingNotes fails every note write.
+type failingNotes struct {
+	*repository.Memory
+}
+
+func (failingNotes) CreateNote(ctx context.Context, note *models.Note, actor audit.Actor) error {
+	return errors.New("database is down")
+}
+
+func (failingNotes) UpdateNote(ctx context.Context, before models.Note, note *models.Note, actor audit.Actor) error {
+	return errors.New("database is down")
+}
+
+func TestCreateNoteFailureReleasesDashboardImage(t *testing.T) {
+	ts := newTestServer(t)
+	ts.Notes = failingNotes{ts.repo}
+
+	body, contentType := multipartForm(t, map[string]string{"title": "Photo"},
+		formFile{field: "dashboard_image", name: "pixel.png", content: pngImage})
+	decode(t, ts.do(uuid.New(), http.MethodPost, "/notes", body, contentType), http.StatusInternalServerError, nil)
+
+	sum := sha256.Sum256(pngImage)
+	if _, ok := ts.repo.Content(hex.EncodeToString(sum[:])); ok {
+		t.Fatal("dashboard image kept after the note failed to save")
+	}
+}
+
+func TestCreateNoteRequestTooLarge(t *testing.T) {
+	ts := newTestServer(t)
+	ts.Config.Uploads.MaxRequestSize = 1024
+
+	body, contentType := multipartForm(t, map[string]string{"title": "Long", "content": strings.Repeat("x", 4096)})
+	var response struct {
+		MaxBytes int64 `json:"max_bytes"`
+	}
+	decode(t, ts.do(uuid.New(), http.MethodPost, "/notes", body, contentType), http.StatusRequestEntityTooLarge, &response)
+	if response.MaxBytes != 1024 {
+		t.Fatalf("max_bytes = %d", response.MaxBytes)
+	}
+}
+
+func TestCreateNoteInWorkspaceRequiresEditor(t *testing.T) {
+	ts := newTestServer(t)
+	workspaceID, viewer, editor := uuid.New(), uuid.New(), uuid.New()
+	ts.repo.SetMember(workspaceID, viewer, models.RoleViewer)
+	ts.repo.SetMember(workspaceID, editor, models.RoleEditor)
+	fields := map[string]string{"title": "Plan", "workspace_id": workspaceID.String()}
+
+	body, contentType := multipartForm(t, fields)
+	decode(t, ts.do(viewer, http.MethodPost, "/notes", body, contentType), http.StatusForbidden, nil)
+
+	body, contentType = multipartForm(t, fields)
+	decode(t, ts.do(uuid.New(), http.MethodPost, "/notes", body, contentType), http.StatusNotFound, nil)
+
+	body, contentType = multipartForm(t, fields)
+	var note models.Note
+	decode(t, ts.do(editor, http.MethodPost, "/notes", body, contentType), http.StatusCreated, &note)
+	if note.WorkspaceID == nil || *note.WorkspaceID != workspaceID {
+		t.Fatalf("workspace_id = %v", note.WorkspaceID)
+	}
+}
+
+func TestGetNote(t *testing.T) {
+	ts := newTestServer(t)
+	owner := uuid.New()
+	note := ts.createNote(t, owner, "Secret", "only mine")
+
+	var got models.Note
+	decode(t, ts.do(owner, http.MethodGet, "/notes/"+note.ID.String(), nil, ""), http.StatusOK, &got)
+	if got.ID != note.ID || got.Content != "only mine" {
+		t.Fatalf("got %+v", got)
+	}
+
+	decode(t, ts.do(uuid.New(), http.MethodGet, "/notes/"+note.ID.String(), nil, ""), http.StatusNotFound, nil)
+	decode(t, ts.do(owner, http.MethodGet, "/notes/not-a-uuid", nil, ""), http.StatusNotFound, nil)
+}
+
+func TestUpdateNote(t *testing.T) {
+	ts := newTestServer(t)
+	owner := uuid.New()
+	note := ts.createNote(t, owner, "Draft", "first")
+
+	body, contentType := multipartForm(t, map[string]string{"title": "Final", "content": "second"})
+	var got models.Note
+	decode(t, ts.do(owner, http.MethodPut, "/notes/"+note.ID.String(), body, contentType), http.StatusOK, &got)
+	if got.Title != "Final" || got.Content != "second" {
+		t.Fatalf("updated %+v", got)
+	}
+
+	events := ts.repo.AuditEvents()
+	last := events[len(events)-1]
+	if last.Action != audit.ActionNoteUpdate || last.TargetID != note.ID.String() {
+		t.Fatalf("last audit event = %+v", last)
+	}
+
+	body, contentType = multipartForm(t, map[string]string{"title": "Hijacked"})
+	decode(t, ts.do(uuid.New(), http.MethodPut, "/notes/"+note.ID.String(), body, contentType), http.StatusNotFound, nil)
+}
+
+func TestUpdateNoteReplacesDashboardImage(t *testing.T) {
+	ts := newTestServer(t)
+	owner := uuid.New()
+
+	body, contentType := multipartForm(t, map[string]string{"title": "Photo"},
+		formFile{field: "dashboard_image", name: "first.png", content: pngImage})
+	var note models.Note
+	decode(t, ts.do(owner, http.MethodPost, "/notes", body, contentType), http.StatusCreated, &note)
+	firstPath, _, _ := strings.Cut(note.DashboardPath, "?")
+
+	replacement := append(append([]byte(nil), pngImage...), 0)
+	body, contentType = multipartForm(t, map[string]string{"title": "Photo"},
+		formFile{field: "dashboard_image", name: "second.png", content: replacement})
+	decode(t, ts.do(owner, http.MethodPut, "/notes/"+note.ID.String(), body, contentType), http.StatusOK, &note)
+
+	if path, _, _ := strings.Cut(note.DashboardPath, "?"); path == firstPath {
+		t.Fatalf("dashboard path unchanged: %q", path)
+	}
+	decode(t, ts.do(owner, http.MethodGet, "/"+firstPath, nil, ""), http.StatusNotFound, nil)
+}
+
+func TestUpdateNoteFailureReleasesDashboardImage(t *testing.T) {
+	ts := newTestServer(t)
+	owner := uuid.New()
+	note := ts.createNote(t, owner, "Photo", "")
+	ts.Notes = failingNotes{ts.repo}
+
+	body, contentType := multipartForm(t, map[string]string{"title": "Photo"},
+		formFile{field: "dashboard_image", name: "pixel.png", content: pngImage})
+	decode(t, ts.do(owner, http.MethodPut, "/notes/"+note.ID.String(), body, contentType), http.StatusInternalServerError, nil)
+
+	sum := sha256.Sum256(pngImage)
+	if _, ok := ts.repo.Content(hex.EncodeToString(sum[:])); ok {
+		t.Fatal("dashboard image kept after the note failed to save")
+	}
+}
+
+func TestUpdateNoteReplacingDashboardImageAtQuota(t *testing.T) {
+	ts := newTestServer(t)
+	owner := uuid.New()
+	replacement := append(append([]byte(nil), pngImage...), 0)
+	maxBytes, maxNotes := quota.MaxBytes(), quota.MaxNotes()
+	quota.SetLimits(int64(len("Photo")+len(replacement)), maxNotes)
+	t.Cleanup(func() { quota.SetLimits(maxBytes, maxNotes) })
+
+	body, contentType := multipartForm(t, map[string]string{"title": "Photo"},
+		formFile{field: "dashboard_image", name: "first.png", content: pngImage})
+	var note models.Note
+	decode(t, ts.do(owner, http.MethodPost, "/notes", body, contentType), http.StatusCreated, &note)
+
+	// The replaced image is released, so only the difference is charged
+	body, contentType = multipartForm(t, map[string]string{"title": "Photo"},
+		formFile{field: "dashboard_image", name: "second.png", content: replacement})
+	decode(t, ts.do(owner, http.MethodPut, "/notes/"+note.ID.String(), body, contentType), http.StatusOK, nil)
+
+	body, contentType = multipartForm(t, map[string]string{"title": "Photo"},
+		formFile{field: "dashboard_image", name: "third.png", content: append(replacement, 0)})
+	decode(t, ts.do(owner, http.MethodPut, "/notes/"+note.ID.String(), body, contentType), http.StatusRequestEntityTooLarge, nil)
+}
+
+func TestUpdateNoteAsWorkspaceViewer(t *testing.T) {
+	ts := newTestServer(t)
+	workspaceID, owner, viewer := uuid.New(), uuid.New(), uuid.New()
+	ts.repo.SetMember(workspaceID, owner, models.RoleOwner)
+	ts.repo.SetMember(workspaceID, viewer, models.RoleViewer)
+	note := ts.createNote(t, owner, "Shared", "")
+	if err := ts.repo.ShareNote(context.Background(), &note, workspaceID, audit.Actor{UserID: owner}); err != nil {
+		t.Fatal(err)
+	}
+
+	decode(t, ts.do(viewer, http.MethodGet, "/notes/"+note.ID.String(), nil, ""), http.StatusOK, nil)
+
+	body, contentType := multipartForm(t, map[string]string{"title": "Edited"})
+	decode(t, ts.do(viewer, http.MethodPut, "/notes/"+note.ID.String(), body, contentType), http.StatusForbidden, nil)
+}
+
+func TestDeleteNote(t *testing.T) {
+	ts := newTestServer(t)
+	owner := uuid.New()
+	note := ts.createNote(t, owner, "Old", "")
+
+	decode(t, ts.do(uuid.New(), http.MethodDelete, "/notes/"+note.ID.String(), nil, ""), http.StatusNotFound, nil)
+	decode(t, ts.do(owner, http.MethodDelete, "/notes/"+note.ID.String(), nil, ""), http.StatusOK, nil)
+
+	// The note is in the trash, not gone
+	decode(t, ts.do(owner, http.MethodGet, "/notes/"+note.ID.String(), nil, ""), http.StatusNotFound, nil)
+	trashed, err := ts.repo.ListTrashedNotes(context.Background(), owner)
+	if err != nil || len(trashed) != 1 || trashed[0].ID != note.ID {
+		t.Fatalf("trash = %+v, %v", trashed, err)
+	}
+
+	ts.events.mu.Lock()
+	defer ts.events.mu.Unlock()
+	if len(ts.events.deleted) != 1 || ts.events.deleted[0] != note.ID {
+		t.Fatalf("published deletes = %v", ts.events.deleted)
+	}
+	if list := ts.events.lists[owner]; len(list) != 0 {
+		t.Fatalf("published list still has %d notes", len(list))
+	}
+}
+
+func TestListNotes(t *testing.T) {
+	ts := newTestServer(t)
+	owner, other := uuid.New(), uuid.New()
+	kept := ts.createNote(t, owner, "Kept", "")
+	removed := ts.createNote(t, owner, "Removed", "")
+	if err := ts.repo.DeleteNote(context.Background(), &removed, audit.Actor{UserID: owner}); err != nil {
+		t.Fatal(err)
+	}
+	ts.createNote(t, other, "Someone else's", "")
+
+	var notes []models.Note
+	decode(t, ts.do(owner, http.MethodGet, "/notes", nil, ""), http.StatusOK, &notes)
+	if len(notes) != 1 || notes[0].ID != kept.ID {
+		t.Fatalf("listed %+v", notes)
+	}
+}
@@ -0,0 +1,104 @@
+package handlers
+
+import (
+	"NoteApi/internal/auth"
+	"NoteApi/internal/middleware"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"time"
+)
+
+type revokeTokenRequest struct {
+	JTI string `json:"jti"`
+}
+
+type revokeAllRequest struct {
+	Before *time.Time `json:"before"`
+}
+
+// RevokeToken logs out a single token: the caller's own by default, or another
+// of the caller's JWTs named by "jti". Open websocket connections that
+// authenticated with it are closed.
+func (s *Server) RevokeToken(c *gin.Context) {
+	principal, ok := auth.FromContext(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User ID not found"})
+		return
+	}
+
+	var req revokeTokenRequest
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+			return
+		}
+	}
+
+	// Revoking the API token in use goes through its own record
+	if req.JTI == "" && principal.ViaAPIToken() {
+		if err := s.Accounts.RevokeAPIToken(c.Request.Context(), principal.APITokenID, time.Now()); err != nil {
+			middleware.Logger(c).Error("Failed to revoke API token", "error", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke token"})
+			return
+		}
+		s.Events.TokenRevoked(principal.UserID, principal.TokenID)
+		c.JSON(http.StatusOK, gin.H{"message": "Token revoked"})
+		return
+	}
+
+	jti, expiresAt := req.JTI, time.Time{}
+	if jti == "" {
+		if principal.TokenID == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Token has no jti; use /auth/revoke-all instead"})
+			return
+		}
+		jti, expiresAt = principal.TokenID, principal.ExpiresAt
+	}
+
+	if err := s.Accounts.RevokeToken(c.Request.Context(), principal.UserID, jti, expiresAt); err != nil {
+		middleware.Logger(c).Error("Failed to revoke token", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke token"})
+		return
+	}
+	s.Events.TokenRevoked(principal.UserID, jti)
+
+	c.JSON(http.StatusOK, gin.H{"message": "Token revoked", "jti": jti})
+}
+
+// RevokeAllTokens revokes every token of the caller issued before "before"
+// (default now), including personal API tokens and the token used for this
+// request, and closes the matching websocket connections.
+func (s *Server) RevokeAllTokens(c *gin.Context) {
+	userID, ok := userIDFromContext(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User ID not found"})
+		return
+	}
+
+	var req revokeAllRequest
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+			return
+		}
+	}
+
+	now := time.Now()
+	cutoff := now
+	if req.Before != nil {
+		if req.Before.After(now) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "before must not be in the future"})
+			return
+		}
+		cutoff = *req.Before
+	}
+
+	if err := s.Accounts.RevokeTokensBefore(c.Request.Context(), userID, cutoff); err != nil {
+		middleware.Logger(c).Error("Failed to revoke tokens", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke tokens"})
+		return
+	}
+	s.Events.TokensRevokedBefore(userID, cutoff)
+
+	c.JSON(http.StatusOK, gin.H{"message": "Tokens revoked", "revoked_before": cutoff})
+}
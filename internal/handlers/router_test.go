@@ -0,0 +1,210 @@
+package handlers
+
+import (
+	"NoteApi/internal/auth"
+	"NoteApi/internal/config"
+	"NoteApi/internal/database"
+	"NoteApi/internal/models"
+	"NoteApi/internal/repository"
+	"NoteApi/internal/storage"
+	"context"
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"gorm.io/gorm/logger"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const routerTestSecret = "router-test-secret"
+
+// newRouterServer builds the production router on a migrated SQLite
+// database, with the real authentication, scope and rate limit middleware.
+// JWTs are verified with routerTestSecret.
+func newRouterServer(t *testing.T, cfg config.Config) (*gin.Engine, *repository.Postgres) {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+
+	previousDB := database.DB
+	database.ConnectToDb(config.DatabaseConfig{Driver: config.DriverSQLite, DSN: filepath.Join(t.TempDir(), "notes.db"), MaxIdleConns: 1, MaxOpenConns: 1})
+	database.DB.Logger = logger.Discard
+	db := database.DB
+	t.Cleanup(func() {
+		database.DB = previousDB
+		if sqlDB, err := db.DB(); err == nil {
+			sqlDB.Close()
+		}
+	})
+	if _, err := database.MigrateUp(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	previousRoot := storage.Root
+	storage.SetRoot(t.TempDir())
+	t.Cleanup(func() { storage.SetRoot(previousRoot) })
+
+	verifier, err := auth.NewVerifier(auth.VerifierConfig{HMACSecret: routerTestSecret})
+	if err != nil {
+		t.Fatal(err)
+	}
+	previousVerifier, _ := auth.Default()
+	auth.SetDefault(verifier)
+	t.Cleanup(func() {
+		if previousVerifier != nil {
+			auth.SetDefault(previousVerifier)
+		}
+	})
+
+	repo := repository.NewPostgres(db)
+	server := &Server{
+		Config:     cfg,
+		Notes:      repo,
+		Workspaces: repo,
+		Accounts:   repo,
+		TusUploads: repo,
+		Database:   repo,
+		Events:     &fakeEvents{},
+	}
+	r, err := server.Router()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r, repo
+}
+
+// signToken returns a JWT for userID with scopes, issued now.
+func signToken(t *testing.T, userID uuid.UUID, scopes ...string) string {
+	t.Helper()
+	now := time.Now()
+	claims := auth.Claims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   userID.String(),
+			ID:        uuid.NewString(),
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(time.Hour)),
+		},
+		Scope: strings.Join(scopes, " "),
+	}
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(routerTestSecret))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+// send serves a request with an optional bearer token.
+func send(r *gin.Engine, token, method, target string, body io.Reader, contentType string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, target, body)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func TestRouterAuthentication(t *testing.T) {
+	r, repo := newRouterServer(t, config.Defaults())
+	userID := uuid.New()
+	readWrite := signToken(t, userID, auth.ScopeNotesRead, auth.ScopeNotesWrite)
+
+	var response map[string]interface{}
+	decode(t, send(r, "", http.MethodGet, "/notes", nil, ""), http.StatusUnauthorized, nil)
+	decode(t, send(r, "not-a-jwt", http.MethodGet, "/notes", nil, ""), http.StatusUnauthorized, nil)
+	decode(t, send(r, signToken(t, uuid.New(), auth.ScopeNotesRead)+"x", http.MethodGet, "/notes", nil, ""), http.StatusUnauthorized, nil)
+
+	// The scope check runs before the handler
+	body, contentType := multipartForm(t, map[string]string{"title": "Groceries", "content": "milk"})
+	decode(t, send(r, signToken(t, userID, auth.ScopeNotesRead), http.MethodPost, "/notes", body, contentType), http.StatusForbidden, &response)
+	if response["scope"] != auth.ScopeNotesWrite {
+		t.Fatalf("403 body = %v", response)
+	}
+
+	body, contentType = multipartForm(t, map[string]string{"title": "Groceries", "content": "milk"})
+	var note models.Note
+	decode(t, send(r, readWrite, http.MethodPost, "/notes", body, contentType), http.StatusCreated, &note)
+	var fetched models.Note
+	decode(t, send(r, readWrite, http.MethodGet, "/notes/"+note.ID.String(), nil, ""), http.StatusOK, &fetched)
+	if fetched.Title != "Groceries" || fetched.UserID != userID {
+		t.Fatalf("fetched %+v", fetched)
+	}
+
+	// Another user's token does not reach the note
+	decode(t, send(r, signToken(t, uuid.New(), auth.ScopeNotesRead), http.MethodGet, "/notes/"+note.ID.String(), nil, ""), http.StatusNotFound, nil)
+
+	// Revoking every token issued so far rejects the one in use
+	if err := repo.RevokeTokensBefore(context.Background(), userID, time.Now().Add(time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	decode(t, send(r, readWrite, http.MethodGet, "/notes", nil, ""), http.StatusUnauthorized, &response)
+	if response["error"] != "Token revoked" {
+		t.Fatalf("401 body = %v", response)
+	}
+}
+
+func TestRouterAPIToken(t *testing.T) {
+	r, repo := newRouterServer(t, config.Defaults())
+	userID := uuid.New()
+	plaintext, _, err := repo.CreateAPIToken(context.Background(), userID, "ci", []string{auth.ScopeNotesRead}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	decode(t, send(r, plaintext, http.MethodGet, "/notes", nil, ""), http.StatusOK, nil)
+	body, contentType := multipartForm(t, map[string]string{"title": "Groceries"})
+	decode(t, send(r, plaintext, http.MethodPost, "/notes", body, contentType), http.StatusForbidden, nil)
+	decode(t, send(r, auth.APITokenPrefix+"unknown", http.MethodGet, "/notes", nil, ""), http.StatusUnauthorized, nil)
+}
+
+func TestRouterRateLimit(t *testing.T) {
+	cfg := config.Defaults()
+	cfg.RateLimit.Notes = "2/1m"
+	r, _ := newRouterServer(t, cfg)
+	token := signToken(t, uuid.New(), auth.ScopeNotesRead)
+
+	for i := 0; i < 2; i++ {
+		decode(t, send(r, token, http.MethodGet, "/notes", nil, ""), http.StatusOK, nil)
+	}
+	w := send(r, token, http.MethodGet, "/notes", nil, "")
+	decode(t, w, http.StatusTooManyRequests, nil)
+	if w.Header().Get("Retry-After") == "" || w.Header().Get("RateLimit-Limit") != "2" {
+		t.Fatalf("headers = %v", w.Header())
+	}
+
+	// Limits are per user; unauthenticated requests are turned away before
+	// they reach the user's bucket
+	decode(t, send(r, signToken(t, uuid.New(), auth.ScopeNotesRead), http.MethodGet, "/notes", nil, ""), http.StatusOK, nil)
+	decode(t, send(r, "", http.MethodGet, "/notes", nil, ""), http.StatusUnauthorized, nil)
+}
+
+func TestRouterReadyz(t *testing.T) {
+	r, _ := newRouterServer(t, config.Defaults())
+
+	var response struct {
+		Status string                 `json:"status"`
+		Checks map[string]checkResult `json:"checks"`
+	}
+	decode(t, send(r, "", http.MethodGet, "/readyz", nil, ""), http.StatusOK, &response)
+	for _, name := range []string{"database", "migrations", "blob_store", "websocket_hub"} {
+		if response.Checks[name].Status != "ok" {
+			t.Errorf("%s check = %+v", name, response.Checks[name])
+		}
+	}
+
+	// A database behind this build fails readiness
+	if _, err := database.MigrateDown(context.Background(), 1); err != nil {
+		t.Fatal(err)
+	}
+	decode(t, send(r, "", http.MethodGet, "/readyz", nil, ""), http.StatusServiceUnavailable, &response)
+	if response.Checks["migrations"].Status != "failed" {
+		t.Fatalf("migrations check = %+v", response.Checks["migrations"])
+	}
+}
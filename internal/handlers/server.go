@@ -0,0 +1,184 @@
+package handlers
+
+import (
+	"NoteApi/internal/auth"
+	"NoteApi/internal/config"
+	"NoteApi/internal/metrics"
+	"NoteApi/internal/middleware"
+	"NoteApi/internal/models"
+	"NoteApi/internal/quota"
+	"NoteApi/internal/ratelimit"
+	"NoteApi/internal/repository"
+	"NoteApi/internal/tracing"
+	"context"
+	"fmt"
+	"github.com/gin-contrib/cors"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"go.opentelemetry.io/contrib/instrumentation/github.com/gin-gonic/gin/otelgin"
+	"time"
+)
+
+// EventPublisher pushes changes to users' live connections. The websocket
+// hub implements it in production. Events take the context of the request
+// that caused them so delivery shows up in its trace.
+type EventPublisher interface {
+	NoteUpdated(ctx context.Context, note models.Note, userID uuid.UUID)
+	NoteDeleted(ctx context.Context, noteID, userID uuid.UUID)
+	// NoteListChanged sends userID their full personal note list.
+	NoteListChanged(ctx context.Context, notes []models.Note, userID uuid.UUID)
+	QuotaWarning(ctx context.Context, usage quota.Usage, userID uuid.UUID)
+	// TokenRevoked closes connections authenticated with the token.
+	TokenRevoked(userID uuid.UUID, tokenID string)
+	// TokensRevokedBefore closes connections whose token was issued before
+	// cutoff.
+	TokensRevokedBefore(userID uuid.UUID, cutoff time.Time)
+	// Health reports how many connections are open, failing if the
+	// publisher cannot deliver events.
+	Health(ctx context.Context) (int, error)
+}
+
+// Server holds what the handlers depend on and builds the router.
+type Server struct {
+	Config     config.Config
+	Notes      repository.NoteRepository
+	Workspaces repository.WorkspaceRepository
+	// Accounts backs the token, ticket and audit log routes.
+	Accounts   repository.AccountRepository
+	TusUploads repository.TusRepository
+	// Database backs the database and migration readiness checks.
+	Database repository.HealthRepository
+	Events   EventPublisher
+	// Limiter backs the per-IP and per-user rate limits; nil keeps them in
+	// memory.
+	Limiter ratelimit.Store
+	// WebSocket serves /ws after authentication.
+	WebSocket gin.HandlerFunc
+}
+
+// Router builds the gin engine with every route and middleware.
+func (s *Server) Router() (*gin.Engine, error) {
+	r := gin.New()
+	// The tracing middleware runs first so the request span covers the rest
+	// of the chain and its trace ID is available to the access log.
+	r.Use(otelgin.Middleware(tracing.ServiceName))
+	r.Use(middleware.RequestID(), middleware.AccessLog(), middleware.Metrics(), middleware.Recovery())
+
+	if err := r.SetTrustedProxies(s.Config.Server.TrustedProxies); err != nil {
+		return nil, err
+	}
+
+	// Without configured origins no cross-origin requests are allowed
+	if len(s.Config.Server.CORSOrigins) > 0 {
+		r.Use(cors.New(cors.Config{
+			AllowOrigins:     s.Config.Server.CORSOrigins,
+			AllowMethods:     []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
+			AllowHeaders:     []string{"Origin", "Content-Type", "Accept", "Authorization", "X-Request-ID"},
+			ExposeHeaders:    []string{"Content-Length", "Content-Type", "X-Request-ID"},
+			AllowCredentials: false,
+			MaxAge:           12 * time.Hour,
+		}))
+	}
+
+	// Rate limiting: every client is limited per IP, and authenticated route
+	// groups additionally per user
+	limiter := s.Limiter
+	if limiter == nil {
+		limiter = ratelimit.NewMemoryStore()
+	}
+	limits := map[string]gin.HandlerFunc{}
+	for group, value := range s.Config.RateLimit.Groups() {
+		limit, err := ratelimit.ParseLimit(value)
+		if err != nil {
+			return nil, fmt.Errorf("failed to configure %s rate limit: %w", group, err)
+		}
+		limits[group] = middleware.RateLimit(limiter, group, limit)
+	}
+	notesLimit := limits["notes"]
+	uploadsLimit := limits["uploads"]
+	tusLimit := limits["tus"]
+	wsLimit := limits["ws"]
+
+	// Health check routes. /livez only says the process is up; /readyz checks
+	// the dependencies needed to serve traffic. /health is kept for existing
+	// probes and now means ready. They are registered before the IP rate limit
+	// so frequent probes are never throttled.
+	r.GET("/livez", s.Livez)
+	r.GET("/readyz", s.Readyz)
+	r.GET("/health", s.Readyz)
+
+	r.Use(limits["ip"])
+
+	// Uploaded files are served through an authenticated handler; signed
+	// URLs let <img> tags load them without an Authorization header.
+	r.GET("/uploads/:filename", middleware.CheckSignedOrAuthenticated(), middleware.RequireScope(auth.ScopeNotesRead), notesLimit, s.DownloadUpload)
+
+	// Metrics with a token but no listener of their own are served here
+	if s.Config.Metrics.Addr == "" && s.Config.Metrics.Token != "" {
+		r.GET("/metrics", gin.WrapH(metrics.Handler(s.Config.Metrics.Token)))
+	}
+
+	// Note routes
+	r.POST("/notes", middleware.CheckAuthenticated(), middleware.RequireScope(auth.ScopeNotesWrite), notesLimit, s.CreateNote)
+	r.GET("/notes/:id", middleware.CheckAuthenticated(), middleware.RequireScope(auth.ScopeNotesRead), notesLimit, s.GetNote)
+	r.PUT("/notes/:id", middleware.CheckAuthenticated(), middleware.RequireScope(auth.ScopeNotesWrite), notesLimit, s.UpdateNote)
+	r.DELETE("/notes/:id", middleware.CheckAuthenticated(), middleware.RequireScope(auth.ScopeNotesDelete), notesLimit, s.DeleteNote)
+	r.GET("/notes", middleware.CheckAuthenticated(), middleware.RequireScope(auth.ScopeNotesRead), notesLimit, s.ListNotes)
+	r.GET("/notes/trash", middleware.CheckAuthenticated(), middleware.RequireScope(auth.ScopeNotesRead), notesLimit, s.ListTrashedNotes)
+	r.POST("/notes/:id/restore", middleware.CheckAuthenticated(), middleware.RequireScope(auth.ScopeNotesDelete), notesLimit, s.RestoreNote)
+	r.POST("/notes/:id/share", middleware.CheckAuthenticated(), middleware.RequireScope(auth.ScopeNotesWrite), notesLimit, s.ShareNote)
+
+	// Workspace routes; role checks happen in the handlers
+	workspaceRead := []gin.HandlerFunc{middleware.CheckAuthenticated(), middleware.RequireScope(auth.ScopeNotesRead), notesLimit}
+	workspaceManage := []gin.HandlerFunc{middleware.CheckAuthenticated(), middleware.RequireScope(auth.ScopeWorkspacesManage), notesLimit}
+	r.POST("/workspaces", append(workspaceManage, s.CreateWorkspace)...)
+	r.GET("/workspaces", append(workspaceRead, s.ListWorkspaces)...)
+	r.GET("/workspaces/:id", append(workspaceRead, s.GetWorkspace)...)
+	r.PATCH("/workspaces/:id", append(workspaceManage, s.UpdateWorkspace)...)
+	r.DELETE("/workspaces/:id", append(workspaceManage, s.DeleteWorkspace)...)
+	r.GET("/workspaces/:id/members", append(workspaceRead, s.ListWorkspaceMembers)...)
+	r.PUT("/workspaces/:id/members/:user_id", append(workspaceManage, s.UpdateWorkspaceMember)...)
+	r.DELETE("/workspaces/:id/members/:user_id", append(workspaceManage, s.RemoveWorkspaceMember)...)
+	r.POST("/workspaces/:id/invitations", append(workspaceManage, s.CreateWorkspaceInvitation)...)
+	r.GET("/workspaces/:id/invitations", append(workspaceManage, s.ListWorkspaceInvitations)...)
+	r.DELETE("/workspaces/:id/invitations/:invitation_id", append(workspaceManage, s.RevokeWorkspaceInvitation)...)
+	r.POST("/invitations/accept", append(workspaceManage, s.AcceptWorkspaceInvitation)...)
+	r.GET("/workspaces/:id/notes", append(workspaceRead, s.ListWorkspaceNotes)...)
+	r.GET("/workspaces/:id/notes/search", append(workspaceRead, s.SearchWorkspaceNotes)...)
+	r.GET("/workspaces/:id/notes/trash", append(workspaceRead, s.ListWorkspaceTrash)...)
+
+	// File upload route
+	r.POST("/upload", middleware.CheckAuthenticated(), middleware.RequireScope(auth.ScopeUploadsWrite), uploadsLimit, s.UploadFile)
+	r.HEAD("/blobs/:sha256", middleware.CheckAuthenticated(), middleware.RequireScope(auth.ScopeUploadsWrite), uploadsLimit, s.HeadBlob)
+
+	// Storage usage route
+	r.GET("/me/usage", middleware.CheckAuthenticated(), middleware.RequireScope(auth.ScopeNotesRead), s.GetUsage)
+	r.GET("/me/audit", middleware.CheckAuthenticated(), middleware.RequireScope(auth.ScopeNotesRead), notesLimit, s.ListAuditEvents)
+
+	// Personal API token routes
+	r.POST("/me/tokens", middleware.CheckAuthenticated(), middleware.RequireScope(auth.ScopeTokensManage), notesLimit, s.CreateAPIToken)
+	r.GET("/me/tokens", middleware.CheckAuthenticated(), middleware.RequireScope(auth.ScopeTokensManage), notesLimit, s.ListAPITokens)
+	r.DELETE("/me/tokens/:id", middleware.CheckAuthenticated(), middleware.RequireScope(auth.ScopeTokensManage), notesLimit, s.RevokeAPIToken)
+
+	// Token revocation (logout and "sign out everywhere")
+	r.POST("/auth/revoke", middleware.CheckAuthenticated(), middleware.RequireScope(auth.ScopeTokensManage), notesLimit, s.RevokeToken)
+	r.POST("/auth/revoke-all", middleware.CheckAuthenticated(), middleware.RequireScope(auth.ScopeTokensManage), notesLimit, s.RevokeAllTokens)
+
+	// Resumable (tus 1.0) upload routes
+	tus := r.Group("/uploads/tus", CheckTusResumable())
+	tus.OPTIONS("", s.TusOptions)
+	tusAuth := []gin.HandlerFunc{middleware.CheckAuthenticated(), middleware.RequireScope(auth.ScopeUploadsWrite), tusLimit}
+	tus.POST("", append(tusAuth, s.TusCreate)...)
+	tus.HEAD("/:id", append(tusAuth, s.TusHead)...)
+	tus.PATCH("/:id", append(tusAuth, s.TusPatch)...)
+	tus.DELETE("/:id", append(tusAuth, s.TusDelete)...)
+
+	// WebSocket route; browsers fetch a ticket first or authenticate in the
+	// first frame
+	r.POST("/ws/ticket", middleware.CheckAuthenticated(), middleware.RequireScope(auth.ScopeWSSubscribe), wsLimit, s.IssueWebSocketTicket)
+	if s.WebSocket != nil {
+		r.GET("/ws", middleware.CheckAuthenticatedWebSocket(), middleware.RequireScope(auth.ScopeWSSubscribe), wsLimit, s.WebSocket)
+	}
+
+	return r, nil
+}
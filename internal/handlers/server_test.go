@@ -0,0 +1,172 @@
+package handlers
+
+import (
+	"NoteApi/internal/audit"
+	"NoteApi/internal/auth"
+	"NoteApi/internal/config"
+	"NoteApi/internal/models"
+	"NoteApi/internal/quota"
+	"NoteApi/internal/repository"
+	"NoteApi/pkg/utils"
+	"bytes"
+	"context"
+	"encoding/json"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// pngImage is the smallest content sniffed as image/png.
+var pngImage = []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR\x00\x00\x00\x01\x00\x00\x00\x01\x08\x06\x00\x00\x00\x1f\x15\xc4\x89")
+
+// fakeEvents records what the handlers publish.
+type fakeEvents struct {
+	mu      sync.Mutex
+	updated []uuid.UUID
+	deleted []uuid.UUID
+	lists   map[uuid.UUID][]models.Note
+}
+
+func (f *fakeEvents) NoteUpdated(ctx context.Context, note models.Note, userID uuid.UUID) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.updated = append(f.updated, note.ID)
+}
+
+func (f *fakeEvents) NoteDeleted(ctx context.Context, noteID, userID uuid.UUID) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.deleted = append(f.deleted, noteID)
+}
+
+func (f *fakeEvents) NoteListChanged(ctx context.Context, notes []models.Note, userID uuid.UUID) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.lists == nil {
+		f.lists = map[uuid.UUID][]models.Note{}
+	}
+	f.lists[userID] = notes
+}
+
+func (f *fakeEvents) QuotaWarning(ctx context.Context, usage quota.Usage, userID uuid.UUID) {}
+
+func (f *fakeEvents) TokenRevoked(userID uuid.UUID, tokenID string) {}
+
+func (f *fakeEvents) TokensRevokedBefore(userID uuid.UUID, cutoff time.Time) {}
+
+func (f *fakeEvents) Health(ctx context.Context) (int, error) {
+	return 0, nil
+}
+
+// testServer serves the note and upload routes from a repository.Memory.
+// Requests authenticate as the user in their X-Test-User header.
+type testServer struct {
+	*Server
+	repo   *repository.Memory
+	events *fakeEvents
+	router *gin.Engine
+}
+
+func newTestServer(t *testing.T) *testServer {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	utils.SetSigningKey([]byte("0123456789abcdef0123456789abcdef"))
+
+	ts := &testServer{repo: repository.NewMemory(), events: &fakeEvents{}}
+	ts.Server = &Server{Config: config.Defaults(), Notes: ts.repo, Events: ts.events}
+
+	r := gin.New()
+	authed := r.Group("/", authenticateTestUser)
+	authed.POST("/notes", ts.CreateNote)
+	authed.GET("/notes", ts.ListNotes)
+	authed.GET("/notes/:id", ts.GetNote)
+	authed.PUT("/notes/:id", ts.UpdateNote)
+	authed.DELETE("/notes/:id", ts.DeleteNote)
+	authed.POST("/upload", ts.UploadFile)
+	authed.GET("/uploads/:filename", ts.DownloadUpload)
+	ts.router = r
+	return ts
+}
+
+func authenticateTestUser(c *gin.Context) {
+	userID, err := uuid.Parse(c.GetHeader("X-Test-User"))
+	if err != nil {
+		c.AbortWithStatus(http.StatusUnauthorized)
+		return
+	}
+	auth.SetPrincipal(c, auth.Principal{UserID: userID, Scopes: auth.AllScopes})
+	c.Next()
+}
+
+// do sends a request as userID and returns the recorded response.
+func (ts *testServer) do(userID uuid.UUID, method, target string, body io.Reader, contentType string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, target, body)
+	req.Header.Set("X-Test-User", userID.String())
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	w := httptest.NewRecorder()
+	ts.router.ServeHTTP(w, req)
+	return w
+}
+
+// createNote stores a personal note for userID directly in the repository.
+func (ts *testServer) createNote(t *testing.T, userID uuid.UUID, title, content string) models.Note {
+	t.Helper()
+	note := models.Note{UserID: userID, Title: title, Content: content}
+	if err := ts.repo.CreateNote(context.Background(), &note, audit.Actor{UserID: userID}); err != nil {
+		t.Fatalf("CreateNote: %v", err)
+	}
+	return note
+}
+
+// formFile is a file part of a multipart form.
+type formFile struct {
+	field, name string
+	content     []byte
+}
+
+// multipartForm encodes fields and files and returns the body with its
+// Content-Type.
+func multipartForm(t *testing.T, fields map[string]string, files ...formFile) (*bytes.Buffer, string) {
+	t.Helper()
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	for name, value := range fields {
+		if err := form.WriteField(name, value); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, file := range files {
+		part, err := form.CreateFormFile(file.field, file.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		part.Write(file.content)
+	}
+	if err := form.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return &body, form.FormDataContentType()
+}
+
+// decode unmarshals the response body into v, failing the test on a status
+// other than want.
+func decode(t *testing.T, w *httptest.ResponseRecorder, want int, v interface{}) {
+	t.Helper()
+	if w.Code != want {
+		t.Fatalf("status = %d, want %d; body: %s", w.Code, want, w.Body.String())
+	}
+	if v == nil {
+		return
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), v); err != nil {
+		t.Fatalf("decoding %s: %v", w.Body.String(), err)
+	}
+}
@@ -0,0 +1,33 @@
+package handlers
+
+import (
+	"NoteApi/internal/auth"
+	"NoteApi/internal/middleware"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"time"
+)
+
+// IssueWebSocketTicket returns a short-lived, single-use ticket that
+// authenticates one websocket upgrade as the caller (/ws?ticket=...), so the
+// token itself never appears in a URL.
+func (s *Server) IssueWebSocketTicket(c *gin.Context) {
+	principal, ok := auth.FromContext(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User ID not found"})
+		return
+	}
+
+	ticket, expiresAt, err := s.Accounts.IssueTicket(c.Request.Context(), principal)
+	if err != nil {
+		middleware.Logger(c).Error("Failed to issue websocket ticket", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to issue ticket"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"ticket":     ticket,
+		"expires_at": expiresAt,
+		"expires_in": int(time.Until(expiresAt).Round(time.Second).Seconds()),
+	})
+}
@@ -0,0 +1,148 @@
+package handlers
+
+import (
+	"NoteApi/internal/auth"
+	"NoteApi/internal/middleware"
+	"NoteApi/internal/models"
+	"NoteApi/internal/repository"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"strings"
+	"time"
+)
+
+type createAPITokenRequest struct {
+	Name      string     `json:"name"       binding:"required,max=100"`
+	Scopes    []string   `json:"scopes"`
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+type apiTokenResponse struct {
+	ID         string     `json:"id"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"`
+	Scopes     []string   `json:"scopes"`
+	ExpiresAt  *time.Time `json:"expires_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+func newAPITokenResponse(token models.APIToken) apiTokenResponse {
+	return apiTokenResponse{
+		ID:         token.ID.String(),
+		Name:       token.Name,
+		Prefix:     token.Prefix,
+		Scopes:     strings.Fields(token.Scopes),
+		ExpiresAt:  token.ExpiresAt,
+		LastUsedAt: token.LastUsedAt,
+		RevokedAt:  token.RevokedAt,
+		CreatedAt:  token.CreatedAt,
+	}
+}
+
+// CreateAPIToken issues a personal access token. The plaintext is only
+// returned in this response. Tokens cannot be used to mint further tokens,
+// and never carry a scope the caller was not granted.
+func (s *Server) CreateAPIToken(c *gin.Context) {
+	principal, ok := auth.FromContext(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User ID not found"})
+		return
+	}
+	if principal.ViaAPIToken() {
+		c.JSON(http.StatusForbidden, gin.H{"error": "API tokens cannot create other API tokens"})
+		return
+	}
+
+	var req createAPITokenRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request: name is required"})
+		return
+	}
+	if len(req.Scopes) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "At least one scope is required", "scopes": auth.AllScopes})
+		return
+	}
+	for _, scope := range req.Scopes {
+		if !auth.IsKnownScope(scope) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown scope: " + scope, "scopes": auth.AllScopes})
+			return
+		}
+		if !principal.HasScope(scope) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Cannot grant a scope you do not hold: " + scope, "scope": scope})
+			return
+		}
+	}
+	if req.ExpiresAt != nil && !req.ExpiresAt.After(time.Now()) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "expires_at must be in the future"})
+		return
+	}
+
+	plaintext, token, err := s.Accounts.CreateAPIToken(c.Request.Context(), principal.UserID, req.Name, req.Scopes, req.ExpiresAt)
+	if err != nil {
+		middleware.Logger(c).Error("Failed to create API token", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create API token"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"token":     plaintext,
+		"api_token": newAPITokenResponse(token),
+	})
+}
+
+// ListAPITokens returns the caller's tokens, including revoked ones, without
+// their secrets.
+func (s *Server) ListAPITokens(c *gin.Context) {
+	userID, ok := userIDFromContext(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User ID not found"})
+		return
+	}
+
+	tokens, err := s.Accounts.ListAPITokens(c.Request.Context(), userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch API tokens"})
+		return
+	}
+
+	response := make([]apiTokenResponse, len(tokens))
+	for i, token := range tokens {
+		response[i] = newAPITokenResponse(token)
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// RevokeAPIToken stops a token from authenticating. The record is kept so it
+// still shows up in the list.
+func (s *Server) RevokeAPIToken(c *gin.Context) {
+	userID, ok := userIDFromContext(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User ID not found"})
+		return
+	}
+
+	token, err := s.Accounts.FindAPIToken(c.Request.Context(), userID, c.Param("id"))
+	if err != nil {
+		if !errors.Is(err, repository.ErrAPITokenNotFound) {
+			middleware.Logger(c).Error("Failed to load API token", "error", err)
+		}
+		c.JSON(http.StatusNotFound, gin.H{"error": "API token not found"})
+		return
+	}
+
+	if token.RevokedAt == nil {
+		now := time.Now()
+		token.RevokedAt = &now
+		if err := s.Accounts.RevokeAPIToken(c.Request.Context(), token.ID, now); err != nil {
+			middleware.Logger(c).Error("Failed to revoke API token", "error", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke API token"})
+			return
+		}
+	}
+	s.Events.TokenRevoked(userID, token.ID.String())
+
+	c.JSON(http.StatusOK, newAPITokenResponse(token))
+}
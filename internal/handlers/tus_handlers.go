@@ -0,0 +1,423 @@
+package handlers
+
+import (
+	"NoteApi/internal/audit"
+	"NoteApi/internal/metrics"
+	"NoteApi/internal/middleware"
+	"NoteApi/internal/models"
+	"NoteApi/internal/repository"
+	"NoteApi/internal/storage"
+	"NoteApi/pkg/utils"
+	"context"
+	"encoding/base64"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Resumable uploads following the tus 1.0 protocol (https://tus.io), with the
+// creation, termination and expiration extensions. Clients pass note_id,
+// target ("dashboard" or "attachment"), filename and filetype in
+// Upload-Metadata. Unfinished uploads expire after config.UploadsConfig's
+// TusExpiry without data, measured from the last PATCH.
+
+const (
+	TusVersion    = "1.0.0"
+	TusExtensions = "creation,termination,expiration"
+)
+
+// CheckTusResumable rejects requests for a protocol version we don't speak and
+// stamps every response with the version we do.
+func CheckTusResumable() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Header("Tus-Resumable", TusVersion)
+		if c.Request.Method != http.MethodOptions && c.GetHeader("Tus-Resumable") != TusVersion {
+			c.Header("Tus-Version", TusVersion)
+			c.AbortWithStatus(http.StatusPreconditionFailed)
+			return
+		}
+		c.Next()
+	}
+}
+
+func (s *Server) TusOptions(c *gin.Context) {
+	c.Header("Tus-Version", TusVersion)
+	c.Header("Tus-Extension", TusExtensions)
+	c.Header("Tus-Max-Size", strconv.FormatInt(s.Config.Uploads.TusMaxSize, 10))
+	c.Status(http.StatusNoContent)
+}
+
+func (s *Server) TusCreate(c *gin.Context) {
+	userID, ok := userIDFromContext(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User ID not found"})
+		return
+	}
+
+	length, err := strconv.ParseInt(c.GetHeader("Upload-Length"), 10, 64)
+	if err != nil || length < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid Upload-Length"})
+		return
+	}
+	if length > s.Config.Uploads.TusMaxSize {
+		metrics.UploadRejected(metrics.RejectSize)
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "Upload exceeds Tus-Max-Size"})
+		return
+	}
+	if !s.checkQuota(c, userID, length, 0) {
+		return
+	}
+
+	metadata, err := parseTusMetadata(c.GetHeader("Upload-Metadata"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid Upload-Metadata"})
+		return
+	}
+
+	target := metadata["target"]
+	if target != models.TusTargetDashboard && target != models.TusTargetAttachment {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Metadata target must be dashboard or attachment"})
+		return
+	}
+
+	// Reject obviously unacceptable files up front; the content is sniffed
+	// again once it has arrived.
+	if filetype := metadata["filetype"]; filetype != "" {
+		if target == models.TusTargetDashboard && !strings.HasPrefix(filetype, "image/") {
+			metrics.UploadRejected(metrics.RejectType)
+			c.JSON(http.StatusUnsupportedMediaType, gin.H{"error": "Dashboard image must be an image"})
+			return
+		}
+		if err := storage.CheckType(filetype, length); err != nil {
+			respondStorageError(c, err)
+			return
+		}
+	}
+
+	noteID, err := uuid.Parse(metadata["note_id"])
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid note_id in metadata"})
+		return
+	}
+	if _, err := s.Notes.FindNote(c.Request.Context(), noteID.String(), userID, models.RoleEditor); err != nil {
+		respondWorkspaceError(c, err)
+		return
+	}
+
+	upload := models.TusUpload{
+		UserID:    userID,
+		NoteID:    noteID,
+		Target:    target,
+		Filename:  metadata["filename"],
+		FileType:  metadata["filetype"],
+		Metadata:  c.GetHeader("Upload-Metadata"),
+		Length:    length,
+		ExpiresAt: s.tusExpiry(),
+	}
+	if err := s.TusUploads.CreateTusUpload(c.Request.Context(), &upload); err != nil {
+		middleware.Logger(c).Error("Failed to create tus upload", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create upload"})
+		return
+	}
+
+	if err := utils.EnsureDir(storage.TusPath("")); err != nil {
+		middleware.Logger(c).Error("Failed to create tus directory", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create upload"})
+		return
+	}
+	file, err := os.Create(storage.TusPath(upload.ID.String()))
+	if err != nil {
+		middleware.Logger(c).Error("Failed to create tus file", "error", err)
+		s.TusUploads.DeleteTusUpload(c.Request.Context(), upload.ID)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create upload"})
+		return
+	}
+	file.Close()
+
+	c.Header("Location", "/uploads/tus/"+upload.ID.String())
+	c.Header("Upload-Offset", "0")
+	setUploadExpires(c, upload)
+
+	// A zero-length upload is already complete
+	if length == 0 {
+		if !s.finishTusUpload(c, &upload) {
+			return
+		}
+	}
+
+	c.Status(http.StatusCreated)
+}
+
+func (s *Server) TusHead(c *gin.Context) {
+	upload, ok := s.findTusUpload(c)
+	if !ok || tusGone(c, upload) {
+		return
+	}
+
+	c.Header("Cache-Control", "no-store")
+	setUploadExpires(c, upload)
+	c.Header("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+	c.Header("Upload-Length", strconv.FormatInt(upload.Length, 10))
+	if upload.Path != "" {
+		c.Header("X-Upload-Path", utils.SignPath(upload.Path))
+	}
+	if upload.Metadata != "" {
+		c.Header("Upload-Metadata", upload.Metadata)
+	}
+	c.Status(http.StatusOK)
+}
+
+func (s *Server) TusPatch(c *gin.Context) {
+	if c.ContentType() != "application/offset+octet-stream" {
+		c.JSON(http.StatusUnsupportedMediaType, gin.H{"error": "Content-Type must be application/offset+octet-stream"})
+		return
+	}
+
+	upload, ok := s.findTusUpload(c)
+	if !ok || tusGone(c, upload) {
+		return
+	}
+
+	offset, err := strconv.ParseInt(c.GetHeader("Upload-Offset"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid Upload-Offset"})
+		return
+	}
+	if offset != upload.Offset {
+		c.JSON(http.StatusConflict, gin.H{"error": "Upload-Offset does not match current offset"})
+		return
+	}
+
+	// Everything has arrived; retry attaching it if that failed before.
+	if upload.Offset == upload.Length {
+		if upload.Path == "" && !s.finishTusUpload(c, &upload) {
+			return
+		}
+		c.Header("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+		c.Status(http.StatusNoContent)
+		return
+	}
+
+	// Only one request may write at a time; the others find the offset
+	// moved once it finishes and get a conflict without touching the file.
+	// Whatever arrives is kept even if the connection drops part way
+	// through; that is the point of resuming.
+	var written int64
+	var openErr, copyErr error
+	expiresAt := s.tusExpiry()
+	err = s.TusUploads.WriteTusUpload(c.Request.Context(), upload.ID, offset, expiresAt, func() int64 {
+		file, err := os.OpenFile(storage.TusPath(upload.ID.String()), os.O_WRONLY, 0644)
+		if err != nil {
+			openErr = err
+			return 0
+		}
+		remaining := upload.Length - upload.Offset
+		written, copyErr = io.Copy(io.NewOffsetWriter(file, offset), io.LimitReader(c.Request.Body, remaining))
+		if closeErr := file.Close(); copyErr == nil {
+			copyErr = closeErr
+		}
+		metrics.UploadReceived(metrics.UploadTus, written)
+		return written
+	})
+	if errors.Is(err, repository.ErrTusOffsetChanged) {
+		c.JSON(http.StatusConflict, gin.H{"error": "Upload-Offset does not match current offset"})
+		return
+	}
+	if errors.Is(err, repository.ErrTusUploadNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Upload not found"})
+		return
+	}
+	if err == nil && openErr != nil {
+		err = openErr
+	}
+	if err != nil {
+		middleware.Logger(c).Error("Failed to write tus upload", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to write upload"})
+		return
+	}
+	newOffset := offset + written
+	upload.Offset = newOffset
+	upload.ExpiresAt = expiresAt
+	setUploadExpires(c, upload)
+
+	if copyErr != nil {
+		middleware.Logger(c).Warn("Tus upload interrupted", "upload_id", upload.ID, "offset", newOffset, "error", copyErr)
+		c.Header("Upload-Offset", strconv.FormatInt(newOffset, 10))
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Upload interrupted"})
+		return
+	}
+
+	if upload.Offset == upload.Length {
+		if !s.finishTusUpload(c, &upload) {
+			return
+		}
+	}
+
+	c.Header("Upload-Offset", strconv.FormatInt(newOffset, 10))
+	c.Status(http.StatusNoContent)
+}
+
+func (s *Server) TusDelete(c *gin.Context) {
+	upload, ok := s.findTusUpload(c)
+	if !ok {
+		return
+	}
+
+	// Termination only cancels the transfer; a finished upload already belongs
+	// to its note and is removed along with it.
+	if err := s.TusUploads.DeleteTusUpload(c.Request.Context(), upload.ID); err != nil {
+		middleware.Logger(c).Error("Failed to delete tus upload", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete upload"})
+		return
+	}
+	if err := os.Remove(storage.TusPath(upload.ID.String())); err != nil && !os.IsNotExist(err) {
+		middleware.Logger(c).Error("Failed to remove tus file", "error", err)
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// tusExpiry returns when an upload receiving data now expires.
+func (s *Server) tusExpiry() time.Time {
+	return time.Now().Add(time.Duration(s.Config.Uploads.TusExpiry))
+}
+
+// setUploadExpires sets the Upload-Expires header for an unfinished upload.
+func setUploadExpires(c *gin.Context, upload models.TusUpload) {
+	if upload.Path == "" && !upload.ExpiresAt.IsZero() {
+		c.Header("Upload-Expires", upload.ExpiresAt.UTC().Format(http.TimeFormat))
+	}
+}
+
+// tusGone writes a 410 and returns true when upload expired before it was
+// finished; it can only be terminated.
+func tusGone(c *gin.Context, upload models.TusUpload) bool {
+	if !upload.Expired(time.Now()) {
+		return false
+	}
+	c.JSON(http.StatusGone, gin.H{"error": "Upload expired"})
+	return true
+}
+
+// findTusUpload loads the caller's upload named in the URL, writing a 404 if
+// it does not exist.
+func (s *Server) findTusUpload(c *gin.Context) (models.TusUpload, bool) {
+	userID, ok := userIDFromContext(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User ID not found"})
+		return models.TusUpload{}, false
+	}
+
+	upload, err := s.TusUploads.FindTusUpload(c.Request.Context(), c.Param("id"), userID)
+	if err != nil {
+		if !errors.Is(err, repository.ErrTusUploadNotFound) {
+			middleware.Logger(c).Error("Failed to load tus upload", "error", err)
+		}
+		c.JSON(http.StatusNotFound, gin.H{"error": "Upload not found"})
+		return models.TusUpload{}, false
+	}
+	return upload, true
+}
+
+// finishTusUpload moves the completed content into the blob store and attaches
+// it to the target note.
+func (s *Server) finishTusUpload(c *gin.Context, tus *models.TusUpload) bool {
+	stagingPath := storage.TusPath(tus.ID.String())
+	file, err := os.Open(stagingPath)
+	if err != nil {
+		middleware.Logger(c).Error("Failed to open completed tus upload", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to finish upload"})
+		return false
+	}
+	defer file.Close()
+
+	contentType, ok := sniffUpload(c, file, tus.Length, tus.Target == models.TusTargetDashboard)
+	if !ok {
+		// The content will never be accepted, so don't keep it around
+		s.TusUploads.DeleteTusUpload(c.Request.Context(), tus.ID)
+		file.Close()
+		os.Remove(stagingPath)
+		return false
+	}
+
+	actor := audit.ActorFromContext(c)
+	upload, err := s.Notes.SaveUpload(c.Request.Context(), tus.UserID, file, tus.Filename, contentType, actor)
+	if err != nil {
+		middleware.Logger(c).Error("Failed to store completed tus upload", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to finish upload"})
+		return false
+	}
+
+	if err := s.attachUpload(c.Request.Context(), actor, tus.NoteID, tus.UserID, tus.Target, upload); err != nil {
+		middleware.Logger(c).Error("Failed to attach tus upload", "error", err)
+		s.Notes.ReleaseUpload(c.Request.Context(), upload.Path)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to attach upload to note"})
+		return false
+	}
+
+	// Keep the record so HEAD keeps reporting a complete upload to clients
+	// that lost the final response.
+	tus.Path = upload.Path
+	if err := s.TusUploads.CompleteTusUpload(c.Request.Context(), tus.ID, upload.Path); err != nil {
+		middleware.Logger(c).Error("Failed to mark tus upload complete", "error", err)
+	}
+	file.Close()
+	os.Remove(stagingPath)
+
+	c.Header("Location", "/uploads/tus/"+tus.ID.String())
+	c.Header("X-Upload-Path", utils.SignPath(upload.Path))
+	return true
+}
+
+// attachUpload makes upload the note's dashboard image or adds it as an
+// attachment, then notifies the owner's websocket clients. Changing the
+// dashboard image is audited as a note update.
+func (s *Server) attachUpload(ctx context.Context, actor audit.Actor, noteID, userID uuid.UUID, target string, upload models.Upload) error {
+	note, err := s.Notes.FindNote(ctx, noteID.String(), userID, models.RoleEditor)
+	if err != nil {
+		return err
+	}
+
+	previousPath := note.DashboardPath
+	asDashboard := target == models.TusTargetDashboard
+	if err := s.Notes.AttachUpload(ctx, &note, upload, asDashboard, actor); err != nil {
+		return err
+	}
+
+	if asDashboard {
+		if err := s.Notes.ReleaseUpload(ctx, previousPath); err != nil {
+			slog.Error("Failed to release previous dashboard image", "request_id", actor.RequestID, "note_id", noteID, "error", err)
+		}
+	}
+
+	s.broadcastNoteUpdate(ctx, note)
+	go s.notifyQuota(context.WithoutCancel(ctx), userID)
+	return nil
+}
+
+// parseTusMetadata decodes "key base64value,key2 base64value2".
+func parseTusMetadata(header string) (map[string]string, error) {
+	metadata := map[string]string{}
+	if header == "" {
+		return metadata, nil
+	}
+	for _, pair := range strings.Split(header, ",") {
+		key, encoded, _ := strings.Cut(strings.TrimSpace(pair), " ")
+		if key == "" {
+			return nil, errors.New("empty metadata key")
+		}
+		value, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, err
+		}
+		metadata[key] = string(value)
+	}
+	return metadata, nil
+}
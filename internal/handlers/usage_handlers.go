@@ -0,0 +1,77 @@
+package handlers
+
+import (
+	"NoteApi/internal/metrics"
+	"NoteApi/internal/middleware"
+	"NoteApi/internal/quota"
+	"context"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"log/slog"
+	"net/http"
+)
+
+// GetUsage reports the caller's storage usage and limits.
+func (s *Server) GetUsage(c *gin.Context) {
+	userID, ok := userIDFromContext(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User ID not found"})
+		return
+	}
+
+	usage, err := s.Notes.Usage(c.Request.Context(), userID)
+	if err != nil {
+		middleware.Logger(c).Error("Failed to compute usage", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to compute usage"})
+		return
+	}
+
+	c.JSON(http.StatusOK, usage)
+}
+
+// checkQuota writes a 413 and returns false when storing extraBytes and
+// creating extraNotes would put the user over their quota.
+func (s *Server) checkQuota(c *gin.Context, userID uuid.UUID, extraBytes, extraNotes int64) bool {
+	usage, err := s.Notes.Usage(c.Request.Context(), userID)
+	if err == nil {
+		err = usage.Check(extraBytes, extraNotes)
+	}
+	if err == nil {
+		return true
+	}
+
+	var exceeded *quota.ExceededError
+	if errors.As(err, &exceeded) {
+		if extraBytes > 0 {
+			metrics.UploadRejected(metrics.RejectQuota)
+		}
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{
+			"error": "Quota exceeded",
+			"quota": gin.H{
+				"limit":     exceeded.Limit,
+				"used":      exceeded.Used,
+				"requested": exceeded.Requested,
+				"max":       exceeded.Max,
+			},
+		})
+		return false
+	}
+
+	middleware.Logger(c).Error("Failed to check quota", "error", err)
+	c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check quota"})
+	return false
+}
+
+// notifyQuota warns the user's websocket clients the first time their usage
+// passes quota.WarnRatio.
+func (s *Server) notifyQuota(ctx context.Context, userID uuid.UUID) {
+	usage, err := s.Notes.Usage(ctx, userID)
+	if err != nil {
+		slog.Error("Failed to compute usage", "user_id", userID, "error", err)
+		return
+	}
+	if quota.CrossedWarning(userID, usage) {
+		s.Events.QuotaWarning(ctx, usage, userID)
+	}
+}
@@ -0,0 +1,460 @@
+package handlers
+
+import (
+	"NoteApi/internal/audit"
+	"NoteApi/internal/middleware"
+	"NoteApi/internal/models"
+	"NoteApi/internal/repository"
+	"NoteApi/internal/workspace"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type workspaceRequest struct {
+	Name string `json:"name" binding:"required,max=100"`
+}
+
+type memberRoleRequest struct {
+	Role string `json:"role" binding:"required"`
+}
+
+type invitationRequest struct {
+	Role      string     `json:"role"       binding:"required"`
+	UserID    *uuid.UUID `json:"user_id"`
+	ExpiresIn int        `json:"expires_in"`
+}
+
+type acceptInvitationRequest struct {
+	Code string `json:"code" binding:"required"`
+}
+
+type shareNoteRequest struct {
+	WorkspaceID uuid.UUID `json:"workspace_id" binding:"required"`
+}
+
+type workspaceResponse struct {
+	models.Workspace
+	Role string `json:"role"`
+}
+
+// workspaceFromParam resolves the :id parameter to a workspace the caller
+// belongs to with at least role min. It writes the error response itself and
+// returns false when the request should stop.
+func (s *Server) workspaceFromParam(c *gin.Context, min string) (uuid.UUID, uuid.UUID, string, bool) {
+	userID, ok := userIDFromContext(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User ID not found"})
+		return uuid.Nil, uuid.Nil, "", false
+	}
+	workspaceID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Workspace not found"})
+		return uuid.Nil, uuid.Nil, "", false
+	}
+	role, err := s.Notes.RequireRole(c.Request.Context(), workspaceID, userID, min)
+	if err != nil {
+		respondWorkspaceError(c, err)
+		return uuid.Nil, uuid.Nil, "", false
+	}
+	return workspaceID, userID, role, true
+}
+
+func (s *Server) CreateWorkspace(c *gin.Context) {
+	userID, ok := userIDFromContext(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User ID not found"})
+		return
+	}
+
+	var req workspaceRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request: name is required"})
+		return
+	}
+
+	created, err := s.Workspaces.CreateWorkspace(c.Request.Context(), strings.TrimSpace(req.Name), userID)
+	if err != nil {
+		middleware.Logger(c).Error("Failed to create workspace", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create workspace"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, workspaceResponse{Workspace: created, Role: models.RoleOwner})
+}
+
+// ListWorkspaces returns the workspaces the caller belongs to with their role
+// in each.
+func (s *Server) ListWorkspaces(c *gin.Context) {
+	userID, ok := userIDFromContext(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User ID not found"})
+		return
+	}
+
+	workspaces, err := s.Workspaces.ListWorkspaces(c.Request.Context(), userID)
+	if err != nil {
+		middleware.Logger(c).Error("Failed to list workspaces", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list workspaces"})
+		return
+	}
+
+	c.JSON(http.StatusOK, workspaces)
+}
+
+func (s *Server) GetWorkspace(c *gin.Context) {
+	workspaceID, _, role, ok := s.workspaceFromParam(c, models.RoleViewer)
+	if !ok {
+		return
+	}
+
+	found, err := s.Workspaces.FindWorkspace(c.Request.Context(), workspaceID)
+	if err != nil {
+		respondWorkspaceError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, workspaceResponse{Workspace: found, Role: role})
+}
+
+func (s *Server) UpdateWorkspace(c *gin.Context) {
+	workspaceID, _, role, ok := s.workspaceFromParam(c, models.RoleAdmin)
+	if !ok {
+		return
+	}
+
+	var req workspaceRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request: name is required"})
+		return
+	}
+
+	found, err := s.Workspaces.FindWorkspace(c.Request.Context(), workspaceID)
+	if err != nil {
+		respondWorkspaceError(c, err)
+		return
+	}
+	if err := s.Workspaces.RenameWorkspace(c.Request.Context(), &found, strings.TrimSpace(req.Name)); err != nil {
+		middleware.Logger(c).Error("Failed to update workspace", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update workspace"})
+		return
+	}
+
+	c.JSON(http.StatusOK, workspaceResponse{Workspace: found, Role: role})
+}
+
+// DeleteWorkspace removes an empty workspace. Its notes must be deleted and
+// purged from the trash first so nothing is lost by accident.
+func (s *Server) DeleteWorkspace(c *gin.Context) {
+	workspaceID, _, _, ok := s.workspaceFromParam(c, models.RoleOwner)
+	if !ok {
+		return
+	}
+
+	notes, trashed, err := s.Workspaces.CountWorkspaceNotes(c.Request.Context(), workspaceID)
+	if err != nil {
+		middleware.Logger(c).Error("Failed to count workspace notes", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete workspace"})
+		return
+	}
+	if notes+trashed > 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "Workspace still has notes", "notes": notes, "trashed_notes": trashed})
+		return
+	}
+
+	if err := s.Workspaces.DeleteWorkspace(c.Request.Context(), workspaceID); err != nil {
+		middleware.Logger(c).Error("Failed to delete workspace", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete workspace"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Workspace deleted successfully"})
+}
+
+func (s *Server) ListWorkspaceMembers(c *gin.Context) {
+	workspaceID, _, _, ok := s.workspaceFromParam(c, models.RoleViewer)
+	if !ok {
+		return
+	}
+
+	members, err := s.Workspaces.ListMembers(c.Request.Context(), workspaceID)
+	if err != nil {
+		middleware.Logger(c).Error("Failed to list workspace members", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list members"})
+		return
+	}
+
+	c.JSON(http.StatusOK, members)
+}
+
+// UpdateWorkspaceMember changes a member's role. Admins manage editors and
+// viewers; owners manage everyone.
+func (s *Server) UpdateWorkspaceMember(c *gin.Context) {
+	workspaceID, _, role, ok := s.workspaceFromParam(c, models.RoleAdmin)
+	if !ok {
+		return
+	}
+	memberID, err := uuid.Parse(c.Param("user_id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Member not found"})
+		return
+	}
+
+	var req memberRoleRequest
+	if err := c.ShouldBindJSON(&req); err != nil || !workspace.ValidRole(req.Role) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request: role must be owner, admin, editor or viewer"})
+		return
+	}
+
+	current, err := s.Workspaces.MemberRole(c.Request.Context(), workspaceID, memberID)
+	if err != nil || current == "" {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Member not found"})
+		return
+	}
+	if !workspace.CanGrant(role, current) || !workspace.CanGrant(role, req.Role) {
+		respondWorkspaceError(c, workspace.ErrForbidden)
+		return
+	}
+
+	if err := s.Workspaces.SetMemberRole(c.Request.Context(), workspaceID, memberID, req.Role); err != nil {
+		respondWorkspaceError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"workspace_id": workspaceID, "user_id": memberID, "role": req.Role})
+}
+
+// RemoveWorkspaceMember removes a member. Anyone may leave; removing someone
+// else follows the same rules as changing their role.
+func (s *Server) RemoveWorkspaceMember(c *gin.Context) {
+	workspaceID, userID, role, ok := s.workspaceFromParam(c, models.RoleViewer)
+	if !ok {
+		return
+	}
+	memberID, err := uuid.Parse(c.Param("user_id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Member not found"})
+		return
+	}
+
+	if memberID != userID {
+		current, err := s.Workspaces.MemberRole(c.Request.Context(), workspaceID, memberID)
+		if err != nil || current == "" {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Member not found"})
+			return
+		}
+		if !workspace.CanGrant(role, current) {
+			respondWorkspaceError(c, workspace.ErrForbidden)
+			return
+		}
+	}
+
+	if err := s.Workspaces.RemoveMember(c.Request.Context(), workspaceID, memberID); err != nil {
+		respondWorkspaceError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Member removed successfully"})
+}
+
+// CreateWorkspaceInvitation returns an invitation code. The code is only
+// shown in this response.
+func (s *Server) CreateWorkspaceInvitation(c *gin.Context) {
+	workspaceID, userID, role, ok := s.workspaceFromParam(c, models.RoleAdmin)
+	if !ok {
+		return
+	}
+
+	var req invitationRequest
+	if err := c.ShouldBindJSON(&req); err != nil || !workspace.ValidRole(req.Role) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request: role must be owner, admin, editor or viewer"})
+		return
+	}
+	if !workspace.CanGrant(role, req.Role) {
+		respondWorkspaceError(c, workspace.ErrForbidden)
+		return
+	}
+	if req.ExpiresIn < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "expires_in must be positive"})
+		return
+	}
+
+	code, invitation, err := s.Workspaces.CreateInvitation(c.Request.Context(), workspaceID, userID, req.Role, req.UserID, time.Duration(req.ExpiresIn)*time.Second)
+	if err != nil {
+		respondWorkspaceError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"code": code, "invitation": invitation})
+}
+
+// ListWorkspaceInvitations returns the invitations that can still be
+// accepted.
+func (s *Server) ListWorkspaceInvitations(c *gin.Context) {
+	workspaceID, _, _, ok := s.workspaceFromParam(c, models.RoleAdmin)
+	if !ok {
+		return
+	}
+
+	invitations, err := s.Workspaces.ListInvitations(c.Request.Context(), workspaceID)
+	if err != nil {
+		middleware.Logger(c).Error("Failed to list invitations", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list invitations"})
+		return
+	}
+
+	c.JSON(http.StatusOK, invitations)
+}
+
+func (s *Server) RevokeWorkspaceInvitation(c *gin.Context) {
+	workspaceID, _, _, ok := s.workspaceFromParam(c, models.RoleAdmin)
+	if !ok {
+		return
+	}
+
+	err := s.Workspaces.RevokeInvitation(c.Request.Context(), workspaceID, c.Param("invitation_id"))
+	if errors.Is(err, repository.ErrInvitationNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Invitation not found"})
+		return
+	}
+	if err != nil {
+		middleware.Logger(c).Error("Failed to revoke invitation", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke invitation"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Invitation revoked"})
+}
+
+func (s *Server) AcceptWorkspaceInvitation(c *gin.Context) {
+	userID, ok := userIDFromContext(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User ID not found"})
+		return
+	}
+
+	var req acceptInvitationRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request: code is required"})
+		return
+	}
+
+	member, err := s.Workspaces.AcceptInvitation(c.Request.Context(), req.Code, userID)
+	if err != nil {
+		respondWorkspaceError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, member)
+}
+
+// ListWorkspaceNotes returns every note in the workspace outside the trash.
+func (s *Server) ListWorkspaceNotes(c *gin.Context) {
+	s.listWorkspaceNotes(c, false)
+}
+
+// ListWorkspaceTrash returns the workspace's notes in the trash, most
+// recently deleted first.
+func (s *Server) ListWorkspaceTrash(c *gin.Context) {
+	s.listWorkspaceNotes(c, true)
+}
+
+func (s *Server) listWorkspaceNotes(c *gin.Context, trashed bool) {
+	workspaceID, _, _, ok := s.workspaceFromParam(c, models.RoleViewer)
+	if !ok {
+		return
+	}
+
+	notes, err := s.Workspaces.ListWorkspaceNotes(c.Request.Context(), workspaceID, trashed)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch notes"})
+		return
+	}
+
+	for i := range notes {
+		notes[i] = signNote(notes[i])
+	}
+
+	c.JSON(http.StatusOK, notes)
+}
+
+// SearchWorkspaceNotes runs a full-text search ("q") over the workspace's
+// notes.
+func (s *Server) SearchWorkspaceNotes(c *gin.Context) {
+	workspaceID, _, _, ok := s.workspaceFromParam(c, models.RoleViewer)
+	if !ok {
+		return
+	}
+
+	query := strings.TrimSpace(c.Query("q"))
+	if query == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Query parameter q is required"})
+		return
+	}
+	limit := 50
+	if value := c.Query("limit"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed <= 0 || parsed > 200 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid limit"})
+			return
+		}
+		limit = parsed
+	}
+
+	notes, err := s.Workspaces.SearchNotes(c.Request.Context(), workspaceID, query, limit)
+	if err != nil {
+		respondWorkspaceError(c, err)
+		return
+	}
+
+	for i := range notes {
+		notes[i] = signNote(notes[i])
+	}
+
+	c.JSON(http.StatusOK, notes)
+}
+
+// ShareNote moves one of the caller's personal notes into a workspace where
+// they are at least an editor.
+func (s *Server) ShareNote(c *gin.Context) {
+	userID, ok := userIDFromContext(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User ID not found"})
+		return
+	}
+
+	var req shareNoteRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request: workspace_id is required"})
+		return
+	}
+
+	note, err := s.Notes.FindNote(c.Request.Context(), c.Param("id"), userID, models.RoleEditor)
+	if err != nil {
+		respondWorkspaceError(c, err)
+		return
+	}
+	if note.WorkspaceID != nil {
+		c.JSON(http.StatusConflict, gin.H{"error": "Note already belongs to a workspace"})
+		return
+	}
+	if _, err := s.Notes.RequireRole(c.Request.Context(), req.WorkspaceID, userID, models.RoleEditor); err != nil {
+		respondWorkspaceError(c, err)
+		return
+	}
+
+	if err := s.Notes.ShareNote(c.Request.Context(), &note, req.WorkspaceID, audit.ActorFromContext(c)); err != nil {
+		middleware.Logger(c).Error("Failed to share note", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to share note"})
+		return
+	}
+
+	s.broadcastNoteUpdate(c.Request.Context(), note)
+	s.broadcastNoteList(c.Request.Context(), userID)
+
+	c.JSON(http.StatusOK, signNote(note))
+}
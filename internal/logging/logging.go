@@ -0,0 +1,23 @@
+// internal/logging/logging.go
+package logging
+
+import (
+	"io"
+	"log"
+	"log/slog"
+)
+
+// Setup makes JSON written to out the default log output at the given level
+// ("debug", "info", "warn" or "error"). The standard log package is routed
+// through the same handler, so log.Printf calls come out as JSON too.
+func Setup(level string, out io.Writer) error {
+	var lvl slog.Level
+	if err := lvl.UnmarshalText([]byte(level)); err != nil {
+		return err
+	}
+	// slog adds its own timestamp
+	log.SetFlags(0)
+	handler := slog.NewJSONHandler(out, &slog.HandlerOptions{Level: lvl})
+	slog.SetDefault(slog.New(handler))
+	return nil
+}
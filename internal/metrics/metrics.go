@@ -0,0 +1,158 @@
+// internal/metrics/metrics.go
+package metrics
+
+import (
+	"crypto/subtle"
+	"database/sql"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "noteapi"
+
+var (
+	httpRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "http_requests_total",
+		Help:      "HTTP requests handled, by route and status.",
+	}, []string{"method", "route", "status"})
+
+	httpDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "Time taken to handle HTTP requests, by route and status.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method", "route", "status"})
+
+	wsConnections = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "ws_connections",
+		Help:      "Websocket connections currently open on this instance.",
+	})
+
+	wsBroadcasts = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "ws_broadcasts_total",
+		Help:      "Messages broadcast to websocket clients, by message type.",
+	}, []string{"type"})
+
+	wsDeliveries = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "ws_deliveries_total",
+		Help:      "Broadcast messages written to individual clients (fan-out), by message type.",
+	}, []string{"type"})
+
+	wsWriteFailures = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "ws_write_failures_total",
+		Help:      "Failed writes to websocket clients.",
+	})
+
+	wsDropped = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "ws_dropped_clients_total",
+		Help:      "Websocket clients disconnected by the server, by reason.",
+	}, []string{"reason"})
+
+	uploadBytes = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "upload_bytes_total",
+		Help:      "Upload bytes received, by upload method.",
+	}, []string{"method"})
+
+	uploadRejections = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "upload_rejections_total",
+		Help:      "Uploads refused, by reason.",
+	}, []string{"reason"})
+)
+
+// Reasons a websocket client is dropped.
+const (
+	DropWriteFailed  = "write_failed"
+	DropTokenRevoked = "token_revoked"
+	DropShutdown     = "shutdown"
+)
+
+// Reasons an upload is rejected.
+const (
+	RejectQuota = "quota"
+	RejectType  = "type"
+	RejectSize  = "size"
+)
+
+// Upload methods.
+const (
+	UploadMultipart = "multipart"
+	UploadTus       = "tus"
+)
+
+// ObserveRequest records a handled HTTP request. route should be the route
+// pattern rather than the path, to keep the number of series bounded.
+func ObserveRequest(method, route string, status int, elapsed time.Duration) {
+	statusLabel := strconv.Itoa(status)
+	httpRequests.WithLabelValues(method, route, statusLabel).Inc()
+	httpDuration.WithLabelValues(method, route, statusLabel).Observe(elapsed.Seconds())
+}
+
+// CountRequest records a request without its duration, for websocket
+// upgrades that last as long as the connection.
+func CountRequest(method, route string, status int) {
+	httpRequests.WithLabelValues(method, route, strconv.Itoa(status)).Inc()
+}
+
+func WebSocketConnected()    { wsConnections.Inc() }
+func WebSocketDisconnected() { wsConnections.Dec() }
+func WebSocketWriteFailed()  { wsWriteFailures.Inc() }
+
+// WebSocketDropped counts a client the server disconnected.
+func WebSocketDropped(reason string) {
+	wsDropped.WithLabelValues(reason).Inc()
+}
+
+// WebSocketBroadcast records one broadcast and how many clients it reached.
+func WebSocketBroadcast(messageType string, delivered int) {
+	wsBroadcasts.WithLabelValues(messageType).Inc()
+	wsDeliveries.WithLabelValues(messageType).Add(float64(delivered))
+}
+
+// UploadReceived counts bytes received for an upload.
+func UploadReceived(method string, bytes int64) {
+	uploadBytes.WithLabelValues(method).Add(float64(bytes))
+}
+
+// UploadRejected counts an upload refused for reason.
+func UploadRejected(reason string) {
+	uploadRejections.WithLabelValues(reason).Inc()
+}
+
+// RegisterDB exports the connection pool statistics of db, labelled with
+// the name of its driver.
+func RegisterDB(db *sql.DB, driver string) {
+	prometheus.MustRegister(collectors.NewDBStatsCollector(db, driver))
+}
+
+// Handler serves the metrics in the Prometheus text format. When token is
+// set, scrapes must send it as a bearer token.
+func Handler(token string) http.Handler {
+	handler := promhttp.Handler()
+	if token == "" {
+		return handler
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		given := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(given), []byte(token)) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		handler.ServeHTTP(w, r)
+	})
+}
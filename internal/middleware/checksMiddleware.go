@@ -3,15 +3,19 @@
 package middleware
 
 import (
-	"fmt"
+	"errors"
 	"net/http"
-	"os"
 	"strings"
 
+	"NoteApi/internal/auth"
+	"NoteApi/internal/database"
+	"NoteApi/pkg/utils"
+
 	"github.com/gin-gonic/gin"
-	"github.com/golang-jwt/jwt/v5"
 )
 
+// CheckAuthenticated verifies the bearer token in the Authorization header and
+// stores the resulting auth.Principal on the context.
 func CheckAuthenticated() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		// Get the Authorization header from the request
@@ -30,29 +34,153 @@ func CheckAuthenticated() gin.HandlerFunc {
 			return
 		}
 
-		// Parse and validate the token
-		token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-			// Validate the alg is what you expect
-			if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-				return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+		authenticateToken(c, tokenString)
+	}
+}
+
+// DeferredWebSocketAuthKey is set on upgrade requests that carried no
+// credential; the websocket handler then expects one in the first frame.
+const DeferredWebSocketAuthKey = "ws_auth_deferred"
+
+// allowQueryToken is set from config.WebSocketConfig by SetAllowQueryToken.
+var allowQueryToken bool
+
+// SetAllowQueryToken enables the legacy "token" query parameter on websocket
+// upgrades.
+func SetAllowQueryToken(allowed bool) {
+	allowQueryToken = allowed
+}
+
+// AllowQueryToken reports whether the legacy "token" query parameter is
+// accepted on websocket upgrades. It is off by default because query strings
+// end up in proxy and access logs.
+func AllowQueryToken() bool {
+	return allowQueryToken
+}
+
+// CheckAuthenticatedWebSocket authenticates websocket upgrades, which browsers
+// cannot send an Authorization header with. In order it accepts an
+// Authorization header, a single-use "ticket" from POST /ws/ticket, and (only
+// when AllowQueryToken) a raw "token" query parameter. Without any of them the
+// request is marked for first-frame authentication by the websocket handler.
+func CheckAuthenticatedWebSocket() gin.HandlerFunc {
+	checkHeader := CheckAuthenticated()
+	return func(c *gin.Context) {
+		if c.GetHeader("Authorization") != "" {
+			checkHeader(c)
+			return
+		}
+
+		if ticket := c.Query("ticket"); ticket != "" {
+			principal, err := auth.RedeemTicket(database.DB.WithContext(c.Request.Context()), ticket)
+			if err != nil {
+				abortAuthError(c, err)
+				return
+			}
+			auth.SetPrincipal(c, principal)
+			c.Next()
+			return
+		}
+
+		if tokenString := c.Query("token"); tokenString != "" {
+			if !AllowQueryToken() {
+				c.JSON(http.StatusUnauthorized, gin.H{"error": "Tokens in the query string are disabled; use a ticket from POST /ws/ticket"})
+				c.Abort()
+				return
 			}
-			return []byte(os.Getenv("JWT_SECRET")), nil
-		})
+			authenticateToken(c, tokenString)
+			return
+		}
+
+		c.Set(DeferredWebSocketAuthKey, true)
+		c.Next()
+	}
+}
 
-		if err != nil {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token"})
+// authenticateToken resolves tokenString to a principal with
+// auth.AuthenticateToken and stores it on the context.
+func authenticateToken(c *gin.Context, tokenString string) {
+	principal, err := auth.AuthenticateToken(database.DB.WithContext(c.Request.Context()), tokenString)
+	if err != nil {
+		abortAuthError(c, err)
+		return
+	}
+
+	auth.SetPrincipal(c, principal)
+	c.Next()
+}
+
+// abortAuthError maps an authentication failure to a response.
+func abortAuthError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, auth.ErrTokenRevoked):
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Token revoked"})
+	case errors.Is(err, auth.ErrInvalidToken):
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token"})
+	case errors.Is(err, auth.ErrVerifierUnavailable):
+		Logger(c).Error("Token verifier unavailable", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Authentication is not configured"})
+	default:
+		Logger(c).Error("Failed to authenticate token", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to authenticate"})
+	}
+	c.Abort()
+}
+
+// CheckSignedOrAuthenticated accepts requests carrying a valid URL signature
+// produced by utils.SignPath and falls back to CheckAuthenticated otherwise.
+func CheckSignedOrAuthenticated() gin.HandlerFunc {
+	authenticate := CheckAuthenticated()
+	return func(c *gin.Context) {
+		signature := c.Query("signature")
+		if signature == "" {
+			authenticate(c)
+			return
+		}
+
+		path := strings.TrimPrefix(c.Request.URL.Path, "/")
+		if err := utils.VerifySignedPath(path, c.Query("expires"), signature); err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired URL signature"})
 			c.Abort()
 			return
 		}
 
-		if claims, ok := token.Claims.(jwt.MapClaims); ok && token.Valid {
-			// Set user ID in the context for later use
-			c.Set("user_id", claims["sub"])
-			c.Next()
-		} else {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token claims"})
+		c.Set("signed_url", true)
+		c.Next()
+	}
+}
+
+// RequireScope rejects authenticated requests whose principal lacks scope. It
+// must run after CheckAuthenticated; requests admitted by a URL signature
+// carry no principal and are let through, as are websocket upgrades whose
+// authentication is deferred to the first frame.
+func RequireScope(scope string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		principal, ok := auth.FromContext(c)
+		if !ok {
+			if signed, _ := c.Get("signed_url"); signed == true {
+				c.Next()
+				return
+			}
+			// The websocket handler checks scopes after first-frame auth
+			if c.GetBool(DeferredWebSocketAuthKey) {
+				c.Next()
+				return
+			}
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Not authenticated"})
 			c.Abort()
 			return
 		}
+
+		if !principal.HasScope(scope) {
+			c.JSON(http.StatusForbidden, gin.H{
+				"error": "Missing required scope: " + scope,
+				"scope": scope,
+			})
+			c.Abort()
+			return
+		}
+
+		c.Next()
 	}
 }
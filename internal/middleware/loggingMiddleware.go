@@ -0,0 +1,72 @@
+// internal/middleware/loggingMiddleware.go
+
+package middleware
+
+import (
+	"io"
+	"log/slog"
+	"net/http"
+	"runtime/debug"
+	"time"
+
+	"NoteApi/internal/auth"
+	"NoteApi/internal/tracing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Logger returns the default logger annotated with the request ID, the trace
+// ID when the request is sampled and, once the request is authenticated, the
+// user ID.
+func Logger(c *gin.Context) *slog.Logger {
+	logger := slog.Default().With("request_id", c.GetString(RequestIDKey))
+	if traceID := tracing.TraceID(c.Request.Context()); traceID != "" {
+		logger = logger.With("trace_id", traceID)
+	}
+	if principal, ok := auth.FromContext(c); ok {
+		logger = logger.With("user_id", principal.UserID.String())
+	}
+	return logger
+}
+
+// AccessLog writes one line per request once it has been handled. It should
+// run right after RequestID. The query string is left out because it can
+// carry signatures and websocket credentials.
+func AccessLog() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+
+		status := c.Writer.Status()
+		level := slog.LevelInfo
+		switch {
+		case status >= 500:
+			level = slog.LevelError
+		case status >= 400:
+			level = slog.LevelWarn
+		}
+
+		attrs := []any{
+			"method", c.Request.Method,
+			"route", c.FullPath(),
+			"path", c.Request.URL.Path,
+			"status", status,
+			"latency_ms", float64(time.Since(start).Microseconds()) / 1000,
+			"bytes", c.Writer.Size(),
+			"client_ip", c.ClientIP(),
+		}
+		if len(c.Errors) > 0 {
+			attrs = append(attrs, "errors", c.Errors.String())
+		}
+		Logger(c).Log(c.Request.Context(), level, "request", attrs...)
+	}
+}
+
+// Recovery turns a panic into a 500 and logs it, with its stack, as JSON
+// rather than gin's plain-text dump.
+func Recovery() gin.HandlerFunc {
+	return gin.CustomRecoveryWithWriter(io.Discard, func(c *gin.Context, err any) {
+		Logger(c).Error("Panic recovered", "panic", err, "stack", string(debug.Stack()))
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+	})
+}
@@ -0,0 +1,37 @@
+// internal/middleware/metricsMiddleware.go
+
+package middleware
+
+import (
+	"net/http"
+	"time"
+
+	"NoteApi/internal/metrics"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Metrics records each request's count and latency by route and status.
+// Requests that match no route share the "unmatched" label.
+func Metrics() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+
+		route := c.FullPath()
+		if route == "" {
+			route = "unmatched"
+		}
+		if c.IsWebsocket() {
+			// Upgraded connections last as long as the client stays, so
+			// their duration says nothing about handler latency
+			status := c.Writer.Status()
+			if status == http.StatusOK {
+				status = http.StatusSwitchingProtocols
+			}
+			metrics.CountRequest(c.Request.Method, route, status)
+			return
+		}
+		metrics.ObserveRequest(c.Request.Method, route, c.Writer.Status(), time.Since(start))
+	}
+}
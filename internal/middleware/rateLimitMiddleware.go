@@ -0,0 +1,56 @@
+// internal/middleware/rateLimitMiddleware.go
+
+package middleware
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"NoteApi/internal/auth"
+	"NoteApi/internal/ratelimit"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RateLimit throttles requests in a route group with a token bucket. Requests
+// are keyed by the authenticated user when a principal is present (so it
+// should run after CheckAuthenticated) and by client IP otherwise. Responses
+// carry RateLimit-* headers; rejected ones get 429 and Retry-After. If the
+// store fails the request is let through rather than taking the API down.
+func RateLimit(store ratelimit.Store, group string, limit ratelimit.Limit) gin.HandlerFunc {
+	policy := strconv.Itoa(limit.Requests) + ";w=" + strconv.Itoa(int(limit.Period.Seconds()))
+
+	return func(c *gin.Context) {
+		key := group + ":ip:" + c.ClientIP()
+		if principal, ok := auth.FromContext(c); ok {
+			key = group + ":user:" + principal.UserID.String()
+		}
+
+		result, err := store.Take(key, limit, time.Now())
+		if err != nil {
+			Logger(c).Error("Rate limit store failed", "group", group, "error", err)
+			c.Next()
+			return
+		}
+
+		c.Header("RateLimit-Policy", policy)
+		c.Header("RateLimit-Limit", strconv.Itoa(limit.Requests))
+		c.Header("RateLimit-Remaining", strconv.Itoa(result.Remaining))
+		c.Header("RateLimit-Reset", strconv.Itoa(ceilSeconds(result.Reset)))
+
+		if !result.Allowed {
+			c.Header("Retry-After", strconv.Itoa(ceilSeconds(result.RetryAfter)))
+			c.JSON(http.StatusTooManyRequests, gin.H{"error": "Rate limit exceeded"})
+			c.Abort()
+			return
+		}
+
+		c.Next()
+	}
+}
+
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
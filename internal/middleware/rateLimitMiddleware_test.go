@@ -0,0 +1,73 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"NoteApi/internal/auth"
+	"NoteApi/internal/ratelimit"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+func TestRateLimitHeaders(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	limit := ratelimit.Limit{Requests: 2, Period: time.Minute}
+	userID := uuid.New()
+
+	r := gin.New()
+	r.GET("/ip", RateLimit(ratelimit.NewMemoryStore(), "notes", limit), func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+	r.GET("/user", func(c *gin.Context) {
+		auth.SetPrincipal(c, auth.Principal{UserID: userID})
+	}, RateLimit(ratelimit.NewMemoryStore(), "notes", limit), func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+
+	get := func(path, addr string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.RemoteAddr = addr
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	tests := []struct {
+		path, addr   string
+		code         int
+		remaining    string
+		reset, retry string
+	}{
+		{"/ip", "192.0.2.1:1000", http.StatusOK, "1", "30", ""},
+		{"/ip", "192.0.2.1:1001", http.StatusOK, "0", "60", ""},
+		{"/ip", "192.0.2.1:1002", http.StatusTooManyRequests, "0", "60", "30"},
+		// Another client has its own bucket
+		{"/ip", "192.0.2.2:1000", http.StatusOK, "1", "30", ""},
+		// Authenticated requests are keyed by user, whatever their address
+		{"/user", "192.0.2.3:1000", http.StatusOK, "1", "30", ""},
+		{"/user", "192.0.2.4:1000", http.StatusOK, "0", "60", ""},
+		{"/user", "192.0.2.5:1000", http.StatusTooManyRequests, "0", "60", "30"},
+	}
+	for i, tt := range tests {
+		w := get(tt.path, tt.addr)
+		if w.Code != tt.code {
+			t.Fatalf("request %d: status = %d, want %d", i, w.Code, tt.code)
+		}
+		want := map[string]string{
+			"RateLimit-Policy":    "2;w=60",
+			"RateLimit-Limit":     "2",
+			"RateLimit-Remaining": tt.remaining,
+			"RateLimit-Reset":     tt.reset,
+			"Retry-After":         tt.retry,
+		}
+		for header, value := range want {
+			if got := w.Header().Get(header); got != value {
+				t.Errorf("request %d: %s = %q, want %q", i, header, got, value)
+			}
+		}
+	}
+}
@@ -0,0 +1,69 @@
+// internal/middleware/requestIDMiddleware.go
+
+package middleware
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+const (
+	RequestIDHeader = "X-Request-ID"
+	RequestIDKey    = "request_id"
+)
+
+// requestIDPattern bounds what we accept from clients so the ID is safe to
+// log and echo back.
+var requestIDPattern = regexp.MustCompile(`^[A-Za-z0-9._:-]{1,128}$`)
+
+// RequestID reuses a well-formed X-Request-ID from the client or generates
+// one, stores it on the context and echoes it in the response. JSON error
+// responses also carry it as "request_id", so a reported error can be matched
+// to its log lines.
+func RequestID() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		requestID := c.GetHeader(RequestIDHeader)
+		if !requestIDPattern.MatchString(requestID) {
+			requestID = uuid.New().String()
+		}
+
+		c.Set(RequestIDKey, requestID)
+		c.Header(RequestIDHeader, requestID)
+		c.Writer = &requestIDWriter{ResponseWriter: c.Writer, requestID: requestID}
+		c.Next()
+	}
+}
+
+// requestIDWriter adds "request_id" to the JSON object of an error response.
+// Handlers render gin.H in a single Write, so only the first one is touched.
+type requestIDWriter struct {
+	gin.ResponseWriter
+	requestID string
+	written   bool
+}
+
+func (w *requestIDWriter) Write(data []byte) (int, error) {
+	if w.written || w.Status() < 400 || len(data) < 2 || data[0] != '{' ||
+		!strings.HasPrefix(w.Header().Get("Content-Type"), "application/json") {
+		w.written = true
+		return w.ResponseWriter.Write(data)
+	}
+	w.written = true
+
+	// requestIDPattern guarantees the ID needs no JSON escaping
+	field := `"request_id":"` + w.requestID + `"`
+	if data[1] != '}' {
+		field += ","
+	}
+	body := make([]byte, 0, len(data)+len(field))
+	body = append(body, '{')
+	body = append(body, field...)
+	body = append(body, data[1:]...)
+	if _, err := w.ResponseWriter.Write(body); err != nil {
+		return 0, err
+	}
+	return len(data), nil
+}
@@ -0,0 +1,31 @@
+// APIToken.go
+package models
+
+import (
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"time"
+)
+
+// APIToken is a personal access token for scripts and integrations. Only the
+// SHA-256 of the token is stored; the plaintext is shown once at creation.
+type APIToken struct {
+	ID         uuid.UUID  `gorm:"type:uuid;primary_key" json:"id"`
+	UserID     uuid.UUID  `gorm:"type:uuid;index"       json:"user_id"`
+	Name       string     `                             json:"name"`
+	Prefix     string     `                             json:"prefix"`
+	TokenHash  string     `gorm:"size:64;uniqueIndex"   json:"-"`
+	Scopes     string     `                             json:"-"`
+	ExpiresAt  *time.Time `                             json:"expires_at"`
+	LastUsedAt *time.Time `                             json:"last_used_at"`
+	RevokedAt  *time.Time `                             json:"revoked_at,omitempty"`
+	CreatedAt  time.Time  `gorm:"autoCreateTime"        json:"created_at"`
+}
+
+// BeforeCreate will set a UUID rather than numeric ID.
+func (t *APIToken) BeforeCreate(tx *gorm.DB) error {
+	if t.ID == uuid.Nil {
+		t.ID = uuid.New()
+	}
+	return nil
+}
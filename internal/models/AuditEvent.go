@@ -0,0 +1,45 @@
+// AuditEvent.go
+package models
+
+import (
+	"errors"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"time"
+)
+
+var ErrAuditEventImmutable = errors.New("audit events are append-only")
+
+// AuditEvent records who did what to which note or upload. Changes holds a
+// JSON object mapping field names to {"before": ..., "after": ...} summaries.
+// Events are only ever inserted.
+type AuditEvent struct {
+	ID         uuid.UUID `gorm:"type:uuid;primary_key"                 json:"id"`
+	ActorID    uuid.UUID `gorm:"type:uuid;index:idx_audit_actor_time"  json:"actor_id"`
+	Action     string    `gorm:"index"                                 json:"action"`
+	TargetType string    `                                             json:"target_type"`
+	TargetID   string    `gorm:"index"                                 json:"target_id"`
+	RequestID  string    `                                             json:"request_id"`
+	ClientIP   string    `                                             json:"client_ip"`
+	UserAgent  string    `                                             json:"user_agent"`
+	Changes    string    `gorm:"type:text"                             json:"-"`
+	CreatedAt  time.Time `gorm:"index:idx_audit_actor_time"            json:"created_at"`
+}
+
+// BeforeCreate will set a UUID rather than numeric ID.
+func (e *AuditEvent) BeforeCreate(tx *gorm.DB) error {
+	if e.ID == uuid.Nil {
+		e.ID = uuid.New()
+	}
+	return nil
+}
+
+// BeforeUpdate refuses to modify a recorded event.
+func (e *AuditEvent) BeforeUpdate(tx *gorm.DB) error {
+	return ErrAuditEventImmutable
+}
+
+// BeforeDelete refuses to remove a recorded event.
+func (e *AuditEvent) BeforeDelete(tx *gorm.DB) error {
+	return ErrAuditEventImmutable
+}
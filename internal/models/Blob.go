@@ -0,0 +1,19 @@
+// Blob.go
+package models
+
+import "time"
+
+// Blob is a stored file addressed by the SHA-256 digest of its content.
+// RefCount tracks how many Upload rows point at it; the file is removed when
+// the last reference is released. ContentType is sniffed from the content and
+// the optional metadata fields are extracted once when the blob is stored.
+type Blob struct {
+	Digest          string    `gorm:"primaryKey;size:64" json:"sha256"`
+	Size            int64     `                          json:"size"`
+	ContentType     string    `                          json:"content_type"`
+	RefCount        int64     `gorm:"not null;default:0" json:"ref_count"`
+	PageCount       *int      `                          json:"page_count,omitempty"`
+	DurationSeconds *float64  `                          json:"duration_seconds,omitempty"`
+	RowCount        *int      `                          json:"row_count,omitempty"`
+	CreatedAt       time.Time `gorm:"autoCreateTime"     json:"created_at"`
+}
@@ -0,0 +1,17 @@
+// DataKey.go
+package models
+
+import (
+	"github.com/google/uuid"
+	"time"
+)
+
+// DataKey is a user's note encryption key, stored encrypted ("wrapped") with
+// the master key named by MasterKeyID.
+type DataKey struct {
+	UserID      uuid.UUID `gorm:"type:uuid;primary_key"`
+	MasterKeyID string    `gorm:"index"`
+	WrappedKey  string    `gorm:"type:text"`
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+}
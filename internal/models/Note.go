@@ -7,17 +7,37 @@ import (
 	"time"
 )
 
+// Note belongs to UserID, or to WorkspaceID when that is set, in which case
+// UserID is the member who created it. Size is the plaintext size of the title
+// and content in bytes, kept so quotas can be summed without decrypting every
+// note.
 type Note struct {
-	ID            uuid.UUID `gorm:"type:uuid;primary_key;default:gen_random_uuid()" json:"ID"`
-	Title         string    `                                                       json:"title"`
-	DashboardPath string    `                                                       json:"dashboard_path"`
-	Content       string    `                                                       json:"content"`
-	CreatedAt     time.Time `gorm:"autoCreateTime"                                  json:"created_at"`
-	LastChanged   time.Time `gorm:"autoUpdateTime"                                  json:"last_changed"`
-	LastRemove    time.Time `                                                       json:"last_removed"`
-	UserID        uuid.UUID `gorm:"type:uuid"                                       json:"user_id"`
+	ID            uuid.UUID  `gorm:"type:uuid;primary_key" json:"ID"`
+	Title         string     `                                                       json:"title"`
+	DashboardPath string     `                                                       json:"dashboard_path"`
+	Content       string     `                                                       json:"content"`
+	CreatedAt     time.Time  `gorm:"autoCreateTime"                                  json:"created_at"`
+	LastChanged   time.Time  `gorm:"autoUpdateTime"                                  json:"last_changed"`
+	LastRemove    time.Time  `                                                       json:"last_removed"`
+	UserID        uuid.UUID  `gorm:"type:uuid"                                       json:"user_id"`
+	WorkspaceID   *uuid.UUID `gorm:"type:uuid;index"                                 json:"workspace_id"`
+	Size          int64      `                                                       json:"size"`
+
+	// Plaintext copies kept while the encrypted fields are being written
+	plainTitle   string
+	plainContent string
+}
+
+// FieldCipher encrypts note fields at rest. NoteCipher is nil unless
+// encryption is configured, in which case titles and contents are sealed
+// before every save and opened after every read.
+type FieldCipher interface {
+	Seal(userID, noteID uuid.UUID, field, plaintext string) (string, error)
+	Open(userID, noteID uuid.UUID, field, value string) (string, error)
 }
 
+var NoteCipher FieldCipher
+
 // BeforeCreate will set a UUID rather than numeric ID.
 func (n *Note) BeforeCreate(tx *gorm.DB) error {
 	if n.ID == uuid.Nil {
@@ -26,12 +46,87 @@ func (n *Note) BeforeCreate(tx *gorm.DB) error {
 	return nil
 }
 
+// PlainSize returns the size of the plaintext title and content in bytes, as
+// charged against the storage quota.
+func (n *Note) PlainSize() int64 {
+	return int64(len(n.Title) + len(n.Content))
+}
+
+// BeforeSave records the note's size and encrypts the title and content when
+// a NoteCipher is set.
+func (n *Note) BeforeSave(tx *gorm.DB) error {
+	n.Size = n.PlainSize()
+	if NoteCipher == nil {
+		return nil
+	}
+	// BeforeSave runs before BeforeCreate, and the ID is part of the
+	// ciphertext's additional data
+	if n.ID == uuid.Nil {
+		n.ID = uuid.New()
+	}
+
+	title, err := NoteCipher.Seal(n.UserID, n.ID, "title", n.Title)
+	if err != nil {
+		return err
+	}
+	content, err := NoteCipher.Seal(n.UserID, n.ID, "content", n.Content)
+	if err != nil {
+		return err
+	}
+	n.plainTitle, n.plainContent = n.Title, n.Content
+	n.Title, n.Content = title, content
+	return nil
+}
+
+// AfterSave puts the plaintext back so callers never see ciphertext.
+func (n *Note) AfterSave(tx *gorm.DB) error {
+	if NoteCipher == nil {
+		return nil
+	}
+	n.Title, n.Content = n.plainTitle, n.plainContent
+	return nil
+}
+
+// AfterFind decrypts the title and content when a NoteCipher is set. Queries
+// that select encrypted fields must also select id and user_id.
+func (n *Note) AfterFind(tx *gorm.DB) error {
+	if NoteCipher == nil {
+		return nil
+	}
+	title, err := NoteCipher.Open(n.UserID, n.ID, "title", n.Title)
+	if err != nil {
+		return err
+	}
+	content, err := NoteCipher.Open(n.UserID, n.ID, "content", n.Content)
+	if err != nil {
+		return err
+	}
+	n.Title, n.Content = title, content
+	return nil
+}
+
 // BeforeUpdate will update the LastChanged time
 func (n *Note) BeforeUpdate(tx *gorm.DB) error {
 	n.LastChanged = time.Now()
 	return nil
 }
 
+// InTrash reports whether the note has been moved to the trash.
+func (n *Note) InTrash() bool {
+	return !n.LastRemove.IsZero()
+}
+
+// NotTrashed is a query scope selecting notes outside the trash, whose
+// last_remove is zero or, for rows older than soft delete, NULL.
+func NotTrashed(db *gorm.DB) *gorm.DB {
+	return db.Where("(notes.last_remove IS NULL OR notes.last_remove <= ?)", time.Time{})
+}
+
+// Trashed is a query scope selecting notes in the trash.
+func Trashed(db *gorm.DB) *gorm.DB {
+	return db.Where("notes.last_remove > ?", time.Time{})
+}
+
 // SoftDelete updates the LastRemove time instead of deleting the record
 func (n *Note) SoftDelete(tx *gorm.DB) error {
 	n.LastRemove = time.Now()
@@ -0,0 +1,13 @@
+// RateLimitBucket.go
+package models
+
+import "time"
+
+// RateLimitBucket is the shared token bucket state used by the Postgres rate
+// limit store.
+type RateLimitBucket struct {
+	Key       string    `gorm:"primaryKey"`
+	Tokens    float64   `gorm:"not null"`
+	Allowed   bool      `gorm:"not null"`
+	UpdatedAt time.Time `gorm:"not null;index"`
+}
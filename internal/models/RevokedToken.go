@@ -0,0 +1,16 @@
+// RevokedToken.go
+package models
+
+import (
+	"github.com/google/uuid"
+	"time"
+)
+
+// RevokedToken denies a single JWT by its jti until the token would have
+// expired anyway, after which the row can be pruned.
+type RevokedToken struct {
+	JTI       string    `gorm:"primary_key"`
+	UserID    uuid.UUID `gorm:"type:uuid;index"`
+	ExpiresAt time.Time `gorm:"index"`
+	CreatedAt time.Time
+}
@@ -0,0 +1,45 @@
+// TusUpload.go
+package models
+
+import (
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"time"
+)
+
+const (
+	TusTargetDashboard  = "dashboard"
+	TusTargetAttachment = "attachment"
+)
+
+// TusUpload tracks a resumable upload. The partial content lives in the tus
+// staging directory until Offset reaches Length; Path is set once the content
+// has been stored and attached to the note. An unfinished upload can no longer
+// be resumed after ExpiresAt, which is zero for uploads that never expire.
+type TusUpload struct {
+	ID        uuid.UUID `gorm:"type:uuid;primary_key" json:"id"`
+	UserID    uuid.UUID `gorm:"type:uuid;index"       json:"user_id"`
+	NoteID    uuid.UUID `gorm:"type:uuid"             json:"note_id"`
+	Target    string    `                             json:"target"`
+	Filename  string    `                             json:"filename"`
+	FileType  string    `                             json:"filetype"`
+	Metadata  string    `                             json:"metadata"`
+	Length    int64     `                             json:"length"`
+	Offset    int64     `gorm:"column:upload_offset"  json:"offset"`
+	Path      string    `                             json:"path,omitempty"`
+	CreatedAt time.Time `gorm:"autoCreateTime"        json:"created_at"`
+	ExpiresAt time.Time `                             json:"expires_at"`
+}
+
+// Expired reports whether the upload was left unfinished past its expiry.
+func (t *TusUpload) Expired(now time.Time) bool {
+	return t.Path == "" && !t.ExpiresAt.IsZero() && !now.Before(t.ExpiresAt)
+}
+
+// BeforeCreate will set a UUID rather than numeric ID.
+func (t *TusUpload) BeforeCreate(tx *gorm.DB) error {
+	if t.ID == uuid.Nil {
+		t.ID = uuid.New()
+	}
+	return nil
+}
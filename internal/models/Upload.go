@@ -0,0 +1,32 @@
+// Upload.go
+package models
+
+import (
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"time"
+)
+
+// Upload records who owns a file stored in the uploads directory so the
+// download handler can authorize access to it. Path is the public name of the
+// upload; when Digest is set the content lives in the matching Blob. NoteID is
+// set when the upload is attached to a note.
+type Upload struct {
+	ID          uuid.UUID  `gorm:"type:uuid;primary_key"   json:"id"`
+	UserID      uuid.UUID  `gorm:"type:uuid;index"         json:"user_id"`
+	NoteID      *uuid.UUID `gorm:"type:uuid;index"         json:"note_id,omitempty"`
+	Path        string     `gorm:"uniqueIndex"             json:"path"`
+	Filename    string     `                               json:"filename"`
+	Digest      string     `gorm:"size:64;index"           json:"sha256,omitempty"`
+	ContentType string     `                               json:"content_type"`
+	Size        int64      `                               json:"size"`
+	CreatedAt   time.Time  `gorm:"autoCreateTime"          json:"created_at"`
+}
+
+// BeforeCreate will set a UUID rather than numeric ID.
+func (u *Upload) BeforeCreate(tx *gorm.DB) error {
+	if u.ID == uuid.Nil {
+		u.ID = uuid.New()
+	}
+	return nil
+}
@@ -0,0 +1,14 @@
+// UserRevocation.go
+package models
+
+import (
+	"github.com/google/uuid"
+	"time"
+)
+
+// UserRevocation denies every token of a user issued before RevokedBefore.
+type UserRevocation struct {
+	UserID        uuid.UUID `gorm:"type:uuid;primary_key"`
+	RevokedBefore time.Time
+	UpdatedAt     time.Time
+}
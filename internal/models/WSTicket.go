@@ -0,0 +1,23 @@
+// WSTicket.go
+package models
+
+import (
+	"github.com/google/uuid"
+	"time"
+)
+
+// WSTicket is a short-lived, single-use credential for a websocket upgrade.
+// It carries the principal of the request that issued it.
+type WSTicket struct {
+	TicketHash string    `gorm:"primary_key"`
+	UserID     uuid.UUID `gorm:"type:uuid"`
+	Scopes     string
+	TokenID    string
+	IssuedAt   time.Time
+	APITokenID *uuid.UUID `gorm:"type:uuid"`
+	// TokenExpiresAt is when the credential the ticket stands in for
+	// expires; nil when it does not.
+	TokenExpiresAt *time.Time
+	ExpiresAt      time.Time `gorm:"index"`
+	CreatedAt      time.Time
+}
@@ -0,0 +1,67 @@
+// Workspace.go
+package models
+
+import (
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"time"
+)
+
+// Workspace roles, from most to least privileged.
+const (
+	RoleOwner  = "owner"
+	RoleAdmin  = "admin"
+	RoleEditor = "editor"
+	RoleViewer = "viewer"
+)
+
+// Workspace is a team that owns notes jointly.
+type Workspace struct {
+	ID        uuid.UUID `gorm:"type:uuid;primary_key" json:"id"`
+	Name      string    `                             json:"name"`
+	CreatedBy uuid.UUID `gorm:"type:uuid"             json:"created_by"`
+	CreatedAt time.Time `                             json:"created_at"`
+	UpdatedAt time.Time `                             json:"updated_at"`
+}
+
+// BeforeCreate will set a UUID rather than numeric ID.
+func (w *Workspace) BeforeCreate(tx *gorm.DB) error {
+	if w.ID == uuid.Nil {
+		w.ID = uuid.New()
+	}
+	return nil
+}
+
+// WorkspaceMember grants UserID a role in a workspace.
+type WorkspaceMember struct {
+	WorkspaceID uuid.UUID `gorm:"type:uuid;primary_key"       json:"workspace_id"`
+	UserID      uuid.UUID `gorm:"type:uuid;primary_key;index" json:"user_id"`
+	Role        string    `                                   json:"role"`
+	CreatedAt   time.Time `                                   json:"created_at"`
+	UpdatedAt   time.Time `                                   json:"updated_at"`
+}
+
+// WorkspaceInvitation lets whoever presents its code join a workspace with
+// Role, or only InviteeID when set. Users live in the external auth service,
+// so invitations are handed out as codes rather than sent by email.
+type WorkspaceInvitation struct {
+	ID          uuid.UUID  `gorm:"type:uuid;primary_key"     json:"id"`
+	WorkspaceID uuid.UUID  `gorm:"type:uuid;index"           json:"workspace_id"`
+	Role        string     `                                 json:"role"`
+	CodeHash    string     `gorm:"uniqueIndex"               json:"-"`
+	InvitedBy   uuid.UUID  `gorm:"type:uuid"                 json:"invited_by"`
+	InviteeID   *uuid.UUID `gorm:"type:uuid"                 json:"invitee_id"`
+	ExpiresAt   time.Time  `                                 json:"expires_at"`
+	AcceptedBy  *uuid.UUID `gorm:"type:uuid"                 json:"accepted_by"`
+	AcceptedAt  *time.Time `                                 json:"accepted_at"`
+	RevokedAt   *time.Time `                                 json:"revoked_at,omitempty"`
+	CreatedAt   time.Time  `                                 json:"created_at"`
+}
+
+// BeforeCreate will set a UUID rather than numeric ID.
+func (i *WorkspaceInvitation) BeforeCreate(tx *gorm.DB) error {
+	if i.ID == uuid.Nil {
+		i.ID = uuid.New()
+	}
+	return nil
+}
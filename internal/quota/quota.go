@@ -0,0 +1,202 @@
+// internal/quota/quota.go
+package quota
+
+import (
+	"NoteApi/internal/models"
+	"fmt"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultMaxBytes = 1 << 30 // 1 GiB
+	DefaultMaxNotes = 0       // unlimited
+
+	// WarnRatio is the share of either limit at which users get a warning.
+	WarnRatio = 0.9
+)
+
+// Breakdown is the number of items in a usage category and the bytes they take.
+type Breakdown struct {
+	Count int64 `json:"count"`
+	Bytes int64 `json:"bytes"`
+}
+
+// Usage describes what a user currently stores against their limits. Pending
+// covers resumable uploads that have been created but not finished; their
+// full length is reserved so parallel uploads cannot overshoot the quota,
+// until they expire.
+type Usage struct {
+	Notes       Breakdown `json:"notes"`
+	Attachments Breakdown `json:"attachments"`
+	Trash       Breakdown `json:"trash"`
+	Pending     Breakdown `json:"pending_uploads"`
+	TotalBytes  int64     `json:"total_bytes"`
+	NoteCount   int64     `json:"note_count"`
+	MaxBytes    int64     `json:"max_bytes"`
+	MaxNotes    int64     `json:"max_notes"`
+}
+
+// Ratio returns the larger of the byte and note usage ratios. Unlimited
+// quotas count as zero.
+func (u Usage) Ratio() float64 {
+	var ratio float64
+	if u.MaxBytes > 0 {
+		ratio = float64(u.TotalBytes) / float64(u.MaxBytes)
+	}
+	if u.MaxNotes > 0 {
+		if notes := float64(u.NoteCount) / float64(u.MaxNotes); notes > ratio {
+			ratio = notes
+		}
+	}
+	return ratio
+}
+
+// ExceededError is returned by Check when a write would go over a limit.
+type ExceededError struct {
+	Limit     string
+	Used      int64
+	Requested int64
+	Max       int64
+}
+
+func (e *ExceededError) Error() string {
+	return fmt.Sprintf("%s quota exceeded: %d used, %d requested, %d allowed", e.Limit, e.Used, e.Requested, e.Max)
+}
+
+// The limits applied to every user; set from config.QuotaConfig by SetLimits.
+var (
+	maxBytes int64 = DefaultMaxBytes
+	maxNotes int64 = DefaultMaxNotes
+)
+
+// SetLimits sets the byte and note limits. Zero disables a limit.
+func SetLimits(bytes, notes int64) {
+	maxBytes, maxNotes = bytes, notes
+}
+
+// MaxBytes returns the byte limit; zero means unlimited.
+func MaxBytes() int64 {
+	return maxBytes
+}
+
+// MaxNotes returns the note limit; zero means unlimited.
+func MaxNotes() int64 {
+	return maxNotes
+}
+
+// ForUser computes the current usage for userID.
+func ForUser(db *gorm.DB, userID uuid.UUID) (Usage, error) {
+	usage := Usage{MaxBytes: MaxBytes(), MaxNotes: MaxNotes()}
+
+	err := db.Model(&models.Note{}).
+		Select("COUNT(*) AS count, COALESCE(SUM(size), 0) AS bytes").
+		Scopes(models.NotTrashed).
+		Where("user_id = ?", userID).
+		Scan(&usage.Notes).Error
+	if err != nil {
+		return Usage{}, err
+	}
+
+	err = db.Model(&models.Note{}).
+		Select("COUNT(*) AS count, COALESCE(SUM(size), 0) AS bytes").
+		Scopes(models.Trashed).
+		Where("user_id = ?", userID).
+		Scan(&usage.Trash).Error
+	if err != nil {
+		return Usage{}, err
+	}
+
+	// Files belonging to trashed notes count as trash, not attachments
+	trashedNotes := func(column string) *gorm.DB {
+		return db.Model(&models.Note{}).
+			Select(column).
+			Scopes(models.Trashed).
+			Where("user_id = ?", userID)
+	}
+	var trashedFiles Breakdown
+	err = db.Model(&models.Upload{}).
+		Select("COUNT(*) AS count, COALESCE(SUM(size), 0) AS bytes").
+		Where("user_id = ?", userID).
+		Where("note_id IN (?) OR path IN (?)", trashedNotes("id"), trashedNotes("dashboard_path")).
+		Scan(&trashedFiles).Error
+	if err != nil {
+		return Usage{}, err
+	}
+
+	var allFiles Breakdown
+	err = db.Model(&models.Upload{}).
+		Select("COUNT(*) AS count, COALESCE(SUM(size), 0) AS bytes").
+		Where("user_id = ?", userID).
+		Scan(&allFiles).Error
+	if err != nil {
+		return Usage{}, err
+	}
+
+	err = db.Model(&models.TusUpload{}).
+		Select("COUNT(*) AS count, COALESCE(SUM(length), 0) AS bytes").
+		Where("user_id = ? AND path = ''", userID).
+		Where("expires_at IS NULL OR expires_at > ?", time.Now()).
+		Scan(&usage.Pending).Error
+	if err != nil {
+		return Usage{}, err
+	}
+
+	usage.Attachments = Breakdown{
+		Count: allFiles.Count - trashedFiles.Count,
+		Bytes: allFiles.Bytes - trashedFiles.Bytes,
+	}
+	usage.NoteCount = usage.Notes.Count + usage.Trash.Count
+	usage.Trash.Count += trashedFiles.Count
+	usage.Trash.Bytes += trashedFiles.Bytes
+	usage.TotalBytes = usage.Notes.Bytes + usage.Attachments.Bytes + usage.Trash.Bytes + usage.Pending.Bytes
+
+	return usage, nil
+}
+
+// Check returns an *ExceededError if storing extraBytes more and creating
+// extraNotes more notes would put userID over a limit.
+func Check(db *gorm.DB, userID uuid.UUID, extraBytes, extraNotes int64) error {
+	usage, err := ForUser(db, userID)
+	if err != nil {
+		return err
+	}
+	return usage.Check(extraBytes, extraNotes)
+}
+
+// Check returns an *ExceededError if extraBytes more and extraNotes more
+// notes would not fit in u.
+func (u Usage) Check(extraBytes, extraNotes int64) error {
+	if u.MaxBytes > 0 && extraBytes > 0 && u.TotalBytes+extraBytes > u.MaxBytes {
+		return &ExceededError{Limit: "storage", Used: u.TotalBytes, Requested: extraBytes, Max: u.MaxBytes}
+	}
+	if u.MaxNotes > 0 && extraNotes > 0 && u.NoteCount+extraNotes > u.MaxNotes {
+		return &ExceededError{Limit: "note", Used: u.NoteCount, Requested: extraNotes, Max: u.MaxNotes}
+	}
+	return nil
+}
+
+var (
+	warned   = make(map[uuid.UUID]bool)
+	warnedMu sync.Mutex
+)
+
+// CrossedWarning reports whether usage has just passed WarnRatio for userID,
+// so callers warn once per crossing rather than on every write.
+func CrossedWarning(userID uuid.UUID, usage Usage) bool {
+	warnedMu.Lock()
+	defer warnedMu.Unlock()
+
+	above := usage.Ratio() >= WarnRatio
+	if !above {
+		delete(warned, userID)
+		return false
+	}
+	if warned[userID] {
+		return false
+	}
+	warned[userID] = true
+	return true
+}
@@ -0,0 +1,87 @@
+// internal/ratelimit/ratelimit.go
+package ratelimit
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Limit is a token bucket: Requests tokens are refilled evenly over Period and
+// the bucket holds at most Requests tokens, which is also the burst size.
+type Limit struct {
+	Requests int
+	Period   time.Duration
+}
+
+// Rate returns the refill rate in tokens per second.
+func (l Limit) Rate() float64 {
+	return float64(l.Requests) / l.Period.Seconds()
+}
+
+// String formats the limit the way ParseLimit reads it.
+func (l Limit) String() string {
+	return fmt.Sprintf("%d/%s", l.Requests, l.Period)
+}
+
+// ParseLimit reads "<requests>/<period>", e.g. "120/1m" or "10/1s".
+func ParseLimit(value string) (Limit, error) {
+	requests, period, ok := strings.Cut(strings.TrimSpace(value), "/")
+	if !ok {
+		return Limit{}, fmt.Errorf("rate limit %q: expected <requests>/<period>", value)
+	}
+	n, err := strconv.Atoi(requests)
+	if err != nil || n <= 0 {
+		return Limit{}, fmt.Errorf("rate limit %q: invalid request count", value)
+	}
+	d, err := time.ParseDuration(period)
+	if err != nil || d <= 0 {
+		return Limit{}, fmt.Errorf("rate limit %q: invalid period", value)
+	}
+	return Limit{Requests: n, Period: d}, nil
+}
+
+// Result describes the outcome of taking a token.
+type Result struct {
+	Allowed bool
+	// Remaining is the number of whole tokens left after this request.
+	Remaining int
+	// RetryAfter is how long until the next token is available; zero when
+	// Allowed.
+	RetryAfter time.Duration
+	// Reset is how long until the bucket is full again.
+	Reset time.Duration
+}
+
+// Store keeps bucket state. Implementations must make Take atomic per key so
+// concurrent requests cannot overspend a bucket.
+type Store interface {
+	Take(key string, limit Limit, now time.Time) (Result, error)
+}
+
+// result derives the response fields from a bucket's token count after the
+// request has been accounted for.
+func result(allowed bool, tokens float64, limit Limit) Result {
+	rate := limit.Rate()
+	res := Result{
+		Allowed:   allowed,
+		Remaining: int(math.Floor(tokens)),
+		Reset:     time.Duration((float64(limit.Requests) - tokens) / rate * float64(time.Second)),
+	}
+	if !allowed {
+		res.RetryAfter = time.Duration((1 - tokens) / rate * float64(time.Second))
+	}
+	return res
+}
+
+// refill returns the tokens in a bucket that held tokens at last, capped at
+// the bucket size.
+func refill(tokens float64, last, now time.Time, limit Limit) float64 {
+	elapsed := now.Sub(last).Seconds()
+	if elapsed < 0 {
+		elapsed = 0
+	}
+	return math.Min(float64(limit.Requests), tokens+elapsed*limit.Rate())
+}
@@ -0,0 +1,97 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseLimit(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    Limit
+		wantErr bool
+	}{
+		{"120/1m", Limit{Requests: 120, Period: time.Minute}, false},
+		{" 10/1s ", Limit{Requests: 10, Period: time.Second}, false},
+		{"1000/24h", Limit{Requests: 1000, Period: 24 * time.Hour}, false},
+		{"120", Limit{}, true},
+		{"0/1m", Limit{}, true},
+		{"-5/1m", Limit{}, true},
+		{"many/1m", Limit{}, true},
+		{"10/0s", Limit{}, true},
+		{"10/1d", Limit{}, true},
+		{"", Limit{}, true},
+	}
+	for _, tt := range tests {
+		got, err := ParseLimit(tt.value)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseLimit(%q) error = %v, want error %v", tt.value, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("ParseLimit(%q) = %v, want %v", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestMemoryStoreTake(t *testing.T) {
+	store := NewMemoryStore()
+	limit := Limit{Requests: 3, Period: 3 * time.Second}
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	// A new bucket starts full and allows a burst of Requests
+	for i := 2; i >= 0; i-- {
+		res, err := store.Take("k", limit, start)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !res.Allowed || res.Remaining != i || res.RetryAfter != 0 {
+			t.Fatalf("take %d = %+v", 3-i, res)
+		}
+	}
+	res, _ := store.Take("k", limit, start)
+	if res.Allowed || res.Remaining != 0 || res.RetryAfter != time.Second || res.Reset != 3*time.Second {
+		t.Fatalf("take over the burst = %+v", res)
+	}
+
+	// One token refills per second
+	res, _ = store.Take("k", limit, start.Add(1500*time.Millisecond))
+	if !res.Allowed || res.Remaining != 0 || res.Reset != 2500*time.Millisecond {
+		t.Fatalf("take after 1.5s = %+v", res)
+	}
+	res, _ = store.Take("k", limit, start.Add(1600*time.Millisecond))
+	if res.Allowed || res.RetryAfter != 400*time.Millisecond {
+		t.Fatalf("take after 1.6s = %+v", res)
+	}
+
+	// Buckets never hold more than Requests tokens
+	res, _ = store.Take("k", limit, start.Add(time.Hour))
+	if !res.Allowed || res.Remaining != 2 {
+		t.Fatalf("take after an hour = %+v", res)
+	}
+
+	// Keys do not share buckets
+	if res, _ := store.Take("other", limit, start); !res.Allowed || res.Remaining != 2 {
+		t.Fatalf("take on another key = %+v", res)
+	}
+}
+
+func TestMemoryStoreKeepsRefillingBuckets(t *testing.T) {
+	store := NewMemoryStore()
+	daily := Limit{Requests: 2, Period: 24 * time.Hour}
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	store.Take("daily", daily, start)
+	store.Take("daily", daily, start)
+	store.Take("brief", Limit{Requests: 1, Period: time.Second}, start)
+
+	// Two hours later the daily bucket is still nearly empty and must not be
+	// evicted, while the brief one has refilled and can go
+	res, _ := store.Take("daily", daily, start.Add(2*time.Hour))
+	if res.Allowed {
+		t.Fatalf("daily limit reset after two hours: %+v", res)
+	}
+	if _, ok := store.buckets["brief"]; ok {
+		t.Fatal("refilled bucket was not evicted")
+	}
+}
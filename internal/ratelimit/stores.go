@@ -0,0 +1,155 @@
+// internal/ratelimit/stores.go
+package ratelimit
+
+import (
+	"NoteApi/internal/models"
+	"context"
+	"fmt"
+	"gorm.io/gorm"
+	"log"
+	"sync"
+	"time"
+)
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+	// full is when the bucket will have refilled completely; from then on
+	// it is no different from a new one.
+	full time.Time
+}
+
+// MemoryStore keeps buckets in process memory. It is only correct for a
+// single instance; use PostgresStore when running several.
+type MemoryStore struct {
+	mu      sync.Mutex
+	buckets map[string]*bucket
+	sweep   time.Time
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{buckets: make(map[string]*bucket)}
+}
+
+func (s *MemoryStore) Take(key string, limit Limit, now time.Time) (Result, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.evictIdle(now)
+
+	b, ok := s.buckets[key]
+	if !ok {
+		b = &bucket{tokens: float64(limit.Requests), last: now}
+		s.buckets[key] = b
+	}
+
+	b.tokens = refill(b.tokens, b.last, now, limit)
+	b.last = now
+	allowed := b.tokens >= 1
+	if allowed {
+		b.tokens--
+	}
+	res := result(allowed, b.tokens, limit)
+	b.full = now.Add(res.Reset)
+	return res, nil
+}
+
+// evictIdle drops buckets that have refilled completely, at most once a
+// minute.
+func (s *MemoryStore) evictIdle(now time.Time) {
+	if now.Sub(s.sweep) < time.Minute {
+		return
+	}
+	s.sweep = now
+	for key, b := range s.buckets {
+		if !now.Before(b.full) {
+			delete(s.buckets, key)
+		}
+	}
+}
+
+// PostgresStore keeps buckets in the rate_limit_buckets table so every
+// instance shares them. Each Take is a single atomic upsert.
+type PostgresStore struct {
+	db *gorm.DB
+}
+
+func NewPostgresStore(db *gorm.DB) *PostgresStore {
+	return &PostgresStore{db: db}
+}
+
+func (s *PostgresStore) Take(key string, limit Limit, now time.Time) (Result, error) {
+	var row struct {
+		Tokens  float64
+		Allowed bool
+	}
+
+	// Refill from the stored state, then spend one token if there is one.
+	// A new bucket starts full, minus this request.
+	err := s.db.Raw(`
+		INSERT INTO rate_limit_buckets (key, tokens, allowed, updated_at)
+		VALUES (@key, @capacity - 1, TRUE, @now)
+		ON CONFLICT (key) DO UPDATE SET
+			allowed = LEAST(@capacity, rate_limit_buckets.tokens
+				+ GREATEST(EXTRACT(EPOCH FROM (EXCLUDED.updated_at - rate_limit_buckets.updated_at)), 0) * @rate) >= 1,
+			tokens = LEAST(@capacity, rate_limit_buckets.tokens
+				+ GREATEST(EXTRACT(EPOCH FROM (EXCLUDED.updated_at - rate_limit_buckets.updated_at)), 0) * @rate)
+				- CASE WHEN LEAST(@capacity, rate_limit_buckets.tokens
+					+ GREATEST(EXTRACT(EPOCH FROM (EXCLUDED.updated_at - rate_limit_buckets.updated_at)), 0) * @rate) >= 1
+					THEN 1 ELSE 0 END,
+			updated_at = EXCLUDED.updated_at
+		RETURNING tokens, allowed`,
+		map[string]interface{}{
+			"key":      key,
+			"capacity": float64(limit.Requests),
+			"rate":     limit.Rate(),
+			"now":      now,
+		},
+	).Scan(&row).Error
+	if err != nil {
+		return Result{}, err
+	}
+
+	return result(row.Allowed, row.Tokens, limit), nil
+}
+
+// PruneIdle deletes buckets untouched for longer than idle.
+func (s *PostgresStore) PruneIdle(idle time.Duration) error {
+	return s.db.Where("updated_at < ?", time.Now().Add(-idle)).Delete(&models.RateLimitBucket{}).Error
+}
+
+// PruneLoop calls PruneIdle every interval so the table only holds buckets
+// that are still refilling. The table does not record each bucket's limit,
+// so idle must be at least the longest period configured: a bucket that
+// has been idle that long has refilled under any limit. It returns when ctx
+// is cancelled.
+func (s *PostgresStore) PruneLoop(ctx context.Context, interval, idle time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := s.PruneIdle(idle); err != nil {
+				log.Printf("Failed to prune rate limit buckets: %v", err)
+			}
+		}
+	}
+}
+
+// NewStore returns the store named by config.RateLimitConfig's Store:
+// "memory" or "postgres".
+func NewStore(name string, db *gorm.DB) (Store, error) {
+	switch name {
+	case "memory":
+		return NewMemoryStore(), nil
+	case "postgres":
+		if db.Dialector.Name() != "postgres" {
+			return nil, fmt.Errorf("the postgres store needs a postgres database, not %s", db.Dialector.Name())
+		}
+		return NewPostgresStore(db), nil
+	default:
+		return nil, fmt.Errorf("unknown store %q", name)
+	}
+}
@@ -0,0 +1,459 @@
+// internal/repository/memory.go
+package repository
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"NoteApi/internal/audit"
+	"NoteApi/internal/models"
+	"NoteApi/internal/quota"
+	"NoteApi/internal/storage"
+	"NoteApi/internal/workspace"
+
+	"github.com/google/uuid"
+)
+
+// Memory is a NoteRepository kept in process memory, for handler tests that
+// should not need Postgres or a blob directory. It follows the same access
+// rules and error values as Postgres.
+type Memory struct {
+	mu       sync.Mutex
+	notes    map[uuid.UUID]models.Note
+	members  map[memberKey]string
+	uploads  map[string]models.Upload
+	blobs    map[string]models.Blob
+	contents map[string][]byte
+	events   []models.AuditEvent
+}
+
+type memberKey struct {
+	workspaceID uuid.UUID
+	userID      uuid.UUID
+}
+
+func NewMemory() *Memory {
+	return &Memory{
+		notes:    map[uuid.UUID]models.Note{},
+		members:  map[memberKey]string{},
+		uploads:  map[string]models.Upload{},
+		blobs:    map[string]models.Blob{},
+		contents: map[string][]byte{},
+	}
+}
+
+// SetMember gives userID role in the workspace, for setting up tests.
+func (m *Memory) SetMember(workspaceID, userID uuid.UUID, role string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.members[memberKey{workspaceID, userID}] = role
+}
+
+// AuditEvents returns the events recorded so far, oldest first.
+func (m *Memory) AuditEvents() []models.AuditEvent {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]models.AuditEvent(nil), m.events...)
+}
+
+// Content returns the bytes stored for digest.
+func (m *Memory) Content(digest string) ([]byte, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	content, ok := m.contents[digest]
+	return content, ok
+}
+
+func (m *Memory) FindNote(ctx context.Context, noteID string, userID uuid.UUID, min string) (models.Note, error) {
+	return m.findNote(noteID, userID, min, false)
+}
+
+func (m *Memory) FindTrashedNote(ctx context.Context, noteID string, userID uuid.UUID, min string) (models.Note, error) {
+	return m.findNote(noteID, userID, min, true)
+}
+
+func (m *Memory) findNote(noteID string, userID uuid.UUID, min string, trashed bool) (models.Note, error) {
+	id, err := uuid.Parse(noteID)
+	if err != nil {
+		return models.Note{}, workspace.ErrNoteNotFound
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	note, ok := m.notes[id]
+	if !ok || note.InTrash() != trashed {
+		return models.Note{}, workspace.ErrNoteNotFound
+	}
+	if note.WorkspaceID == nil {
+		if note.UserID != userID {
+			return models.Note{}, workspace.ErrNoteNotFound
+		}
+		return note, nil
+	}
+	if _, err := m.requireRole(*note.WorkspaceID, userID, min); err != nil {
+		if err == workspace.ErrNotMember {
+			return models.Note{}, workspace.ErrNoteNotFound
+		}
+		return models.Note{}, err
+	}
+	return note, nil
+}
+
+func (m *Memory) ListPersonalNotes(ctx context.Context, userID uuid.UUID) ([]models.Note, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	notes := []models.Note{}
+	for _, note := range m.notes {
+		if note.UserID == userID && note.WorkspaceID == nil && !note.InTrash() {
+			notes = append(notes, note)
+		}
+	}
+	sort.Slice(notes, func(i, j int) bool { return notes[i].CreatedAt.Before(notes[j].CreatedAt) })
+	return notes, nil
+}
+
+func (m *Memory) ListTrashedNotes(ctx context.Context, userID uuid.UUID) ([]models.Note, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	notes := []models.Note{}
+	for _, note := range m.notes {
+		if note.UserID == userID && note.WorkspaceID == nil && note.InTrash() {
+			notes = append(notes, note)
+		}
+	}
+	sort.Slice(notes, func(i, j int) bool { return notes[i].LastRemove.After(notes[j].LastRemove) })
+	return notes, nil
+}
+
+func (m *Memory) CreateNote(ctx context.Context, note *models.Note, actor audit.Actor) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if note.ID == uuid.Nil {
+		note.ID = uuid.New()
+	}
+	now := time.Now()
+	note.CreatedAt, note.LastChanged = now, now
+	m.notes[note.ID] = *note
+	m.record(actor, audit.ActionNoteCreate, audit.TargetNote, note.ID, audit.NoteChanges(nil, note))
+	return nil
+}
+
+func (m *Memory) UpdateNote(ctx context.Context, before models.Note, note *models.Note, actor audit.Actor) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	note.LastChanged = time.Now()
+	m.notes[note.ID] = *note
+	m.record(actor, audit.ActionNoteUpdate, audit.TargetNote, note.ID, audit.NoteChanges(&before, note))
+	return nil
+}
+
+func (m *Memory) DeleteNote(ctx context.Context, note *models.Note, actor audit.Actor) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	note.LastRemove = time.Now()
+	m.notes[note.ID] = *note
+	m.record(actor, audit.ActionNoteDelete, audit.TargetNote, note.ID, audit.TrashChanges(time.Time{}, note.LastRemove))
+	return nil
+}
+
+func (m *Memory) RestoreNote(ctx context.Context, note *models.Note, actor audit.Actor) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	removed := note.LastRemove
+	note.LastRemove = time.Time{}
+	m.notes[note.ID] = *note
+	m.record(actor, audit.ActionNoteRestore, audit.TargetNote, note.ID, audit.TrashChanges(removed, time.Time{}))
+	return nil
+}
+
+func (m *Memory) PurgeNote(ctx context.Context, note models.Note, actor audit.Actor) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.notes, note.ID)
+	m.record(actor, audit.ActionNotePurge, audit.TargetNote, note.ID, audit.TrashChanges(note.LastRemove, time.Time{}))
+	return nil
+}
+
+func (m *Memory) ShareNote(ctx context.Context, note *models.Note, workspaceID uuid.UUID, actor audit.Actor) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	note.WorkspaceID = &workspaceID
+	m.notes[note.ID] = *note
+	m.record(actor, audit.ActionNoteShare, audit.TargetNote, note.ID, audit.Changes{"workspace_id": {After: workspaceID.String()}})
+	return nil
+}
+
+func (m *Memory) AttachUpload(ctx context.Context, note *models.Note, upload models.Upload, asDashboard bool, actor audit.Actor) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	upload.NoteID = &note.ID
+	m.uploads[upload.Path] = upload
+	if !asDashboard {
+		return nil
+	}
+
+	before := *note
+	note.DashboardPath = upload.Path
+	m.notes[note.ID] = *note
+	m.record(actor, audit.ActionNoteUpdate, audit.TargetNote, note.ID, audit.NoteChanges(&before, note))
+	return nil
+}
+
+func (m *Memory) RequireRole(ctx context.Context, workspaceID, userID uuid.UUID, min string) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.requireRole(workspaceID, userID, min)
+}
+
+func (m *Memory) requireRole(workspaceID, userID uuid.UUID, min string) (string, error) {
+	role, ok := m.members[memberKey{workspaceID, userID}]
+	if !ok {
+		return "", workspace.ErrNotMember
+	}
+	if !workspace.RoleAtLeast(role, min) {
+		return role, workspace.ErrForbidden
+	}
+	return role, nil
+}
+
+func (m *Memory) Audience(ctx context.Context, note models.Note) []uuid.UUID {
+	if note.WorkspaceID == nil {
+		return []uuid.UUID{note.UserID}
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var ids []uuid.UUID
+	for key := range m.members {
+		if key.workspaceID == *note.WorkspaceID {
+			ids = append(ids, key.userID)
+		}
+	}
+	return ids
+}
+
+func (m *Memory) FindBlob(ctx context.Context, userID uuid.UUID, digest string) (models.Blob, error) {
+	if !storage.ValidDigest(digest) {
+		return models.Blob{}, storage.ErrInvalidDigest
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	blob, ok := m.blobs[digest]
+	if !ok || blob.RefCount < 1 || !m.hasUploaded(userID, digest) {
+		return models.Blob{}, storage.ErrBlobNotFound
+	}
+	return blob, nil
+}
+
+// hasUploaded reports whether userID has an upload of digest; m.mu must be
+// held.
+func (m *Memory) hasUploaded(userID uuid.UUID, digest string) bool {
+	for _, upload := range m.uploads {
+		if upload.UserID == userID && upload.Digest == digest {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *Memory) SaveUpload(ctx context.Context, userID uuid.UUID, src io.Reader, filename, contentType string, actor audit.Actor) (models.Upload, error) {
+	content, err := io.ReadAll(src)
+	if err != nil {
+		return models.Upload{}, err
+	}
+	sum := sha256.Sum256(content)
+	digest := hex.EncodeToString(sum[:])
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.blobs[digest]; !ok {
+		m.blobs[digest] = models.Blob{Digest: digest, Size: int64(len(content)), ContentType: contentType, CreatedAt: time.Now()}
+		m.contents[digest] = content
+	}
+	return m.link(userID, digest, filename, actor), nil
+}
+
+func (m *Memory) LinkUpload(ctx context.Context, userID uuid.UUID, digest, filename string, actor audit.Actor) (models.Upload, error) {
+	if _, err := m.FindBlob(ctx, userID, digest); err != nil {
+		return models.Upload{}, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.link(userID, digest, filename, actor), nil
+}
+
+// link records an upload of a stored blob; m.mu must be held.
+func (m *Memory) link(userID uuid.UUID, digest, filename string, actor audit.Actor) models.Upload {
+	blob := m.blobs[digest]
+	blob.RefCount++
+	m.blobs[digest] = blob
+
+	upload := models.Upload{
+		ID:          uuid.New(),
+		UserID:      userID,
+		Path:        filepath.ToSlash(filepath.Join(storage.UploadPath, uuid.New().String()+filepath.Ext(filename))),
+		Filename:    storage.CleanFilename(filename),
+		Digest:      digest,
+		ContentType: blob.ContentType,
+		Size:        blob.Size,
+		CreatedAt:   time.Now(),
+	}
+	m.uploads[upload.Path] = upload
+	m.record(actor, audit.ActionUploadCreate, audit.TargetUpload, upload.ID, audit.UploadChanges(upload))
+	return upload
+}
+
+func (m *Memory) CanAccessUpload(ctx context.Context, userID uuid.UUID, path string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	upload, ok := m.uploads[path]
+	if ok && upload.UserID == userID {
+		return true, nil
+	}
+	for _, note := range m.notes {
+		attached := ok && upload.NoteID != nil && *upload.NoteID == note.ID
+		if (note.DashboardPath == path || attached) && m.canSee(note, userID) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// canSee reports whether note is userID's personal note or in one of their
+// workspaces; m.mu must be held.
+func (m *Memory) canSee(note models.Note, userID uuid.UUID) bool {
+	if note.WorkspaceID == nil {
+		return note.UserID == userID
+	}
+	_, ok := m.members[memberKey{*note.WorkspaceID, userID}]
+	return ok
+}
+
+func (m *Memory) FindUpload(ctx context.Context, path string) (models.Upload, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	upload, ok := m.uploads[path]
+	if !ok {
+		return models.Upload{}, storage.ErrUploadNotFound
+	}
+	return upload, nil
+}
+
+func (m *Memory) OpenUpload(ctx context.Context, path string) (UploadContent, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	upload, ok := m.uploads[path]
+	if !ok {
+		return UploadContent{}, storage.ErrUploadNotFound
+	}
+	content := bytes.NewReader(m.contents[upload.Digest])
+	return UploadContent{ReadSeekCloser: nopCloser{content}, Upload: upload, ModTime: upload.CreatedAt}, nil
+}
+
+type nopCloser struct {
+	io.ReadSeeker
+}
+
+func (nopCloser) Close() error { return nil }
+
+func (m *Memory) ReleaseUpload(ctx context.Context, path string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.release(path)
+	return nil
+}
+
+func (m *Memory) ReleaseAttachments(ctx context.Context, noteID uuid.UUID) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for path, upload := range m.uploads {
+		if upload.NoteID != nil && *upload.NoteID == noteID {
+			m.release(path)
+		}
+	}
+	return nil
+}
+
+// release drops an upload and its blob once unreferenced; m.mu must be held.
+func (m *Memory) release(path string) {
+	upload, ok := m.uploads[path]
+	if !ok {
+		return
+	}
+	delete(m.uploads, path)
+
+	blob := m.blobs[upload.Digest]
+	blob.RefCount--
+	if blob.RefCount > 0 {
+		m.blobs[upload.Digest] = blob
+		return
+	}
+	delete(m.blobs, upload.Digest)
+	delete(m.contents, upload.Digest)
+}
+
+// Usage counts notes and uploads the same way quota.ForUser does. Memory has
+// no resumable uploads, so nothing is ever pending.
+func (m *Memory) Usage(ctx context.Context, userID uuid.UUID) (quota.Usage, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	usage := quota.Usage{MaxBytes: quota.MaxBytes(), MaxNotes: quota.MaxNotes()}
+	trashed := map[uuid.UUID]bool{}
+	trashedPaths := map[string]bool{}
+	for _, note := range m.notes {
+		if note.UserID != userID {
+			continue
+		}
+		size := note.PlainSize()
+		if note.LastRemove.IsZero() {
+			usage.Notes.Count++
+			usage.Notes.Bytes += size
+			continue
+		}
+		usage.Trash.Count++
+		usage.Trash.Bytes += size
+		trashed[note.ID] = true
+		trashedPaths[note.DashboardPath] = true
+	}
+
+	usage.NoteCount = usage.Notes.Count + usage.Trash.Count
+	for _, upload := range m.uploads {
+		if upload.UserID != userID {
+			continue
+		}
+		if (upload.NoteID != nil && trashed[*upload.NoteID]) || trashedPaths[upload.Path] {
+			usage.Trash.Count++
+			usage.Trash.Bytes += upload.Size
+			continue
+		}
+		usage.Attachments.Count++
+		usage.Attachments.Bytes += upload.Size
+	}
+	usage.TotalBytes = usage.Notes.Bytes + usage.Attachments.Bytes + usage.Trash.Bytes
+	return usage, nil
+}
+
+// record appends an audit event; m.mu must be held.
+func (m *Memory) record(actor audit.Actor, action, targetType string, targetID uuid.UUID, changes audit.Changes) {
+	encoded, _ := json.Marshal(changes)
+	m.events = append(m.events, models.AuditEvent{
+		ID:         uuid.New(),
+		ActorID:    actor.UserID,
+		Action:     action,
+		TargetType: targetType,
+		TargetID:   targetID.String(),
+		RequestID:  actor.RequestID,
+		ClientIP:   actor.ClientIP,
+		UserAgent:  actor.UserAgent,
+		Changes:    string(encoded),
+		CreatedAt:  time.Now(),
+	})
+}
@@ -0,0 +1,505 @@
+// internal/repository/postgres.go
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"NoteApi/internal/audit"
+	"NoteApi/internal/auth"
+	"NoteApi/internal/config"
+	"NoteApi/internal/database"
+	"NoteApi/internal/models"
+	"NoteApi/internal/quota"
+	"NoteApi/internal/storage"
+	"NoteApi/internal/workspace"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Postgres implements every repository on the application database. Access
+// checks, blob storage, quota accounting and tokens go through the workspace,
+// storage, quota and auth packages, which are handed the same connection.
+type Postgres struct {
+	db       *gorm.DB
+	tusLocks keyedMutex
+}
+
+func NewPostgres(db *gorm.DB) *Postgres {
+	return &Postgres{db: db}
+}
+
+func (p *Postgres) FindNote(ctx context.Context, noteID string, userID uuid.UUID, min string) (models.Note, error) {
+	return workspace.FindNote(p.db.WithContext(ctx), noteID, userID, min)
+}
+
+func (p *Postgres) FindTrashedNote(ctx context.Context, noteID string, userID uuid.UUID, min string) (models.Note, error) {
+	return workspace.FindTrashedNote(p.db.WithContext(ctx), noteID, userID, min)
+}
+
+func (p *Postgres) ListPersonalNotes(ctx context.Context, userID uuid.UUID) ([]models.Note, error) {
+	var notes []models.Note
+	err := p.db.WithContext(ctx).Scopes(models.NotTrashed).
+		Where("user_id = ? AND workspace_id IS NULL", userID).
+		Select("id, user_id, title, content, dashboard_path").
+		Find(&notes).Error
+	return notes, err
+}
+
+func (p *Postgres) ListTrashedNotes(ctx context.Context, userID uuid.UUID) ([]models.Note, error) {
+	var notes []models.Note
+	err := p.db.WithContext(ctx).Scopes(models.Trashed).
+		Where("user_id = ? AND workspace_id IS NULL", userID).
+		Order("last_remove DESC").
+		Find(&notes).Error
+	return notes, err
+}
+
+func (p *Postgres) CreateNote(ctx context.Context, note *models.Note, actor audit.Actor) error {
+	return p.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(note).Error; err != nil {
+			return err
+		}
+		return audit.Record(tx, actor, audit.ActionNoteCreate, audit.TargetNote, note.ID, audit.NoteChanges(nil, note))
+	})
+}
+
+func (p *Postgres) UpdateNote(ctx context.Context, before models.Note, note *models.Note, actor audit.Actor) error {
+	return p.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Save(note).Error; err != nil {
+			return err
+		}
+		return audit.Record(tx, actor, audit.ActionNoteUpdate, audit.TargetNote, note.ID, audit.NoteChanges(&before, note))
+	})
+}
+
+func (p *Postgres) DeleteNote(ctx context.Context, note *models.Note, actor audit.Actor) error {
+	return p.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := note.SoftDelete(tx); err != nil {
+			return err
+		}
+		return audit.Record(tx, actor, audit.ActionNoteDelete, audit.TargetNote, note.ID, audit.TrashChanges(time.Time{}, note.LastRemove))
+	})
+}
+
+func (p *Postgres) RestoreNote(ctx context.Context, note *models.Note, actor audit.Actor) error {
+	return p.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		removed := note.LastRemove
+		if err := note.Restore(tx); err != nil {
+			return err
+		}
+		return audit.Record(tx, actor, audit.ActionNoteRestore, audit.TargetNote, note.ID, audit.TrashChanges(removed, time.Time{}))
+	})
+}
+
+func (p *Postgres) PurgeNote(ctx context.Context, note models.Note, actor audit.Actor) error {
+	return p.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Delete(&note).Error; err != nil {
+			return err
+		}
+		return audit.Record(tx, actor, audit.ActionNotePurge, audit.TargetNote, note.ID, audit.TrashChanges(note.LastRemove, time.Time{}))
+	})
+}
+
+func (p *Postgres) ShareNote(ctx context.Context, note *models.Note, workspaceID uuid.UUID, actor audit.Actor) error {
+	err := p.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(note).Update("workspace_id", workspaceID).Error; err != nil {
+			return err
+		}
+		changes := audit.Changes{"workspace_id": {After: workspaceID.String()}}
+		return audit.Record(tx, actor, audit.ActionNoteShare, audit.TargetNote, note.ID, changes)
+	})
+	if err != nil {
+		return err
+	}
+	note.WorkspaceID = &workspaceID
+	return nil
+}
+
+// AttachUpload audits a dashboard image change as a note update.
+func (p *Postgres) AttachUpload(ctx context.Context, note *models.Note, upload models.Upload, asDashboard bool, actor audit.Actor) error {
+	return p.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&upload).Update("note_id", note.ID).Error; err != nil {
+			return err
+		}
+		if !asDashboard {
+			return nil
+		}
+
+		before := *note
+		note.DashboardPath = upload.Path
+		if err := tx.Save(note).Error; err != nil {
+			return err
+		}
+		return audit.Record(tx, actor, audit.ActionNoteUpdate, audit.TargetNote, note.ID, audit.NoteChanges(&before, note))
+	})
+}
+
+func (p *Postgres) RequireRole(ctx context.Context, workspaceID, userID uuid.UUID, min string) (string, error) {
+	return workspace.Require(p.db.WithContext(ctx), workspaceID, userID, min)
+}
+
+func (p *Postgres) Audience(ctx context.Context, note models.Note) []uuid.UUID {
+	return workspace.Audience(p.db.WithContext(ctx), note)
+}
+
+func (p *Postgres) FindBlob(ctx context.Context, userID uuid.UUID, digest string) (models.Blob, error) {
+	return storage.FindBlob(ctx, p.db, userID, digest)
+}
+
+func (p *Postgres) SaveUpload(ctx context.Context, userID uuid.UUID, src io.Reader, filename, contentType string, actor audit.Actor) (models.Upload, error) {
+	return storage.Save(ctx, p.db, userID, src, filename, contentType, audit.UploadHook(actor))
+}
+
+func (p *Postgres) LinkUpload(ctx context.Context, userID uuid.UUID, digest, filename string, actor audit.Actor) (models.Upload, error) {
+	return storage.Link(ctx, p.db, userID, digest, filename, audit.UploadHook(actor))
+}
+
+func (p *Postgres) CanAccessUpload(ctx context.Context, userID uuid.UUID, path string) (bool, error) {
+	db := p.db.WithContext(ctx)
+	var count int64
+	err := db.Model(&models.Upload{}).Where("path = ? AND user_id = ?", path, userID).Count(&count).Error
+	if err != nil || count > 0 {
+		return count > 0, err
+	}
+
+	// Notes the user can see: their personal notes and their workspaces' notes
+	visible := func(query *gorm.DB) *gorm.DB {
+		return query.Where("((notes.workspace_id IS NULL AND notes.user_id = ?) OR notes.workspace_id IN (?))",
+			userID,
+			db.Model(&models.WorkspaceMember{}).Select("workspace_id").Where("user_id = ?", userID),
+		)
+	}
+
+	err = visible(db.Model(&models.Note{}).Where("dashboard_path = ?", path)).Count(&count).Error
+	if err != nil || count > 0 {
+		return count > 0, err
+	}
+	err = visible(db.Model(&models.Upload{}).
+		Joins("JOIN notes ON notes.id = uploads.note_id").
+		Where("uploads.path = ?", path)).
+		Count(&count).Error
+	return count > 0, err
+}
+
+func (p *Postgres) FindUpload(ctx context.Context, path string) (models.Upload, error) {
+	var upload models.Upload
+	err := p.db.WithContext(ctx).Where("path = ?", path).First(&upload).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return models.Upload{}, storage.ErrUploadNotFound
+	}
+	return upload, err
+}
+
+func (p *Postgres) OpenUpload(ctx context.Context, path string) (UploadContent, error) {
+	file, upload, err := storage.Open(p.db.WithContext(ctx), path)
+	if err != nil {
+		return UploadContent{}, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return UploadContent{}, err
+	}
+	return UploadContent{ReadSeekCloser: file, Upload: upload, ModTime: info.ModTime()}, nil
+}
+
+func (p *Postgres) ReleaseUpload(ctx context.Context, path string) error {
+	return storage.Release(ctx, p.db, path)
+}
+
+// ReleaseAttachments keeps going past failures and reports all of them.
+func (p *Postgres) ReleaseAttachments(ctx context.Context, noteID uuid.UUID) error {
+	var uploads []models.Upload
+	if err := p.db.WithContext(ctx).Where("note_id = ?", noteID).Find(&uploads).Error; err != nil {
+		return err
+	}
+	var errs []error
+	for _, upload := range uploads {
+		if err := storage.Release(ctx, p.db, upload.Path); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", upload.Path, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (p *Postgres) Usage(ctx context.Context, userID uuid.UUID) (quota.Usage, error) {
+	return quota.ForUser(p.db.WithContext(ctx), userID)
+}
+
+func (p *Postgres) CreateWorkspace(ctx context.Context, name string, ownerID uuid.UUID) (models.Workspace, error) {
+	return workspace.Create(p.db.WithContext(ctx), name, ownerID)
+}
+
+func (p *Postgres) ListWorkspaces(ctx context.Context, userID uuid.UUID) ([]MemberWorkspace, error) {
+	var workspaces []MemberWorkspace
+	err := p.db.WithContext(ctx).Model(&models.Workspace{}).
+		Select("workspaces.*, workspace_members.role").
+		Joins("JOIN workspace_members ON workspace_members.workspace_id = workspaces.id").
+		Where("workspace_members.user_id = ?", userID).
+		Order("workspaces.name").
+		Scan(&workspaces).Error
+	return workspaces, err
+}
+
+func (p *Postgres) FindWorkspace(ctx context.Context, workspaceID uuid.UUID) (models.Workspace, error) {
+	var found models.Workspace
+	err := p.db.WithContext(ctx).Where("id = ?", workspaceID).First(&found).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return models.Workspace{}, ErrWorkspaceNotFound
+	}
+	return found, err
+}
+
+func (p *Postgres) RenameWorkspace(ctx context.Context, found *models.Workspace, name string) error {
+	found.Name = name
+	return p.db.WithContext(ctx).Save(found).Error
+}
+
+func (p *Postgres) CountWorkspaceNotes(ctx context.Context, workspaceID uuid.UUID) (notes, trashed int64, err error) {
+	db := p.db.WithContext(ctx)
+	if err := db.Model(&models.Note{}).Scopes(models.NotTrashed).Where("workspace_id = ?", workspaceID).Count(&notes).Error; err != nil {
+		return 0, 0, err
+	}
+	err = db.Model(&models.Note{}).Scopes(models.Trashed).Where("workspace_id = ?", workspaceID).Count(&trashed).Error
+	return notes, trashed, err
+}
+
+func (p *Postgres) DeleteWorkspace(ctx context.Context, workspaceID uuid.UUID) error {
+	return p.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("workspace_id = ?", workspaceID).Delete(&models.WorkspaceInvitation{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("workspace_id = ?", workspaceID).Delete(&models.WorkspaceMember{}).Error; err != nil {
+			return err
+		}
+		return tx.Where("id = ?", workspaceID).Delete(&models.Workspace{}).Error
+	})
+}
+
+func (p *Postgres) ListMembers(ctx context.Context, workspaceID uuid.UUID) ([]models.WorkspaceMember, error) {
+	var members []models.WorkspaceMember
+	err := p.db.WithContext(ctx).Where("workspace_id = ?", workspaceID).Order("created_at").Find(&members).Error
+	return members, err
+}
+
+func (p *Postgres) MemberRole(ctx context.Context, workspaceID, userID uuid.UUID) (string, error) {
+	return workspace.Role(p.db.WithContext(ctx), workspaceID, userID)
+}
+
+func (p *Postgres) SetMemberRole(ctx context.Context, workspaceID, userID uuid.UUID, role string) error {
+	return workspace.SetRole(p.db.WithContext(ctx), workspaceID, userID, role)
+}
+
+func (p *Postgres) RemoveMember(ctx context.Context, workspaceID, userID uuid.UUID) error {
+	return workspace.RemoveMember(p.db.WithContext(ctx), workspaceID, userID)
+}
+
+func (p *Postgres) CreateInvitation(ctx context.Context, workspaceID, invitedBy uuid.UUID, role string, inviteeID *uuid.UUID, ttl time.Duration) (string, models.WorkspaceInvitation, error) {
+	return workspace.CreateInvitation(p.db.WithContext(ctx), workspaceID, invitedBy, role, inviteeID, ttl)
+}
+
+func (p *Postgres) ListInvitations(ctx context.Context, workspaceID uuid.UUID) ([]models.WorkspaceInvitation, error) {
+	var invitations []models.WorkspaceInvitation
+	err := p.db.WithContext(ctx).
+		Where("workspace_id = ? AND accepted_at IS NULL AND revoked_at IS NULL AND expires_at > ?", workspaceID, time.Now()).
+		Order("created_at DESC").
+		Find(&invitations).Error
+	return invitations, err
+}
+
+func (p *Postgres) RevokeInvitation(ctx context.Context, workspaceID uuid.UUID, invitationID string) error {
+	result := p.db.WithContext(ctx).Model(&models.WorkspaceInvitation{}).
+		Where("id = ? AND workspace_id = ? AND accepted_at IS NULL AND revoked_at IS NULL", invitationID, workspaceID).
+		Update("revoked_at", time.Now())
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrInvitationNotFound
+	}
+	return nil
+}
+
+func (p *Postgres) AcceptInvitation(ctx context.Context, code string, userID uuid.UUID) (models.WorkspaceMember, error) {
+	return workspace.AcceptInvitation(p.db.WithContext(ctx), code, userID)
+}
+
+func (p *Postgres) ListWorkspaceNotes(ctx context.Context, workspaceID uuid.UUID, trashed bool) ([]models.Note, error) {
+	scope, order := models.NotTrashed, "last_changed DESC"
+	if trashed {
+		scope, order = models.Trashed, "last_remove DESC"
+	}
+	var notes []models.Note
+	err := p.db.WithContext(ctx).Scopes(scope).Where("workspace_id = ?", workspaceID).Order(order).Find(&notes).Error
+	return notes, err
+}
+
+func (p *Postgres) SearchNotes(ctx context.Context, workspaceID uuid.UUID, query string, limit int) ([]models.Note, error) {
+	return workspace.SearchNotes(p.db.WithContext(ctx), workspaceID, query, limit)
+}
+
+func (p *Postgres) CreateAPIToken(ctx context.Context, userID uuid.UUID, name string, scopes []string, expiresAt *time.Time) (string, models.APIToken, error) {
+	return auth.CreateAPIToken(p.db.WithContext(ctx), userID, name, scopes, expiresAt)
+}
+
+func (p *Postgres) ListAPITokens(ctx context.Context, userID uuid.UUID) ([]models.APIToken, error) {
+	var tokens []models.APIToken
+	err := p.db.WithContext(ctx).Where("user_id = ?", userID).Order("created_at DESC").Find(&tokens).Error
+	return tokens, err
+}
+
+func (p *Postgres) FindAPIToken(ctx context.Context, userID uuid.UUID, tokenID string) (models.APIToken, error) {
+	id, err := uuid.Parse(tokenID)
+	if err != nil {
+		return models.APIToken{}, ErrAPITokenNotFound
+	}
+	var token models.APIToken
+	err = p.db.WithContext(ctx).Where("id = ? AND user_id = ?", id, userID).First(&token).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return models.APIToken{}, ErrAPITokenNotFound
+	}
+	return token, err
+}
+
+func (p *Postgres) RevokeAPIToken(ctx context.Context, tokenID uuid.UUID, at time.Time) error {
+	return p.db.WithContext(ctx).Model(&models.APIToken{}).
+		Where("id = ? AND revoked_at IS NULL", tokenID).
+		Update("revoked_at", at).Error
+}
+
+func (p *Postgres) RevokeToken(ctx context.Context, userID uuid.UUID, jti string, expiresAt time.Time) error {
+	return auth.RevokeToken(p.db.WithContext(ctx), userID, jti, expiresAt)
+}
+
+func (p *Postgres) RevokeTokensBefore(ctx context.Context, userID uuid.UUID, cutoff time.Time) error {
+	return auth.RevokeUserTokensBefore(p.db.WithContext(ctx), userID, cutoff)
+}
+
+func (p *Postgres) IssueTicket(ctx context.Context, principal auth.Principal) (string, time.Time, error) {
+	return auth.IssueTicket(p.db.WithContext(ctx), principal)
+}
+
+func (p *Postgres) ListAuditEvents(ctx context.Context, userID uuid.UUID, filter audit.Filter) ([]models.AuditEvent, string, error) {
+	return audit.List(p.db.WithContext(ctx), userID, filter)
+}
+
+func (p *Postgres) CreateTusUpload(ctx context.Context, upload *models.TusUpload) error {
+	return p.db.WithContext(ctx).Create(upload).Error
+}
+
+func (p *Postgres) FindTusUpload(ctx context.Context, uploadID string, userID uuid.UUID) (models.TusUpload, error) {
+	id, err := uuid.Parse(uploadID)
+	if err != nil {
+		return models.TusUpload{}, ErrTusUploadNotFound
+	}
+	var upload models.TusUpload
+	err = p.db.WithContext(ctx).Where("id = ? AND user_id = ?", id, userID).First(&upload).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return models.TusUpload{}, ErrTusUploadNotFound
+	}
+	return upload, err
+}
+
+func (p *Postgres) WriteTusUpload(ctx context.Context, uploadID uuid.UUID, from int64, expiresAt time.Time, write func() int64) error {
+	// SQLite has no row locks and its transactions lock the whole database,
+	// which would stall every other write for as long as the chunk takes to
+	// arrive. Only one process serves a SQLite database, so a lock in memory
+	// is enough there.
+	if p.db.Dialector.Name() == config.DriverSQLite {
+		unlock := p.tusLocks.lock(uploadID)
+		defer unlock()
+		return writeTusUpload(p.db.WithContext(ctx), false, uploadID, from, expiresAt, write)
+	}
+	return p.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		return writeTusUpload(tx, true, uploadID, from, expiresAt, write)
+	})
+}
+
+// writeTusUpload checks the offset, writes and records the new offset. With
+// lockRow it holds the upload's row lock until db's transaction ends;
+// otherwise the caller holds the upload's lock.
+func writeTusUpload(db *gorm.DB, lockRow bool, uploadID uuid.UUID, from int64, expiresAt time.Time, write func() int64) error {
+	query := db
+	if lockRow {
+		query = query.Clauses(clause.Locking{Strength: "UPDATE"})
+	}
+	var upload models.TusUpload
+	if err := query.Where("id = ?", uploadID).First(&upload).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrTusUploadNotFound
+		}
+		return err
+	}
+	if upload.Offset != from {
+		return ErrTusOffsetChanged
+	}
+
+	written := write()
+	return db.Model(&models.TusUpload{}).
+		Where("id = ?", uploadID).
+		Updates(map[string]interface{}{"upload_offset": from + written, "expires_at": expiresAt}).Error
+}
+
+func (p *Postgres) CompleteTusUpload(ctx context.Context, uploadID uuid.UUID, path string) error {
+	return p.db.WithContext(ctx).Model(&models.TusUpload{}).Where("id = ?", uploadID).Update("path", path).Error
+}
+
+func (p *Postgres) DeleteTusUpload(ctx context.Context, uploadID uuid.UUID) error {
+	return p.db.WithContext(ctx).Where("id = ?", uploadID).Delete(&models.TusUpload{}).Error
+}
+
+func (p *Postgres) Ping(ctx context.Context) error {
+	sqlDB, err := p.db.DB()
+	if err != nil {
+		return err
+	}
+	return sqlDB.PingContext(ctx)
+}
+
+func (p *Postgres) SchemaVersion(ctx context.Context) (int, error) {
+	return database.CheckSchemaVersion(p.db.WithContext(ctx))
+}
+
+// keyedMutex holds one lock per ID, dropping each once nobody holds or waits
+// for it.
+type keyedMutex struct {
+	mu    sync.Mutex
+	locks map[uuid.UUID]*keyedLock
+}
+
+type keyedLock struct {
+	sync.Mutex
+	users int
+}
+
+// lock blocks until id is free and returns the function that frees it.
+func (k *keyedMutex) lock(id uuid.UUID) func() {
+	k.mu.Lock()
+	if k.locks == nil {
+		k.locks = map[uuid.UUID]*keyedLock{}
+	}
+	entry := k.locks[id]
+	if entry == nil {
+		entry = &keyedLock{}
+		k.locks[id] = entry
+	}
+	entry.users++
+	k.mu.Unlock()
+
+	entry.Lock()
+	return func() {
+		entry.Unlock()
+		k.mu.Lock()
+		if entry.users--; entry.users == 0 {
+			delete(k.locks, id)
+		}
+		k.mu.Unlock()
+	}
+}
@@ -0,0 +1,207 @@
+// internal/repository/repository.go
+package repository
+
+import (
+	"context"
+	"errors"
+	"io"
+	"time"
+
+	"NoteApi/internal/audit"
+	"NoteApi/internal/auth"
+	"NoteApi/internal/models"
+	"NoteApi/internal/quota"
+
+	"github.com/google/uuid"
+)
+
+var (
+	ErrWorkspaceNotFound  = errors.New("workspace not found")
+	ErrInvitationNotFound = errors.New("invitation not found")
+	ErrAPITokenNotFound   = errors.New("API token not found")
+	ErrTusUploadNotFound  = errors.New("tus upload not found")
+	// ErrTusOffsetChanged means another request moved the upload's offset
+	// first.
+	ErrTusOffsetChanged = errors.New("tus upload offset changed")
+)
+
+// NoteRepository stores what the note and upload handlers work with: notes,
+// the workspace roles that guard them, uploads and quota usage. Writes that
+// are audited take the actor and record the event with the change.
+//
+// Errors follow the packages the Postgres implementation wraps:
+// workspace.ErrNoteNotFound, workspace.ErrNotMember and workspace.ErrForbidden
+// for access checks, storage.ErrBlobNotFound and storage.ErrInvalidDigest for
+// blob lookups and storage.ErrUploadNotFound for downloads. Every method
+// takes the request context so queries and storage writes are traced under
+// the request.
+type NoteRepository interface {
+	// FindNote loads a note userID may act on with at least role min. Notes
+	// in the trash are not found.
+	FindNote(ctx context.Context, noteID string, userID uuid.UUID, min string) (models.Note, error)
+	// FindTrashedNote is FindNote for notes in the trash.
+	FindTrashedNote(ctx context.Context, noteID string, userID uuid.UUID, min string) (models.Note, error)
+	// ListPersonalNotes lists userID's notes that are neither in a workspace
+	// nor in the trash.
+	ListPersonalNotes(ctx context.Context, userID uuid.UUID) ([]models.Note, error)
+	// ListTrashedNotes lists userID's personal notes in the trash, most
+	// recently deleted first.
+	ListTrashedNotes(ctx context.Context, userID uuid.UUID) ([]models.Note, error)
+	CreateNote(ctx context.Context, note *models.Note, actor audit.Actor) error
+	UpdateNote(ctx context.Context, before models.Note, note *models.Note, actor audit.Actor) error
+	// DeleteNote moves note to the trash. Its files are kept, and still
+	// count against the quota, until it is purged.
+	DeleteNote(ctx context.Context, note *models.Note, actor audit.Actor) error
+	// RestoreNote takes note back out of the trash.
+	RestoreNote(ctx context.Context, note *models.Note, actor audit.Actor) error
+	// PurgeNote deletes note for good. The caller releases its files. Only
+	// the note's ID and last_remove need to be loaded.
+	PurgeNote(ctx context.Context, note models.Note, actor audit.Actor) error
+	// ShareNote moves a personal note into a workspace.
+	ShareNote(ctx context.Context, note *models.Note, workspaceID uuid.UUID, actor audit.Actor) error
+	// AttachUpload links upload to note, as its dashboard image when
+	// asDashboard is set.
+	AttachUpload(ctx context.Context, note *models.Note, upload models.Upload, asDashboard bool, actor audit.Actor) error
+
+	// RequireRole returns userID's role in the workspace, failing unless it
+	// is at least min.
+	RequireRole(ctx context.Context, workspaceID, userID uuid.UUID, min string) (string, error)
+	// Audience lists the users who should hear about changes to note.
+	Audience(ctx context.Context, note models.Note) []uuid.UUID
+
+	// FindBlob looks up content userID has uploaded before. Content only
+	// other users have uploaded is not found.
+	FindBlob(ctx context.Context, userID uuid.UUID, digest string) (models.Blob, error)
+	// SaveUpload stores src as a new upload for userID.
+	SaveUpload(ctx context.Context, userID uuid.UUID, src io.Reader, filename, contentType string, actor audit.Actor) (models.Upload, error)
+	// LinkUpload records another upload of content userID has uploaded
+	// before, failing like FindBlob otherwise.
+	LinkUpload(ctx context.Context, userID uuid.UUID, digest, filename string, actor audit.Actor) (models.Upload, error)
+	// CanAccessUpload reports whether userID uploaded the file at path or can
+	// see a note that displays it as its dashboard image or has it attached.
+	CanAccessUpload(ctx context.Context, userID uuid.UUID, path string) (bool, error)
+	// FindUpload loads the upload record stored at path.
+	FindUpload(ctx context.Context, path string) (models.Upload, error)
+	// OpenUpload returns the content of the upload at path. Files written
+	// before uploads were tracked come with an empty record.
+	OpenUpload(ctx context.Context, path string) (UploadContent, error)
+	// ReleaseUpload drops the upload stored at path; unknown paths are ignored.
+	ReleaseUpload(ctx context.Context, path string) error
+	// ReleaseAttachments drops every upload attached to a deleted note.
+	ReleaseAttachments(ctx context.Context, noteID uuid.UUID) error
+	Usage(ctx context.Context, userID uuid.UUID) (quota.Usage, error)
+}
+
+// UploadContent is an open upload as served by DownloadUpload.
+type UploadContent struct {
+	io.ReadSeekCloser
+	Upload  models.Upload
+	ModTime time.Time
+}
+
+// MemberWorkspace is a workspace with the role of the member it was listed
+// for.
+type MemberWorkspace struct {
+	models.Workspace
+	Role string `json:"role"`
+}
+
+// WorkspaceRepository stores workspaces, their members and invitations for
+// the workspace routes. Role checks go through NoteRepository.RequireRole;
+// errors are those of the workspace package plus ErrWorkspaceNotFound and
+// ErrInvitationNotFound.
+type WorkspaceRepository interface {
+	// CreateWorkspace makes a workspace with ownerID as its owner.
+	CreateWorkspace(ctx context.Context, name string, ownerID uuid.UUID) (models.Workspace, error)
+	// ListWorkspaces lists the workspaces userID belongs to by name.
+	ListWorkspaces(ctx context.Context, userID uuid.UUID) ([]MemberWorkspace, error)
+	FindWorkspace(ctx context.Context, workspaceID uuid.UUID) (models.Workspace, error)
+	RenameWorkspace(ctx context.Context, workspace *models.Workspace, name string) error
+	// CountWorkspaceNotes counts the workspace's notes outside and inside the
+	// trash.
+	CountWorkspaceNotes(ctx context.Context, workspaceID uuid.UUID) (notes, trashed int64, err error)
+	// DeleteWorkspace removes the workspace with its members and
+	// invitations. Its notes must be gone already.
+	DeleteWorkspace(ctx context.Context, workspaceID uuid.UUID) error
+
+	// ListMembers lists the workspace's members, longest standing first.
+	ListMembers(ctx context.Context, workspaceID uuid.UUID) ([]models.WorkspaceMember, error)
+	// MemberRole returns userID's role in the workspace, or "" when not a
+	// member.
+	MemberRole(ctx context.Context, workspaceID, userID uuid.UUID) (string, error)
+	SetMemberRole(ctx context.Context, workspaceID, userID uuid.UUID, role string) error
+	RemoveMember(ctx context.Context, workspaceID, userID uuid.UUID) error
+
+	// CreateInvitation returns the invitation and its code, which is not
+	// stored.
+	CreateInvitation(ctx context.Context, workspaceID, invitedBy uuid.UUID, role string, inviteeID *uuid.UUID, ttl time.Duration) (string, models.WorkspaceInvitation, error)
+	// ListInvitations lists the invitations that can still be accepted,
+	// newest first.
+	ListInvitations(ctx context.Context, workspaceID uuid.UUID) ([]models.WorkspaceInvitation, error)
+	// RevokeInvitation revokes an invitation that can still be accepted.
+	RevokeInvitation(ctx context.Context, workspaceID uuid.UUID, invitationID string) error
+	AcceptInvitation(ctx context.Context, code string, userID uuid.UUID) (models.WorkspaceMember, error)
+
+	// ListWorkspaceNotes lists the workspace's notes outside the trash, most
+	// recently changed first, or with trashed those in it, most recently
+	// deleted first.
+	ListWorkspaceNotes(ctx context.Context, workspaceID uuid.UUID, trashed bool) ([]models.Note, error)
+	// SearchNotes runs a full-text search over the workspace's notes, failing
+	// with workspace.ErrSearchDisabled while notes are encrypted.
+	SearchNotes(ctx context.Context, workspaceID uuid.UUID, query string, limit int) ([]models.Note, error)
+}
+
+// AccountRepository stores what a user manages about their own access: API
+// tokens, revocations, websocket tickets and their audit log.
+type AccountRepository interface {
+	// CreateAPIToken returns the token's plaintext, which is not stored.
+	CreateAPIToken(ctx context.Context, userID uuid.UUID, name string, scopes []string, expiresAt *time.Time) (string, models.APIToken, error)
+	// ListAPITokens lists userID's tokens, revoked ones included, newest
+	// first.
+	ListAPITokens(ctx context.Context, userID uuid.UUID) ([]models.APIToken, error)
+	// FindAPIToken loads one of userID's tokens or fails with
+	// ErrAPITokenNotFound.
+	FindAPIToken(ctx context.Context, userID uuid.UUID, tokenID string) (models.APIToken, error)
+	// RevokeAPIToken marks the token revoked at at, unless it already is.
+	RevokeAPIToken(ctx context.Context, tokenID uuid.UUID, at time.Time) error
+	// RevokeToken denies the JWT with jti until it expires; a zero expiresAt
+	// keeps the revocation for the longest token lifetime.
+	RevokeToken(ctx context.Context, userID uuid.UUID, jti string, expiresAt time.Time) error
+	// RevokeTokensBefore denies every token of userID issued before cutoff.
+	RevokeTokensBefore(ctx context.Context, userID uuid.UUID, cutoff time.Time) error
+	// IssueTicket returns a single-use websocket ticket for principal and
+	// when it expires.
+	IssueTicket(ctx context.Context, principal auth.Principal) (string, time.Time, error)
+	// ListAuditEvents returns the events performed by userID, newest first,
+	// and the cursor of the next page, failing with audit.ErrInvalidCursor.
+	ListAuditEvents(ctx context.Context, userID uuid.UUID, filter audit.Filter) ([]models.AuditEvent, string, error)
+}
+
+// HealthRepository reports whether the database can serve requests, for the
+// readiness checks.
+type HealthRepository interface {
+	// Ping checks that the database answers within ctx.
+	Ping(ctx context.Context) error
+	// SchemaVersion returns the applied migration version, failing with
+	// database.ErrSchemaOutdated or database.ErrSchemaTooNew unless it is the
+	// one this build expects.
+	SchemaVersion(ctx context.Context) (int, error)
+}
+
+// TusRepository stores the state of resumable uploads; their content is
+// staged on disk by the tus handlers.
+type TusRepository interface {
+	CreateTusUpload(ctx context.Context, upload *models.TusUpload) error
+	// FindTusUpload loads one of userID's uploads or fails with
+	// ErrTusUploadNotFound.
+	FindTusUpload(ctx context.Context, uploadID string, userID uuid.UUID) (models.TusUpload, error)
+	// WriteTusUpload calls write to append the next chunk at offset from
+	// while no other request can write the upload, then advances the offset
+	// by the bytes write reports and extends the expiry. It fails with
+	// ErrTusOffsetChanged, without calling write, if the offset is no longer
+	// from.
+	WriteTusUpload(ctx context.Context, uploadID uuid.UUID, from int64, expiresAt time.Time, write func() int64) error
+	// CompleteTusUpload records where the finished content was stored.
+	CompleteTusUpload(ctx context.Context, uploadID uuid.UUID, path string) error
+	DeleteTusUpload(ctx context.Context, uploadID uuid.UUID) error
+}
@@ -0,0 +1,298 @@
+// internal/storage/blobs.go
+package storage
+
+import (
+	"NoteApi/internal/models"
+	"NoteApi/internal/tracing"
+	"NoteApi/pkg/utils"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"github.com/google/uuid"
+	"go.opentelemetry.io/otel/attribute"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// UploadPath prefixes the public path of every upload ("uploads/<name>"),
+// which is also its URL path.
+const UploadPath = "uploads"
+
+// Root is the directory holding uploaded content on disk; see SetRoot.
+var Root = "uploads"
+
+// SetRoot moves on-disk storage to dir. Public upload paths do not change.
+func SetRoot(dir string) {
+	Root = dir
+}
+
+// legacyPath is where an upload stored before deduplication keeps its file.
+func legacyPath(path string) string {
+	return filepath.Join(Root, strings.TrimPrefix(path, UploadPath+"/"))
+}
+
+var (
+	ErrBlobNotFound   = errors.New("blob not found")
+	ErrUploadNotFound = errors.New("upload not found")
+	ErrInvalidDigest  = errors.New("invalid sha256 digest")
+)
+
+var digestPattern = regexp.MustCompile(`^[0-9a-f]{64}$`)
+
+// ValidDigest reports whether digest is a lowercase hex SHA-256.
+func ValidDigest(digest string) bool {
+	return digestPattern.MatchString(digest)
+}
+
+// BlobPath returns where the content for digest is stored on disk.
+func BlobPath(digest string) string {
+	return filepath.Join(Root, "blobs", digest[:2], digest)
+}
+
+// TusPath returns the staging file for an in-progress resumable upload, or the
+// staging directory itself when id is empty.
+func TusPath(id string) string {
+	return filepath.Join(Root, "tus", id)
+}
+
+// CheckWritable verifies that new uploads can be written by creating and
+// removing a file where Save stages them.
+func CheckWritable() error {
+	tmpDir := filepath.Join(Root, "tmp")
+	if err := utils.EnsureDir(tmpDir); err != nil {
+		return err
+	}
+	probe, err := os.CreateTemp(tmpDir, "probe-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(probe.Name())
+	if _, err := probe.Write([]byte("ok")); err != nil {
+		probe.Close()
+		return err
+	}
+	return probe.Close()
+}
+
+// TxHook runs inside the transaction that records a new Upload, so work that
+// must commit or roll back together with it (such as audit events) can join.
+type TxHook func(tx *gorm.DB, upload models.Upload) error
+
+// Save hashes src while writing it to a temporary file, then records an
+// Upload for userID that references the deduplicated blob. contentType should
+// be the sniffed type (see Sniff); metadata is extracted for new blobs.
+func Save(ctx context.Context, db *gorm.DB, userID uuid.UUID, src io.Reader, filename, contentType string, hooks ...TxHook) (upload models.Upload, err error) {
+	ctx, span := tracing.Start(ctx, "storage.save", attribute.String("upload.content_type", contentType))
+	defer func() { tracing.End(span, err) }()
+
+	tmpDir := filepath.Join(Root, "tmp")
+	if err := utils.EnsureDir(tmpDir); err != nil {
+		return models.Upload{}, err
+	}
+
+	tmp, err := os.CreateTemp(tmpDir, "upload-*")
+	if err != nil {
+		return models.Upload{}, err
+	}
+	defer os.Remove(tmp.Name())
+
+	_, write := tracing.Start(ctx, "storage.write")
+	hash := sha256.New()
+	size, err := io.Copy(io.MultiWriter(tmp, hash), src)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	write.SetAttributes(attribute.Int64("upload.size", size))
+	tracing.End(write, err)
+	if err != nil {
+		return models.Upload{}, err
+	}
+	digest := hex.EncodeToString(hash.Sum(nil))
+
+	blob := models.Blob{Digest: digest, Size: size, ContentType: contentType}
+	if _, err := findBlob(db.WithContext(ctx), digest); errors.Is(err, ErrBlobNotFound) {
+		metadata := ExtractMetadata(tmp.Name(), contentType)
+		blob.PageCount = metadata.PageCount
+		blob.DurationSeconds = metadata.DurationSeconds
+		blob.RowCount = metadata.RowCount
+	}
+
+	upload, err = link(ctx, db, userID, blob, filename, hooks)
+	if err != nil {
+		return models.Upload{}, err
+	}
+
+	// Move the content into place after the reference is committed so a
+	// concurrent release of the same digest cannot delete it underneath us.
+	dst := BlobPath(digest)
+	if _, err := os.Stat(dst); errors.Is(err, os.ErrNotExist) {
+		if err := utils.EnsureDir(filepath.Dir(dst)); err != nil {
+			return models.Upload{}, err
+		}
+		if err := os.Rename(tmp.Name(), dst); err != nil {
+			return models.Upload{}, err
+		}
+	}
+
+	return upload, nil
+}
+
+// Link records a new Upload for userID that references content userID has
+// already uploaded, so clients can skip re-sending identical files.
+func Link(ctx context.Context, db *gorm.DB, userID uuid.UUID, digest, filename string, hooks ...TxHook) (models.Upload, error) {
+	blob, err := FindBlob(ctx, db, userID, digest)
+	if err != nil {
+		return models.Upload{}, err
+	}
+
+	return link(ctx, db, userID, blob, filename, hooks)
+}
+
+// FindBlob looks up stored content by digest among the uploads of userID.
+// Content only other users have uploaded is reported as ErrBlobNotFound: a
+// digest leaks through ETags and shared notes, so knowing one must neither
+// grant access to the content nor reveal that the server has it.
+func FindBlob(ctx context.Context, db *gorm.DB, userID uuid.UUID, digest string) (models.Blob, error) {
+	db = db.WithContext(ctx)
+	return findBlob(db.Where("digest IN (?)", db.Model(&models.Upload{}).Select("digest").Where("user_id = ?", userID)), digest)
+}
+
+// findBlob looks up stored content by digest, narrowed by query.
+func findBlob(query *gorm.DB, digest string) (models.Blob, error) {
+	if !ValidDigest(digest) {
+		return models.Blob{}, ErrInvalidDigest
+	}
+
+	var blob models.Blob
+	if err := query.Where("digest = ? AND ref_count > 0", digest).First(&blob).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return models.Blob{}, ErrBlobNotFound
+		}
+		return models.Blob{}, err
+	}
+	return blob, nil
+}
+
+func link(ctx context.Context, db *gorm.DB, userID uuid.UUID, blob models.Blob, filename string, hooks []TxHook) (models.Upload, error) {
+	upload := models.Upload{
+		UserID:      userID,
+		Path:        filepath.ToSlash(filepath.Join(UploadPath, uuid.New().String()+filepath.Ext(filename))),
+		Filename:    CleanFilename(filename),
+		Digest:      blob.Digest,
+		ContentType: blob.ContentType,
+		Size:        blob.Size,
+	}
+
+	err := db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		blob.RefCount = 1
+		if err := tx.Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "digest"}},
+			DoUpdates: clause.Assignments(map[string]interface{}{"ref_count": gorm.Expr("blobs.ref_count + 1")}),
+		}).Create(&blob).Error; err != nil {
+			return err
+		}
+		if err := tx.Create(&upload).Error; err != nil {
+			return err
+		}
+		for _, hook := range hooks {
+			if err := hook(tx, upload); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return models.Upload{}, err
+	}
+	return upload, nil
+}
+
+// CleanFilename keeps only the base name of a client supplied file name.
+func CleanFilename(name string) string {
+	name = filepath.Base(filepath.Clean("/" + strings.ReplaceAll(name, "\\", "/")))
+	if name == "/" || name == "." {
+		return ""
+	}
+	return name
+}
+
+// Release drops the Upload stored at path and frees its blob once nothing
+// else references it. Paths without an Upload record are left untouched.
+func Release(ctx context.Context, db *gorm.DB, path string) error {
+	if path == "" {
+		return nil
+	}
+
+	return db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var upload models.Upload
+		if err := tx.Where("path = ?", path).First(&upload).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return nil
+			}
+			return err
+		}
+		if err := tx.Delete(&upload).Error; err != nil {
+			return err
+		}
+
+		// Uploads stored before deduplication own their file outright.
+		if upload.Digest == "" {
+			if err := os.Remove(legacyPath(upload.Path)); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+			return nil
+		}
+
+		var blob models.Blob
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("digest = ?", upload.Digest).
+			First(&blob).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return nil
+			}
+			return err
+		}
+
+		if blob.RefCount > 1 {
+			return tx.Model(&blob).Update("ref_count", gorm.Expr("ref_count - 1")).Error
+		}
+
+		if err := tx.Delete(&blob).Error; err != nil {
+			return err
+		}
+		if err := os.Remove(BlobPath(blob.Digest)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	})
+}
+
+// Open returns the file backing the upload at path along with its record.
+// Files written before uploads were tracked are opened directly.
+func Open(db *gorm.DB, path string) (*os.File, models.Upload, error) {
+	var upload models.Upload
+	err := db.Where("path = ?", path).First(&upload).Error
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, models.Upload{}, err
+	}
+
+	diskPath := legacyPath(path)
+	if upload.Digest != "" {
+		diskPath = BlobPath(upload.Digest)
+	}
+
+	file, err := os.Open(diskPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, models.Upload{}, ErrUploadNotFound
+		}
+		return nil, models.Upload{}, err
+	}
+	return file, upload, nil
+}
@@ -0,0 +1,233 @@
+// internal/storage/gc.go
+package storage
+
+import (
+	"NoteApi/internal/models"
+	"context"
+	"errors"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// GCReport counts what GC found. Nothing is changed on a dry run, but the
+// counts are the same.
+type GCReport struct {
+	// RefCountsFixed counts blobs whose ref_count disagreed with their uploads.
+	RefCountsFixed int
+	// UnreferencedBlobs counts blobs no upload points at any more.
+	UnreferencedBlobs int
+	// OrphanedFiles counts blob files without a blob record.
+	OrphanedFiles int
+	// StaleTusUploads counts resumable uploads abandoned before completion,
+	// and staging files left without their record.
+	StaleTusUploads int
+	// StaleTempFiles counts files left in the staging directory by
+	// interrupted saves.
+	StaleTempFiles int
+	// BytesFreed is the size of the files removed, or that would be.
+	BytesFreed int64
+}
+
+// GC repairs blob reference counts and removes stored content nothing refers
+// to. Files are only removed once they are older than olderThan, so uploads
+// in progress are left alone; it is safe to run while the server is up.
+func GC(ctx context.Context, db *gorm.DB, olderThan time.Duration, dryRun bool) (GCReport, error) {
+	var report GCReport
+	cutoff := time.Now().Add(-olderThan)
+	db = db.WithContext(ctx)
+
+	if err := gcBlobs(db, &report, dryRun); err != nil {
+		return report, err
+	}
+	if err := gcBlobFiles(db, cutoff, &report, dryRun); err != nil {
+		return report, err
+	}
+	if err := gcTusUploads(db, cutoff, &report, dryRun); err != nil {
+		return report, err
+	}
+	err := removeOlderFiles(filepath.Join(Root, "tmp"), cutoff, dryRun, func(info fs.FileInfo) (bool, error) {
+		report.StaleTempFiles++
+		report.BytesFreed += info.Size()
+		return true, nil
+	})
+	return report, err
+}
+
+// gcBlobs recounts the uploads of every blob whose ref_count looks wrong,
+// deleting blobs that have none left.
+func gcBlobs(db *gorm.DB, report *GCReport, dryRun bool) error {
+	var suspects []models.Blob
+	err := db.Model(&models.Blob{}).
+		Select("blobs.digest, blobs.size, blobs.ref_count").
+		Joins("LEFT JOIN uploads ON uploads.digest = blobs.digest").
+		Group("blobs.digest, blobs.size, blobs.ref_count").
+		Having("COUNT(uploads.id) <> blobs.ref_count OR COUNT(uploads.id) = 0").
+		Find(&suspects).Error
+	if err != nil {
+		return err
+	}
+
+	for _, suspect := range suspects {
+		// Recount under the row lock Link and Release take, so uploads made
+		// since the query above are not missed
+		err := db.Transaction(func(tx *gorm.DB) error {
+			var blob models.Blob
+			if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+				Where("digest = ?", suspect.Digest).
+				First(&blob).Error; err != nil {
+				if errors.Is(err, gorm.ErrRecordNotFound) {
+					return nil
+				}
+				return err
+			}
+			var uploads int64
+			if err := tx.Model(&models.Upload{}).Where("digest = ?", blob.Digest).Count(&uploads).Error; err != nil {
+				return err
+			}
+
+			switch {
+			case uploads == 0:
+				report.UnreferencedBlobs++
+				report.BytesFreed += blob.Size
+				if dryRun {
+					return nil
+				}
+				if err := tx.Delete(&blob).Error; err != nil {
+					return err
+				}
+				if err := os.Remove(BlobPath(blob.Digest)); err != nil && !os.IsNotExist(err) {
+					return err
+				}
+			case uploads != blob.RefCount:
+				report.RefCountsFixed++
+				if dryRun {
+					return nil
+				}
+				return tx.Model(&blob).Update("ref_count", uploads).Error
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// gcBlobFiles removes files in the blob directory that have no blob record,
+// as left behind when a release could not delete its file.
+func gcBlobFiles(db *gorm.DB, cutoff time.Time, report *GCReport, dryRun bool) error {
+	root := filepath.Join(Root, "blobs")
+	err := filepath.WalkDir(root, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() || !ValidDigest(entry.Name()) {
+			return err
+		}
+		info, err := entry.Info()
+		if err != nil || info.ModTime().After(cutoff) {
+			return err
+		}
+
+		var count int64
+		if err := db.Model(&models.Blob{}).Where("digest = ?", entry.Name()).Count(&count).Error; err != nil {
+			return err
+		}
+		if count > 0 {
+			return nil
+		}
+		report.OrphanedFiles++
+		report.BytesFreed += info.Size()
+		if dryRun {
+			return nil
+		}
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	})
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+// gcTusUploads drops resumable uploads that expired or were started before
+// cutoff and never finished, together with their staging files, then any
+// staging files whose record is already gone.
+func gcTusUploads(db *gorm.DB, cutoff time.Time, report *GCReport, dryRun bool) error {
+	var stale []models.TusUpload
+	err := db.Where("path = ''").
+		Where("created_at < ? OR expires_at <= ?", cutoff, time.Now()).
+		Find(&stale).Error
+	if err != nil {
+		return err
+	}
+	for _, upload := range stale {
+		report.StaleTusUploads++
+		report.BytesFreed += upload.Offset
+		if dryRun {
+			continue
+		}
+		if err := db.Delete(&upload).Error; err != nil {
+			return err
+		}
+		if err := os.Remove(TusPath(upload.ID.String())); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	return removeOlderFiles(TusPath(""), cutoff, dryRun, func(info fs.FileInfo) (bool, error) {
+		if id, err := uuid.Parse(info.Name()); err == nil {
+			var count int64
+			if err := db.Model(&models.TusUpload{}).Where("id = ?", id).Count(&count).Error; err != nil {
+				return false, err
+			}
+			if count > 0 {
+				return false, nil
+			}
+		}
+		report.StaleTusUploads++
+		report.BytesFreed += info.Size()
+		return true, nil
+	})
+}
+
+// removeOlderFiles removes the files directly in dir that were last modified
+// before cutoff and that stale agrees to, unless dryRun is set. A missing dir
+// holds nothing to remove.
+func removeOlderFiles(dir string, cutoff time.Time, dryRun bool, stale func(info fs.FileInfo) (bool, error)) error {
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		if info.ModTime().After(cutoff) {
+			continue
+		}
+		remove, err := stale(info)
+		if err != nil {
+			return err
+		}
+		if !remove || dryRun {
+			continue
+		}
+		if err := os.Remove(filepath.Join(dir, entry.Name())); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,257 @@
+// internal/storage/metadata.go
+package storage
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/csv"
+	"errors"
+	"io"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Metadata holds the basic facts extracted from an uploaded file. Fields that
+// do not apply to the file's type, or could not be determined, are nil.
+type Metadata struct {
+	PageCount       *int     `json:"page_count,omitempty"`
+	DurationSeconds *float64 `json:"duration_seconds,omitempty"`
+	RowCount        *int     `json:"row_count,omitempty"`
+}
+
+// ExtractMetadata inspects the file at path according to its sniffed type.
+// Extraction is best effort: unsupported or malformed files yield empty
+// metadata rather than an error.
+func ExtractMetadata(path, contentType string) Metadata {
+	file, err := os.Open(path)
+	if err != nil {
+		return Metadata{}
+	}
+	defer file.Close()
+
+	switch {
+	case contentType == "application/pdf":
+		if pages, ok := pdfPageCount(file); ok {
+			return Metadata{PageCount: &pages}
+		}
+	case contentType == "text/csv":
+		if rows, ok := csvRowCount(file); ok {
+			return Metadata{RowCount: &rows}
+		}
+	case strings.HasPrefix(contentType, "audio/"):
+		if seconds, ok := audioDuration(file, contentType); ok {
+			return Metadata{DurationSeconds: &seconds}
+		}
+	}
+	return Metadata{}
+}
+
+var (
+	pdfPagePattern  = regexp.MustCompile(`/Type\s*/Page\b`)
+	pdfCountPattern = regexp.MustCompile(`/Count\s+(\d+)`)
+)
+
+// PDFs are scanned pdfScanChunk bytes at a time. The last pdfScanOverlap
+// bytes of each chunk are scanned again with the next one, so tokens split
+// across chunks are still found; no token the patterns match is that long
+// in practice.
+const (
+	pdfScanChunk   = 64 << 10
+	pdfScanOverlap = 256
+)
+
+// pdfPageCount counts page objects, falling back to the largest /Count in a
+// page tree node when pages live in compressed object streams.
+func pdfPageCount(r io.Reader) (int, bool) {
+	pages, maxCount := 0, 0
+	buf := make([]byte, 0, pdfScanOverlap+pdfScanChunk)
+	for {
+		n, err := io.ReadFull(r, buf[len(buf):cap(buf)])
+		buf = buf[:len(buf)+n]
+		eof := errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF)
+		if err != nil && !eof {
+			return 0, false
+		}
+
+		// Tokens starting in the overlap are counted with the next chunk
+		limit := len(buf)
+		if !eof {
+			limit -= pdfScanOverlap
+		}
+		for _, match := range pdfPagePattern.FindAllIndex(buf, -1) {
+			if match[0] < limit {
+				pages++
+			}
+		}
+		for _, match := range pdfCountPattern.FindAllSubmatchIndex(buf, -1) {
+			if match[0] >= limit {
+				continue
+			}
+			if count, err := strconv.Atoi(string(buf[match[2]:match[3]])); err == nil && count > maxCount {
+				maxCount = count
+			}
+		}
+
+		if eof {
+			break
+		}
+		buf = buf[:copy(buf, buf[limit:])]
+	}
+
+	if pages > 0 {
+		return pages, true
+	}
+	return maxCount, maxCount > 0
+}
+
+func csvRowCount(r io.Reader) (int, bool) {
+	reader := csv.NewReader(bufio.NewReader(r))
+	reader.FieldsPerRecord = -1
+	reader.LazyQuotes = true
+	reader.ReuseRecord = true
+
+	rows := 0
+	for {
+		_, err := reader.Read()
+		if err == io.EOF {
+			return rows, true
+		}
+		if err != nil {
+			return 0, false
+		}
+		rows++
+	}
+}
+
+func audioDuration(r io.ReadSeeker, contentType string) (float64, bool) {
+	switch contentType {
+	case "audio/wav":
+		return wavDuration(r)
+	case "audio/flac":
+		return flacDuration(r)
+	case "audio/mpeg":
+		return mp3Duration(r)
+	}
+	return 0, false
+}
+
+// wavDuration walks the RIFF chunks for the fmt byte rate and data size.
+func wavDuration(r io.Reader) (float64, bool) {
+	header := make([]byte, 12)
+	if _, err := io.ReadFull(r, header); err != nil || string(header[0:4]) != "RIFF" || string(header[8:12]) != "WAVE" {
+		return 0, false
+	}
+
+	var byteRate uint32
+	for {
+		chunk := make([]byte, 8)
+		if _, err := io.ReadFull(r, chunk); err != nil {
+			return 0, false
+		}
+		id := string(chunk[0:4])
+		size := binary.LittleEndian.Uint32(chunk[4:8])
+
+		switch id {
+		case "fmt ":
+			// PCM and WAVE_FORMAT_EXTENSIBLE headers are 16 to 40 bytes; the
+			// size comes from the file, so refuse anything implausible
+			// rather than allocate it
+			if size < 16 || size > 64 {
+				return 0, false
+			}
+			fmtChunk := make([]byte, size+size%2)
+			if _, err := io.ReadFull(r, fmtChunk); err != nil {
+				return 0, false
+			}
+			byteRate = binary.LittleEndian.Uint32(fmtChunk[8:12])
+		case "data":
+			if byteRate == 0 {
+				return 0, false
+			}
+			return float64(size) / float64(byteRate), true
+		default:
+			// Chunks are padded to an even size
+			if _, err := io.CopyN(io.Discard, r, int64(size+size%2)); err != nil {
+				return 0, false
+			}
+		}
+	}
+}
+
+// flacDuration reads total samples and sample rate from STREAMINFO.
+func flacDuration(r io.Reader) (float64, bool) {
+	header := make([]byte, 4+4+34)
+	if _, err := io.ReadFull(r, header); err != nil || string(header[0:4]) != "fLaC" {
+		return 0, false
+	}
+	info := header[8:]
+	sampleRate := uint32(info[10])<<12 | uint32(info[11])<<4 | uint32(info[12])>>4
+	totalSamples := uint64(info[13]&0x0f)<<32 | uint64(binary.BigEndian.Uint32(info[14:18]))
+	if sampleRate == 0 || totalSamples == 0 {
+		return 0, false
+	}
+	return float64(totalSamples) / float64(sampleRate), true
+}
+
+var mp3Bitrates = [2][16]int{
+	// MPEG-1 Layer III
+	{0, 32, 40, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320, 0},
+	// MPEG-2/2.5 Layer III
+	{0, 8, 16, 24, 32, 40, 48, 56, 64, 80, 96, 112, 128, 144, 160, 0},
+}
+
+// mp3Duration estimates the length from the first frame's bitrate, which is
+// exact for constant bitrate files and approximate for VBR ones.
+func mp3Duration(r io.ReadSeeker) (float64, bool) {
+	size, err := r.Seek(0, io.SeekEnd)
+	if err != nil {
+		return 0, false
+	}
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return 0, false
+	}
+
+	head := make([]byte, 64<<10)
+	n, err := io.ReadFull(r, head)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return 0, false
+	}
+	head = head[:n]
+
+	// Skip an ID3v2 tag; its size is a 28-bit syncsafe integer
+	var base int64
+	if bytes.HasPrefix(head, []byte("ID3")) && len(head) >= 10 {
+		base = 10 + int64(int(head[6])<<21|int(head[7])<<14|int(head[8])<<7|int(head[9]))
+		if _, err := r.Seek(base, io.SeekStart); err != nil {
+			return 0, false
+		}
+		head = head[:cap(head)]
+		n, err := io.ReadFull(r, head)
+		if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) {
+			return 0, false
+		}
+		head = head[:n]
+	}
+
+	for i := 0; i+4 <= len(head); i++ {
+		if head[i] != 0xff || head[i+1]&0xe0 != 0xe0 {
+			continue
+		}
+		version := (head[i+1] >> 3) & 0x03
+		layer := (head[i+1] >> 1) & 0x03
+		bitrateIndex := head[i+2] >> 4
+		if version == 1 || layer != 1 || bitrateIndex == 0 || bitrateIndex == 15 {
+			continue
+		}
+		table := 0
+		if version != 3 {
+			table = 1
+		}
+		bitrate := mp3Bitrates[table][bitrateIndex] * 1000
+		return float64(size-base-int64(i)) * 8 / float64(bitrate), true
+	}
+	return 0, false
+}
@@ -0,0 +1,164 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"strings"
+	"testing"
+)
+
+// wavFile is a 16 kHz, 8-bit mono WAV header announcing two seconds of data,
+// with a LIST chunk before the data chunk.
+func wavFile() []byte {
+	var b bytes.Buffer
+	b.WriteString("RIFF")
+	binary.Write(&b, binary.LittleEndian, uint32(0))
+	b.WriteString("WAVE")
+	b.WriteString("fmt ")
+	binary.Write(&b, binary.LittleEndian, uint32(16))
+	binary.Write(&b, binary.LittleEndian, uint16(1))     // PCM
+	binary.Write(&b, binary.LittleEndian, uint16(1))     // channels
+	binary.Write(&b, binary.LittleEndian, uint32(16000)) // sample rate
+	binary.Write(&b, binary.LittleEndian, uint32(16000)) // byte rate
+	binary.Write(&b, binary.LittleEndian, uint16(1))     // block align
+	binary.Write(&b, binary.LittleEndian, uint16(8))     // bits per sample
+	b.WriteString("LIST")
+	binary.Write(&b, binary.LittleEndian, uint32(3))
+	b.WriteString("abc\x00")
+	b.WriteString("data")
+	binary.Write(&b, binary.LittleEndian, uint32(32000))
+	return b.Bytes()
+}
+
+// flacFile is a FLAC stream header with a STREAMINFO block for two seconds
+// at 44.1 kHz.
+func flacFile() []byte {
+	info := make([]byte, 34)
+	sampleRate, totalSamples := uint32(44100), uint32(88200)
+	info[10] = byte(sampleRate >> 12)
+	info[11] = byte(sampleRate >> 4)
+	info[12] = byte(sampleRate<<4) | 0x02 // stereo
+	binary.BigEndian.PutUint32(info[14:18], totalSamples)
+	return append([]byte("fLaC\x80\x00\x00\x22"), info...)
+}
+
+// mp3File is an ID3v2 tag followed by one second of 128 kbit/s MPEG-1
+// Layer III frames.
+func mp3File() []byte {
+	tag := []byte("ID3\x04\x00\x00\x00\x00\x00\x0a0123456789")
+	frames := make([]byte, 16000)
+	copy(frames, []byte{0xff, 0xfb, 0x90, 0x00})
+	return append(tag, frames...)
+}
+
+func TestAudioDuration(t *testing.T) {
+	tests := []struct {
+		contentType string
+		data        []byte
+		want        float64
+	}{
+		{"audio/wav", wavFile(), 2},
+		{"audio/flac", flacFile(), 2},
+		{"audio/mpeg", mp3File(), 1},
+	}
+	for _, tt := range tests {
+		got, ok := audioDuration(bytes.NewReader(tt.data), tt.contentType)
+		if !ok || got != tt.want {
+			t.Errorf("%s: duration = %v, %v; want %v", tt.contentType, got, ok, tt.want)
+		}
+	}
+}
+
+func TestAudioDurationTruncated(t *testing.T) {
+	tests := []struct {
+		contentType string
+		data        []byte
+		// header is how many leading bytes the parser needs
+		header int
+	}{
+		{"audio/wav", wavFile(), len(wavFile())},
+		{"audio/flac", flacFile(), len(flacFile())},
+		{"audio/mpeg", mp3File(), 20 + 3},
+	}
+	for _, tt := range tests {
+		for n := 0; n < tt.header; n++ {
+			if got, ok := audioDuration(bytes.NewReader(tt.data[:n]), tt.contentType); ok {
+				t.Errorf("%s truncated to %d bytes: duration = %v", tt.contentType, n, got)
+			}
+		}
+	}
+}
+
+func TestWAVDurationRejectsOversizedFmtChunk(t *testing.T) {
+	data := wavFile()
+	binary.LittleEndian.PutUint32(data[16:20], 1<<31)
+	if got, ok := wavDuration(bytes.NewReader(data)); ok {
+		t.Fatalf("duration = %v", got)
+	}
+}
+
+func TestMP3DurationTagPastEnd(t *testing.T) {
+	// The tag claims to run far past the end of the file
+	data := []byte("ID3\x04\x00\x00\x7f\x7f\x7f\x7f\xff\xfb\x90\x00")
+	if got, ok := mp3Duration(bytes.NewReader(data)); ok {
+		t.Fatalf("duration = %v", got)
+	}
+}
+
+func TestPDFPageCount(t *testing.T) {
+	page := "1 0 obj << /Type /Page /Parent 2 0 R >> endobj\n"
+	tests := []struct {
+		name   string
+		data   string
+		want   int
+		wantOK bool
+	}{
+		{"pages", "%PDF-1.7\n" + strings.Repeat(page, 3) + "2 0 obj << /Type /Pages /Count 3 >> endobj\n", 3, true},
+		{"page tree only", "%PDF-1.7\n2 0 obj << /Type /Pages /Kids [] /Count 12 >> endobj\n", 12, true},
+		{"truncated in a token", "%PDF-1.7\n1 0 obj << /Type /Pa", 0, false},
+		{"truncated in the header", "%PD", 0, false},
+		{"empty", "", 0, false},
+	}
+	for _, tt := range tests {
+		got, ok := pdfPageCount(strings.NewReader(tt.data))
+		if got != tt.want || ok != tt.wantOK {
+			t.Errorf("%s: pages = %d, %v; want %d, %v", tt.name, got, ok, tt.want, tt.wantOK)
+		}
+	}
+}
+
+func TestPDFPageCountAcrossChunks(t *testing.T) {
+	// Place tokens so they straddle the boundary between the first two chunks
+	for _, offset := range []int{1, 5, 10, pdfScanOverlap - 1, pdfScanOverlap, pdfScanOverlap + 1} {
+		var data bytes.Buffer
+		data.WriteString(strings.Repeat(" ", pdfScanOverlap+pdfScanChunk-offset))
+		data.WriteString("/Type /Page ")
+		data.WriteString(strings.Repeat(" ", 3*pdfScanChunk))
+		data.WriteString("/Type /Pages /Count 2 /Type /Page")
+
+		got, ok := pdfPageCount(&data)
+		if !ok || got != 2 {
+			t.Errorf("token %d bytes before the boundary: pages = %d, %v; want 2", offset, got, ok)
+		}
+	}
+}
+
+// oneByteReader returns a byte per Read, like a slow network stream.
+type oneByteReader struct {
+	r io.Reader
+}
+
+func (o oneByteReader) Read(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	return o.r.Read(p[:1])
+}
+
+func TestPDFPageCountShortReads(t *testing.T) {
+	data := strings.Repeat(" ", pdfScanChunk) + "/Type /Page /Type /Page"
+	if got, ok := pdfPageCount(oneByteReader{strings.NewReader(data)}); !ok || got != 2 {
+		t.Fatalf("pages = %d, %v; want 2", got, ok)
+	}
+}
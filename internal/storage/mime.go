@@ -0,0 +1,85 @@
+// internal/storage/mime.go
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"github.com/gabriel-vasile/mimetype"
+	"io"
+	"strings"
+)
+
+// TypeRule allows uploads whose sniffed MIME type matches Pattern ("image/*"
+// or an exact type) up to MaxSize bytes.
+type TypeRule struct {
+	Pattern string
+	MaxSize int64
+}
+
+// DefaultAllowedTypes is used when config.UploadsConfig has no AllowedTypes.
+var DefaultAllowedTypes = []TypeRule{
+	{Pattern: "image/*", MaxSize: 10 << 20},
+	{Pattern: "application/pdf", MaxSize: 25 << 20},
+	{Pattern: "audio/*", MaxSize: 50 << 20},
+	{Pattern: "text/csv", MaxSize: 5 << 20},
+	{Pattern: "application/zip", MaxSize: 50 << 20},
+}
+
+var (
+	ErrTypeNotAllowed = errors.New("file type not allowed")
+	ErrFileTooLarge   = errors.New("file too large for its type")
+)
+
+// allowedTypes is the allowlist CheckType applies; set by SetAllowedTypes.
+var allowedTypes = DefaultAllowedTypes
+
+// SetAllowedTypes replaces the upload allowlist. Empty restores
+// DefaultAllowedTypes.
+func SetAllowedTypes(rules []TypeRule) {
+	if len(rules) == 0 {
+		rules = DefaultAllowedTypes
+	}
+	allowedTypes = rules
+}
+
+// AllowedTypes returns the upload allowlist.
+func AllowedTypes() []TypeRule {
+	return allowedTypes
+}
+
+// Matches reports whether the rule covers contentType.
+func (r TypeRule) Matches(contentType string) bool {
+	if prefix, ok := strings.CutSuffix(r.Pattern, "/*"); ok {
+		return strings.HasPrefix(contentType, prefix+"/")
+	}
+	return contentType == r.Pattern
+}
+
+// CheckType returns ErrTypeNotAllowed or ErrFileTooLarge (wrapped with
+// details) unless contentType is on the allowlist and size fits its limit.
+func CheckType(contentType string, size int64) error {
+	for _, rule := range AllowedTypes() {
+		if rule.Matches(contentType) {
+			if size > rule.MaxSize {
+				return fmt.Errorf("%w: %s is limited to %d bytes", ErrFileTooLarge, contentType, rule.MaxSize)
+			}
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: %s", ErrTypeNotAllowed, contentType)
+}
+
+// Sniff detects the MIME type of r from its content rather than trusting the
+// client supplied Content-Type, then rewinds r.
+func Sniff(r io.ReadSeeker) (string, error) {
+	detected, err := mimetype.DetectReader(r)
+	if err != nil {
+		return "", err
+	}
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+	// Drop parameters such as "; charset=utf-8"
+	contentType, _, _ := strings.Cut(detected.String(), ";")
+	return contentType, nil
+}
@@ -0,0 +1,83 @@
+// internal/tracing/gorm.go
+package tracing
+
+import (
+	"errors"
+
+	"go.opentelemetry.io/otel/attribute"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+	"gorm.io/gorm"
+)
+
+const gormSpanKey = "tracing:span"
+
+// RegisterGORM adds a span for every query run with a context that is part
+// of a trace (db.WithContext(ctx)). Queries from background loops and code
+// without a request context are not traced, so they do not show up as
+// traces of their own.
+func RegisterGORM(db *gorm.DB) error {
+	system := db.Dialector.Name()
+	if system == "postgres" {
+		system = "postgresql"
+	}
+
+	type registration struct {
+		before, after func(string, func(*gorm.DB)) error
+		operation     string
+	}
+	callbacks := db.Callback()
+	registrations := []registration{
+		{callbacks.Create().Before("gorm:create").Register, callbacks.Create().After("gorm:create").Register, "create"},
+		{callbacks.Query().Before("gorm:query").Register, callbacks.Query().After("gorm:query").Register, "query"},
+		{callbacks.Update().Before("gorm:update").Register, callbacks.Update().After("gorm:update").Register, "update"},
+		{callbacks.Delete().Before("gorm:delete").Register, callbacks.Delete().After("gorm:delete").Register, "delete"},
+		{callbacks.Row().Before("gorm:row").Register, callbacks.Row().After("gorm:row").Register, "row"},
+		{callbacks.Raw().Before("gorm:raw").Register, callbacks.Raw().After("gorm:raw").Register, "raw"},
+	}
+	for _, r := range registrations {
+		operation := r.operation
+		if err := r.before("tracing:before_"+operation, func(tx *gorm.DB) {
+			startQuery(tx, system, operation)
+		}); err != nil {
+			return err
+		}
+		if err := r.after("tracing:after_"+operation, endQuery); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func startQuery(tx *gorm.DB, system, operation string) {
+	ctx := tx.Statement.Context
+	if ctx == nil || !trace.SpanContextFromContext(ctx).IsValid() {
+		return
+	}
+	name := "gorm." + operation
+	if tx.Statement.Table != "" {
+		name += " " + tx.Statement.Table
+	}
+	_, span := tracer.Start(ctx, name,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(semconv.DBSystemKey.String(system), attribute.String("db.sql.table", tx.Statement.Table)),
+	)
+	tx.InstanceSet(gormSpanKey, span)
+}
+
+func endQuery(tx *gorm.DB) {
+	value, ok := tx.InstanceGet(gormSpanKey)
+	if !ok {
+		return
+	}
+	span := value.(trace.Span)
+	span.SetAttributes(
+		semconv.DBQueryText(tx.Statement.SQL.String()),
+		attribute.Int64("db.rows_affected", tx.Statement.RowsAffected),
+	)
+	err := tx.Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		err = nil
+	}
+	End(span, err)
+}
@@ -0,0 +1,69 @@
+// internal/trash/trash.go
+package trash
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"NoteApi/internal/audit"
+	"NoteApi/internal/models"
+	"NoteApi/internal/repository"
+
+	"gorm.io/gorm"
+)
+
+// batchSize is how many trashed notes Purge loads at a time.
+const batchSize = 100
+
+// trashedBefore selects notes moved to the trash before cutoff.
+func trashedBefore(db *gorm.DB, cutoff time.Time) *gorm.DB {
+	return db.Model(&models.Note{}).
+		Scopes(models.Trashed).
+		Where("last_remove < ?", cutoff)
+}
+
+// Count returns how many notes Purge would delete.
+func Count(db *gorm.DB, cutoff time.Time) (int64, error) {
+	var count int64
+	err := trashedBefore(db, cutoff).Count(&count).Error
+	return count, err
+}
+
+// Purge permanently deletes the notes moved to the trash before cutoff and
+// releases their dashboard images and attachments. Each deletion is audited
+// as done by actor. It stops at the first note it cannot delete; files that
+// cannot be released are reported after the rest have been purged. Titles
+// and contents are never loaded, so no note encryption key is needed.
+func Purge(ctx context.Context, db *gorm.DB, notes repository.NoteRepository, cutoff time.Time, actor audit.Actor) (int, error) {
+	purged := 0
+	var releaseErrs []error
+	for {
+		var batch []models.Note
+		err := trashedBefore(db.WithContext(ctx), cutoff).
+			Select("id, user_id, workspace_id, dashboard_path, last_remove").
+			Order("last_remove").
+			Limit(batchSize).
+			Find(&batch).Error
+		if err != nil {
+			return purged, err
+		}
+		if len(batch) == 0 {
+			return purged, errors.Join(releaseErrs...)
+		}
+
+		for _, note := range batch {
+			if err := notes.PurgeNote(ctx, note, actor); err != nil {
+				return purged, fmt.Errorf("note %s: %w", note.ID, err)
+			}
+			purged++
+			if err := notes.ReleaseUpload(ctx, note.DashboardPath); err != nil {
+				releaseErrs = append(releaseErrs, fmt.Errorf("note %s: %w", note.ID, err))
+			}
+			if err := notes.ReleaseAttachments(ctx, note.ID); err != nil {
+				releaseErrs = append(releaseErrs, fmt.Errorf("note %s: %w", note.ID, err))
+			}
+		}
+	}
+}
@@ -0,0 +1,354 @@
+// internal/workspace/workspace.go
+package workspace
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"strings"
+	"time"
+
+	"NoteApi/internal/auth"
+	"NoteApi/internal/config"
+	"NoteApi/internal/models"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const DefaultInvitationTTL = 7 * 24 * time.Hour
+
+var (
+	ErrNotMember         = errors.New("not a member of the workspace")
+	ErrForbidden         = errors.New("workspace role does not allow this")
+	ErrNoteNotFound      = errors.New("note not found")
+	ErrInvalidRole       = errors.New("invalid workspace role")
+	ErrLastOwner         = errors.New("a workspace needs at least one owner")
+	ErrInvitationInvalid = errors.New("invitation is invalid, expired or already used")
+	ErrSearchDisabled    = errors.New("search is disabled while note encryption is enabled")
+	ErrSearchNotIndexed  = errors.New("search has no index to rebuild on this database")
+)
+
+var roleRank = map[string]int{
+	models.RoleViewer: 1,
+	models.RoleEditor: 2,
+	models.RoleAdmin:  3,
+	models.RoleOwner:  4,
+}
+
+// ValidRole reports whether role is one of the workspace roles.
+func ValidRole(role string) bool {
+	_, ok := roleRank[role]
+	return ok
+}
+
+// RoleAtLeast reports whether role grants everything min does.
+func RoleAtLeast(role, min string) bool {
+	return roleRank[role] >= roleRank[min]
+}
+
+// CanGrant reports whether a member with granter's role may give someone
+// role, or change the role of someone who currently holds it. Owners manage
+// everyone; admins manage editors and viewers.
+func CanGrant(granter, role string) bool {
+	if granter == models.RoleOwner {
+		return true
+	}
+	return granter == models.RoleAdmin && roleRank[role] < roleRank[models.RoleAdmin]
+}
+
+// Role returns userID's role in the workspace, or "" when not a member.
+func Role(db *gorm.DB, workspaceID, userID uuid.UUID) (string, error) {
+	var member models.WorkspaceMember
+	err := db.Where("workspace_id = ? AND user_id = ?", workspaceID, userID).Limit(1).Find(&member).Error
+	if err != nil {
+		return "", err
+	}
+	return member.Role, nil
+}
+
+// Require returns userID's role, or ErrNotMember or ErrForbidden unless it is
+// at least min.
+func Require(db *gorm.DB, workspaceID, userID uuid.UUID, min string) (string, error) {
+	role, err := Role(db, workspaceID, userID)
+	if err != nil {
+		return "", err
+	}
+	if role == "" {
+		return "", ErrNotMember
+	}
+	if !RoleAtLeast(role, min) {
+		return role, ErrForbidden
+	}
+	return role, nil
+}
+
+// MemberIDs lists every member of the workspace.
+func MemberIDs(db *gorm.DB, workspaceID uuid.UUID) ([]uuid.UUID, error) {
+	var ids []uuid.UUID
+	err := db.Model(&models.WorkspaceMember{}).
+		Where("workspace_id = ?", workspaceID).
+		Pluck("user_id", &ids).Error
+	return ids, err
+}
+
+// Create makes a workspace with userID as its owner.
+func Create(db *gorm.DB, name string, userID uuid.UUID) (models.Workspace, error) {
+	workspace := models.Workspace{Name: name, CreatedBy: userID}
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&workspace).Error; err != nil {
+			return err
+		}
+		owner := models.WorkspaceMember{WorkspaceID: workspace.ID, UserID: userID, Role: models.RoleOwner}
+		return tx.Create(&owner).Error
+	})
+	return workspace, err
+}
+
+// FindNote loads a note userID may act on with at least role min. Personal
+// notes are only visible to their owner, who may do anything with them.
+// Notes the user cannot see at all, and notes in the trash, are reported as
+// ErrNoteNotFound.
+func FindNote(db *gorm.DB, noteID string, userID uuid.UUID, min string) (models.Note, error) {
+	return findNote(db, models.NotTrashed, noteID, userID, min)
+}
+
+// FindTrashedNote is FindNote for notes in the trash.
+func FindTrashedNote(db *gorm.DB, noteID string, userID uuid.UUID, min string) (models.Note, error) {
+	return findNote(db, models.Trashed, noteID, userID, min)
+}
+
+func findNote(db *gorm.DB, scope func(*gorm.DB) *gorm.DB, noteID string, userID uuid.UUID, min string) (models.Note, error) {
+	var note models.Note
+	if err := db.Scopes(scope).Where("id = ?", noteID).First(&note).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return models.Note{}, ErrNoteNotFound
+		}
+		return models.Note{}, err
+	}
+
+	if note.WorkspaceID == nil {
+		if note.UserID != userID {
+			return models.Note{}, ErrNoteNotFound
+		}
+		return note, nil
+	}
+
+	if _, err := Require(db, *note.WorkspaceID, userID, min); err != nil {
+		if errors.Is(err, ErrNotMember) {
+			return models.Note{}, ErrNoteNotFound
+		}
+		return models.Note{}, err
+	}
+	return note, nil
+}
+
+// Audience lists the users who should hear about changes to note: its owner,
+// or every member of its workspace.
+func Audience(db *gorm.DB, note models.Note) []uuid.UUID {
+	if note.WorkspaceID == nil {
+		return []uuid.UUID{note.UserID}
+	}
+	ids, err := MemberIDs(db, *note.WorkspaceID)
+	if err != nil {
+		return nil
+	}
+	return ids
+}
+
+// SetRole changes a member's role, keeping at least one owner.
+func SetRole(db *gorm.DB, workspaceID, userID uuid.UUID, role string) error {
+	if !ValidRole(role) {
+		return ErrInvalidRole
+	}
+	return db.Transaction(func(tx *gorm.DB) error {
+		var member models.WorkspaceMember
+		if err := lockMember(tx, workspaceID, userID, &member); err != nil {
+			return err
+		}
+		if member.Role == models.RoleOwner && role != models.RoleOwner {
+			if err := ensureAnotherOwner(tx, workspaceID); err != nil {
+				return err
+			}
+		}
+		return tx.Model(&member).Update("role", role).Error
+	})
+}
+
+// RemoveMember takes userID out of the workspace, keeping at least one owner.
+func RemoveMember(db *gorm.DB, workspaceID, userID uuid.UUID) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		var member models.WorkspaceMember
+		if err := lockMember(tx, workspaceID, userID, &member); err != nil {
+			return err
+		}
+		if member.Role == models.RoleOwner {
+			if err := ensureAnotherOwner(tx, workspaceID); err != nil {
+				return err
+			}
+		}
+		return tx.Where("workspace_id = ? AND user_id = ?", workspaceID, userID).Delete(&models.WorkspaceMember{}).Error
+	})
+}
+
+// lockMember loads a membership and locks every owner row of the workspace,
+// so concurrent demotions cannot leave it without an owner.
+func lockMember(tx *gorm.DB, workspaceID, userID uuid.UUID, member *models.WorkspaceMember) error {
+	var owners []models.WorkspaceMember
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("workspace_id = ? AND role = ?", workspaceID, models.RoleOwner).
+		Find(&owners).Error; err != nil {
+		return err
+	}
+	err := tx.Where("workspace_id = ? AND user_id = ?", workspaceID, userID).First(member).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return ErrNotMember
+	}
+	return err
+}
+
+func ensureAnotherOwner(tx *gorm.DB, workspaceID uuid.UUID) error {
+	var owners int64
+	if err := tx.Model(&models.WorkspaceMember{}).
+		Where("workspace_id = ? AND role = ?", workspaceID, models.RoleOwner).
+		Count(&owners).Error; err != nil {
+		return err
+	}
+	if owners <= 1 {
+		return ErrLastOwner
+	}
+	return nil
+}
+
+// CreateInvitation stores an invitation and returns its code, which is only
+// shown once. inviteeID, when set, restricts who may accept it.
+func CreateInvitation(db *gorm.DB, workspaceID, invitedBy uuid.UUID, role string, inviteeID *uuid.UUID, ttl time.Duration) (string, models.WorkspaceInvitation, error) {
+	if !ValidRole(role) {
+		return "", models.WorkspaceInvitation{}, ErrInvalidRole
+	}
+	if ttl <= 0 {
+		ttl = DefaultInvitationTTL
+	}
+
+	secret := make([]byte, 24)
+	if _, err := rand.Read(secret); err != nil {
+		return "", models.WorkspaceInvitation{}, err
+	}
+	code := base64.RawURLEncoding.EncodeToString(secret)
+
+	invitation := models.WorkspaceInvitation{
+		WorkspaceID: workspaceID,
+		Role:        role,
+		CodeHash:    auth.HashAPIToken(code),
+		InvitedBy:   invitedBy,
+		InviteeID:   inviteeID,
+		ExpiresAt:   time.Now().Add(ttl),
+	}
+	if err := db.Create(&invitation).Error; err != nil {
+		return "", models.WorkspaceInvitation{}, err
+	}
+	return code, invitation, nil
+}
+
+// AcceptInvitation adds userID to the invitation's workspace. Existing members
+// keep their role if it is higher than the invited one.
+func AcceptInvitation(db *gorm.DB, code string, userID uuid.UUID) (models.WorkspaceMember, error) {
+	var member models.WorkspaceMember
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var invitation models.WorkspaceInvitation
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("code_hash = ?", auth.HashAPIToken(code)).
+			First(&invitation).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrInvitationInvalid
+		}
+		if err != nil {
+			return err
+		}
+
+		now := time.Now()
+		if invitation.AcceptedAt != nil || invitation.RevokedAt != nil || now.After(invitation.ExpiresAt) {
+			return ErrInvitationInvalid
+		}
+		if invitation.InviteeID != nil && *invitation.InviteeID != userID {
+			return ErrInvitationInvalid
+		}
+
+		err = tx.Where("workspace_id = ? AND user_id = ?", invitation.WorkspaceID, userID).First(&member).Error
+		switch {
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			member = models.WorkspaceMember{WorkspaceID: invitation.WorkspaceID, UserID: userID, Role: invitation.Role}
+			if err := tx.Create(&member).Error; err != nil {
+				return err
+			}
+		case err != nil:
+			return err
+		case !RoleAtLeast(member.Role, invitation.Role):
+			member.Role = invitation.Role
+			if err := tx.Model(&member).Update("role", member.Role).Error; err != nil {
+				return err
+			}
+		}
+
+		return tx.Model(&invitation).Updates(map[string]interface{}{"accepted_by": userID, "accepted_at": now}).Error
+	})
+	return member, err
+}
+
+// searchDocument is the text SearchNotes matches on Postgres. It must stay
+// identical to the expression of the idx_notes_search index, or searches stop
+// using the index.
+const searchDocument = "to_tsvector('simple', coalesce(title, '') || ' ' || coalesce(content, ''))"
+
+// SearchNotes runs a full-text search over a workspace's notes, best matches
+// first. Encrypted notes cannot be searched in the database, so search is
+// turned off while encryption is enabled.
+func SearchNotes(db *gorm.DB, workspaceID uuid.UUID, query string, limit int) ([]models.Note, error) {
+	if models.NoteCipher != nil {
+		return nil, ErrSearchDisabled
+	}
+	if db.Dialector.Name() == config.DriverSQLite {
+		return searchNotesLike(db, workspaceID, query, limit)
+	}
+
+	var notes []models.Note
+	err := db.
+		Scopes(models.NotTrashed).
+		Where("workspace_id = ?", workspaceID).
+		Where(searchDocument+" @@ plainto_tsquery('simple', ?)", query).
+		Order(clause.OrderBy{Expression: clause.Expr{SQL: "ts_rank(" + searchDocument + ", plainto_tsquery('simple', ?)) DESC", Vars: []interface{}{query}}}).
+		Limit(limit).
+		Find(&notes).Error
+	return notes, err
+}
+
+// searchNotesLike is the search used without Postgres full-text support:
+// notes containing every word of query, most recently changed first.
+func searchNotesLike(db *gorm.DB, workspaceID uuid.UUID, query string, limit int) ([]models.Note, error) {
+	escape := strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
+	db = db.Scopes(models.NotTrashed).Where("workspace_id = ?", workspaceID)
+	for _, word := range strings.Fields(query) {
+		pattern := "%" + escape.Replace(word) + "%"
+		db = db.Where(`(title LIKE ? ESCAPE '\' OR content LIKE ? ESCAPE '\')`, pattern, pattern)
+	}
+
+	var notes []models.Note
+	err := db.Order("last_changed DESC").Limit(limit).Find(&notes).Error
+	return notes, err
+}
+
+// ReindexSearch rebuilds the full-text index without blocking searches or
+// writes, then refreshes the planner statistics for notes. SQLite searches
+// without an index, so there is nothing to rebuild there. It needs no note
+// encryption key; while encryption is enabled the index goes unused.
+func ReindexSearch(db *gorm.DB) error {
+	if db.Dialector.Name() != config.DriverPostgres {
+		return ErrSearchNotIndexed
+	}
+
+	if err := db.Exec("REINDEX INDEX CONCURRENTLY idx_notes_search").Error; err != nil {
+		return err
+	}
+	return db.Exec("ANALYZE notes").Error
+}
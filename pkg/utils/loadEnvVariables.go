@@ -1,13 +1,17 @@
 package utils
 
 import(
+    "errors"
     "log"
+    "os"
     "github.com/joho/godotenv"
 )
 
+// LoadEnv reads a .env file when there is one. Containers usually set the
+// environment directly, so a missing file is not an error.
 func LoadEnv() {
     err := godotenv.Load()
-    if err != nil {
-        log.Fatal("Error loading .env file")
+    if err != nil && !errors.Is(err, os.ErrNotExist) {
+        log.Printf("Error loading .env file: %v", err)
     }
 }